@@ -8,11 +8,15 @@ import (
 	"time"
 
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
+	comfyapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/comfy_api"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
-	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/consts"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/preprocess"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/userservice"
 	reqqueue "github.com/kanootoko/stable-diffusion-telegram-bot/internal/req_queue"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
 	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
@@ -39,14 +43,93 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	sdApi := sdapi.SdAPIType{SdHost: params.StableDiffusionApiHost}
+	var sdApi sdapi.Backend
+	var a1111Api *sdapi.SdAPIType
+	if params.Backend == "comfyui" {
+		comfyApi, err := comfyapi.NewComfyAPI(params.StableDiffusionApiHost, params.ComfyUIRenderWorkflow, params.ComfyUIUpscaleWorkflow)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		sdApi = comfyApi
+	} else {
+		a1111Api = &sdapi.SdAPIType{SdHost: params.StableDiffusionApiHost}
+		if caps, err := a1111Api.DetectCapabilities(ctx); err != nil {
+			fmt.Println("  can't detect api capabilities, assuming latest AUTOMATIC1111:", err)
+		} else {
+			fmt.Println("Detected api", caps)
+		}
+		sdApi = a1111Api
+	}
+	sdApi = sdapi.NewCatalog(sdApi, params.CatalogTTL)
+
+	var safetyFilter *safety.Filter
+	if params.SafetyRules != "" || params.NSFWClassifier != "" {
+		var err error
+		if safetyFilter, err = safety.NewFilter(params.SafetyRules, params.NSFWClassifier, params.NSFWThreshold); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+	}
+
+	chatSettings, err := chatsettings.NewStore(params.ChatSettings)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	userLanguages, err := i18n.NewStore(params.UserLanguages)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	nameAliases, err := logic.LoadNameAliases(params.NameAliases)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	reqQueue := reqqueue.ReqQueue{
+		ProcessTimeout: params.ProcessTimeout,
+		OOMRecovery:    params.OOMRecovery,
+		StreamBatches:  params.StreamBatches,
+		Safety:         safetyFilter,
+		AdminUserIDs:   params.AdminUserIDs,
+		ChatSettings:   chatSettings,
+	}
+	var promptPreprocessors []preprocess.PromptPreprocessor
+	if params.TranslateApiHost != "" {
+		promptPreprocessors = append(promptPreprocessors, &preprocess.Translator{
+			Host:   params.TranslateApiHost,
+			APIKey: params.TranslateApiKey,
+			Target: params.TranslateTarget,
+		})
+	}
+
+	var enhancer preprocess.PromptPreprocessor
+	if params.EnhanceApiHost != "" {
+		enhancer = &preprocess.Enhancer{
+			Host:         params.EnhanceApiHost,
+			APIKey:       params.EnhanceApiKey,
+			Model:        params.EnhanceModel,
+			SystemPrompt: params.EnhanceSystemPrompt,
+			Timeout:      params.EnhanceTimeout,
+		}
+	}
 
-	reqQueue := reqqueue.ReqQueue{ProcessTimeout: params.ProcessTimeout}
 	cmdHandler := logic.NewCmdHandler(
-		&sdApi,
+		sdApi,
 		&reqQueue,
 		params.Defaults,
+		params.Limits,
 		userservice.NewUserServiceStatic(params.AllowedUserIDs, params.AllowedGroupIDs, params.AdminUserIDs),
+		promptPreprocessors,
+		enhancer,
+		safetyFilter,
+		chatSettings,
+		userLanguages,
+		nameAliases,
 	)
 
 	telegramBot, err := telegram.NewBot(params.BotToken, cmdHandler.GetDefaultHandler())
@@ -56,20 +139,25 @@ func main() {
 	}
 
 	cmdHandler.AddHandlers(telegramBot)
+	cmdHandler.RegisterCommands(ctx, params.AdminUserIDs)
 
-	reqQueue.Init(ctx, &sdApi, telegramBot)
+	reqQueue.Init(ctx, sdApi, telegramBot)
 
-	verStr, _ := sdapi.VersionCheckGetStr(ctx, params.StableDiffusionApiHost)
-	telegramBot.SendTextToAdmins(ctx, params.AdminUserIDs, consts.BotStartedToAdminsStr+internal.Version+", "+verStr)
+	if a1111Api != nil && a1111Api.Capabilities().Flavor == sdapi.FlavorAUTOMATIC1111 {
+		verStr, _ := sdapi.VersionCheckGetStr(ctx, params.StableDiffusionApiHost)
+		telegramBot.SendTextToAdmins(ctx, params.AdminUserIDs, i18n.English.T(i18n.BotStartedToAdmins, internal.Version, verStr))
 
-	go func() {
-		for {
-			time.Sleep(24 * time.Hour)
-			if s, updateNeededOrError := sdapi.VersionCheckGetStr(ctx, params.StableDiffusionApiHost); updateNeededOrError {
-				telegramBot.SendTextToAdmins(ctx, params.AdminUserIDs, s)
+		go func() {
+			for {
+				time.Sleep(24 * time.Hour)
+				if s, updateNeededOrError := sdapi.VersionCheckGetStr(ctx, params.StableDiffusionApiHost); updateNeededOrError {
+					telegramBot.SendTextToAdmins(ctx, params.AdminUserIDs, s)
+				}
 			}
-		}
-	}()
+		}()
+	} else {
+		telegramBot.SendTextToAdmins(ctx, params.AdminUserIDs, i18n.English.T(i18n.BotStartedToAdmins, internal.Version, sdApi.Capabilities()))
+	}
 
 	telegramBot.Start(ctx)
 }
@@ -0,0 +1,151 @@
+package chatsettings
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
+)
+
+// Value for resetting a setting to the bot default.
+const resetValue = "reset"
+
+// Minimum progress update interval, to not hit the Telegram message edit rate limits.
+const minProgressIntervalSeconds = 2
+
+// Names of the settings which can be changed with Update.
+var Keys = []string{"models", "max-width", "max-height", "max-steps", "max-cnt", "plain-messages",
+	"mentions", "progress-interval", "spoiler-mode", "negative"}
+
+// Parses a chat maximum, which can only lower the global maximum and can't go below the global
+// minimum.
+func parseMax(value string, globalMin, globalMax int) (int, error) {
+	if value == resetValue {
+		return 0, nil
+	}
+	lo := max(globalMin, 1)
+	v, err := strconv.Atoi(value)
+	if err != nil || v < lo || v > globalMax {
+		return 0, i18n.Errorf(i18n.ChatSettingsInvalidMax, lo, globalMax)
+	}
+	return v, nil
+}
+
+func parseOnOff(value string, resetTo bool) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "1":
+		return true, nil
+	case "off", "false", "0":
+		return false, nil
+	case resetValue:
+		return resetTo, nil
+	default:
+		return false, i18n.Errorf(i18n.ChatSettingsInvalidOnOff)
+	}
+}
+
+// Changes the setting with the given key. Maxima are checked against the global limits. Models
+// are not checked here, as it needs the backend.
+func (s *Settings) Update(key, value string, limits config.GenerationLimits) (err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return i18n.Errorf(i18n.ChatSettingsMissingValue)
+	}
+	switch key {
+	case "models":
+		s.AllowedModels = nil
+		if value != resetValue && value != "all" {
+			for _, m := range strings.Split(value, ",") {
+				if m = strings.TrimSpace(m); m != "" {
+					s.AllowedModels = append(s.AllowedModels, m)
+				}
+			}
+		}
+	case "max-width":
+		s.MaxWidth, err = parseMax(value, limits.MinWidth, limits.MaxWidth)
+	case "max-height":
+		s.MaxHeight, err = parseMax(value, limits.MinHeight, limits.MaxHeight)
+	case "max-steps":
+		s.MaxSteps, err = parseMax(value, limits.MinSteps, limits.MaxSteps)
+	case "max-cnt":
+		s.MaxCnt, err = parseMax(value, limits.MinCnt, limits.MaxCnt)
+	case "plain-messages":
+		s.PlainMessages, err = parseOnOff(value, false)
+	case "mentions":
+		var enabled bool
+		enabled, err = parseOnOff(value, true)
+		s.MentionsDisabled = !enabled
+	case "progress-interval":
+		if value == resetValue {
+			s.ProgressIntervalSeconds = 0
+			break
+		}
+		v, convErr := strconv.Atoi(strings.TrimSuffix(value, "s"))
+		if convErr != nil || v < minProgressIntervalSeconds {
+			return i18n.Errorf(i18n.ChatSettingsInvalidInterval, minProgressIntervalSeconds)
+		}
+		s.ProgressIntervalSeconds = v
+	case "spoiler-mode":
+		if value == resetValue {
+			s.SpoilerMode = ""
+			break
+		}
+		if !slices.Contains([]string{SpoilerModeAll, string(safety.ActionSpoiler), string(safety.ActionBlur), string(safety.ActionWithhold)}, value) {
+			return i18n.Errorf(i18n.ChatSettingsInvalidSpoilerMode)
+		}
+		s.SpoilerMode = value
+	case "negative":
+		if value == resetValue {
+			s.NegativePrompt = ""
+		} else {
+			s.NegativePrompt = value
+		}
+	default:
+		return i18n.Errorf(i18n.ChatSettingsUnknown, key, strings.Join(Keys, ", "))
+	}
+	return err
+}
+
+func orDefault[T comparable](v T, defaultStr string) string {
+	var zero T
+	if v == zero {
+		return defaultStr
+	}
+	return fmt.Sprint(v)
+}
+
+func (s Settings) String() string {
+	models := "all"
+	if len(s.AllowedModels) > 0 {
+		models = strings.Join(s.AllowedModels, ", ")
+	}
+	plainMessages := "off"
+	if s.PlainMessages {
+		plainMessages = "on"
+	}
+	mentions := "on"
+	if s.MentionsDisabled {
+		mentions = "off"
+	}
+	progressInterval := "default"
+	if s.ProgressIntervalSeconds > 0 {
+		progressInterval = fmt.Sprint(s.ProgressIntervalSeconds, "s")
+	}
+	return fmt.Sprintf("models: %s\nmax-width: %s\nmax-height: %s\nmax-steps: %s\nmax-cnt: %s\n"+
+		"plain-messages: %s\nmentions: %s\nprogress-interval: %s\nspoiler-mode: %s\nnegative: %s",
+		models,
+		orDefault(s.MaxWidth, "default"),
+		orDefault(s.MaxHeight, "default"),
+		orDefault(s.MaxSteps, "default"),
+		orDefault(s.MaxCnt, "default"),
+		plainMessages,
+		mentions,
+		progressInterval,
+		orDefault(s.SpoilerMode, "default"),
+		orDefault(s.NegativePrompt, "none"),
+	)
+}
@@ -0,0 +1,83 @@
+package chatsettings
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+)
+
+var testLimits = config.GenerationLimits{MinWidth: 64, MaxWidth: 2048, MinHeight: 64, MaxHeight: 2048,
+	MinSteps: 1, MaxSteps: 100, MinCnt: 1, MaxCnt: 10}
+
+func TestSettingsUpdate(t *testing.T) {
+	var s Settings
+	for _, kv := range [][2]string{
+		{"models", "a, b"},
+		{"max-steps", "20"},
+		{"plain-messages", "on"},
+		{"mentions", "off"},
+		{"progress-interval", "10s"},
+		{"spoiler-mode", "blur"},
+		{"negative", "nsfw, lowres"},
+	} {
+		if err := s.Update(kv[0], kv[1], testLimits); err != nil {
+			t.Fatalf("%s %s: %v", kv[0], kv[1], err)
+		}
+	}
+	if len(s.AllowedModels) != 2 || s.AllowedModels[1] != "b" || s.MaxSteps != 20 || !s.PlainMessages || !s.MentionsDisabled ||
+		s.ProgressIntervalSeconds != 10 || s.SpoilerMode != "blur" || s.NegativePrompt != "nsfw, lowres" {
+		t.Errorf("got settings %+v", s)
+	}
+
+	for _, key := range []string{"models", "max-steps", "plain-messages", "mentions", "progress-interval", "spoiler-mode", "negative"} {
+		if err := s.Update(key, "reset", testLimits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.AllowedModels != nil || s.String() != (Settings{}).String() {
+		t.Errorf("settings are not reset: %+v", s)
+	}
+}
+
+func TestSettingsUpdateInvalid(t *testing.T) {
+	var s Settings
+	for kv, expectedErrStr := range map[[2]string]string{
+		{"max-width", "-5"}:         "should be from 64 to 2048",
+		{"max-width", "32"}:         "should be from 64 to 2048",
+		{"max-steps", "101"}:        "should be from 1 to 100",
+		{"progress-interval", "1"}:  "at least 2",
+		{"spoiler-mode", "delete"}:  "valid values are all",
+		{"plain-messages", "maybe"}: "valid values are on and off",
+		{"unknown", "1"}:            "unknown setting",
+		{"negative", ""}:            "missing value",
+	} {
+		err := s.Update(kv[0], kv[1], testLimits)
+		if err == nil || !strings.Contains(err.Error(), expectedErrStr) {
+			t.Errorf("got error %v for %v, expected %q", err, kv, expectedErrStr)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chatsettings.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(-100, Settings{MaxCnt: 2, SpoilerMode: SpoilerModeAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := store.Get(-100); s.MaxCnt != 2 || s.SpoilerMode != SpoilerModeAll {
+		t.Errorf("got settings %+v", s)
+	}
+	if s := store.Get(-200); s.MaxCnt != 0 {
+		t.Errorf("got settings %+v for unknown chat", s)
+	}
+}
@@ -0,0 +1,81 @@
+package chatsettings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
+)
+
+// Spoiler mode for sending all images as spoilers.
+const SpoilerModeAll = "all"
+
+// Settings are the chat level settings set by the chat admins. Zero values mean the bot
+// defaults are used.
+type Settings struct {
+	// Only these models can be used in the chat, the first one is the default.
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	MaxWidth      int      `json:"max_width,omitempty"`
+	MaxHeight     int      `json:"max_height,omitempty"`
+	MaxSteps      int      `json:"max_steps,omitempty"`
+	MaxCnt        int      `json:"max_cnt,omitempty"`
+	// Messages without a command are rendered, like in private chats. The bot's privacy
+	// mode needs to be disabled to receive them in groups.
+	PlainMessages           bool `json:"plain_messages,omitempty"`
+	ProgressIntervalSeconds int  `json:"progress_interval_seconds,omitempty"`
+	// "all" to send all images as spoilers, or the NSFW action (spoiler, blur or withhold)
+	// overriding the safety rules.
+	SpoilerMode string `json:"spoiler_mode,omitempty"`
+	// Appended to the negative prompt of all renders.
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	// Mentioning the bot and replying to rendered images doesn't trigger renders.
+	MentionsDisabled bool `json:"mentions_disabled,omitempty"`
+}
+
+// Store keeps the chat settings in a JSON file.
+type Store struct {
+	path  string
+	mutex sync.Mutex
+	chats map[int64]Settings
+}
+
+// Loads the settings from the file at path, the file is created on the first change.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, chats: make(map[int64]Settings)}
+	d, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read chat settings: %w", err)
+	}
+	if err = json.Unmarshal(d, &s.chats); err != nil {
+		return nil, fmt.Errorf("can't parse chat settings: %w", err)
+	}
+	return s, nil
+}
+
+func (s *Store) Get(chatID int64) Settings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.chats[chatID]
+}
+
+// Stores the settings of the chat and writes all settings to the file.
+func (s *Store) Set(chatID int64, settings Settings) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.chats[chatID] = settings
+
+	d, err := json.MarshalIndent(s.chats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = utils.WriteFileAtomic(s.path, d); err != nil {
+		return fmt.Errorf("can't save chat settings: %w", err)
+	}
+	return nil
+}
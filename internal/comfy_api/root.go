@@ -0,0 +1,586 @@
+package comfyapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+	"golang.org/x/net/websocket"
+)
+
+//go:embed workflows/render.json
+var defaultRenderWorkflow string
+
+//go:embed workflows/upscale.json
+var defaultUpscaleWorkflow string
+
+const historyPollInterval = 500 * time.Millisecond
+
+type progressState struct {
+	promptID  string
+	iter      int
+	iterCount int
+	value     int
+	max       int
+	startedAt time.Time
+}
+
+type ComfyAPIType struct {
+	Host string
+
+	clientID        string
+	renderWorkflow  *template.Template
+	upscaleWorkflow *template.Template
+	// Custom workflows may load LoRAs from the prompt tags, the built-in one doesn't.
+	customRenderWorkflow bool
+
+	progressMutex sync.Mutex
+	progress      progressState
+}
+
+var _ sdapi.Backend = (*ComfyAPIType)(nil)
+
+func loadWorkflowTemplate(name, path, defaultWorkflow string) (*template.Template, error) {
+	workflow := defaultWorkflow
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s workflow: %w", name, err)
+		}
+		workflow = string(b)
+	}
+
+	t, err := template.New(name).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(workflow)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse %s workflow: %w", name, err)
+	}
+	return t, nil
+}
+
+// Workflow files are API format ComfyUI workflows with Go template actions. If a path is empty
+// then the built-in workflow is used.
+func NewComfyAPI(host, renderWorkflowPath, upscaleWorkflowPath string) (*ComfyAPIType, error) {
+	a := &ComfyAPIType{Host: host}
+
+	var err error
+	if a.renderWorkflow, err = loadWorkflowTemplate("render", renderWorkflowPath, defaultRenderWorkflow); err != nil {
+		return nil, err
+	}
+	a.customRenderWorkflow = renderWorkflowPath != ""
+	if a.upscaleWorkflow, err = loadWorkflowTemplate("upscale", upscaleWorkflowPath, defaultUpscaleWorkflow); err != nil {
+		return nil, err
+	}
+
+	clientID := make([]byte, 16)
+	if _, err = rand.Read(clientID); err != nil {
+		return nil, err
+	}
+	a.clientID = hex.EncodeToString(clientID)
+	return a, nil
+}
+
+func (a *ComfyAPIType) doReq(request *http.Request) ([]byte, error) {
+	client := http.Client{}
+	resp, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) { // Not showing the API host to users.
+			return nil, fmt.Errorf("%s to %s: %w", request.Method, request.URL.Path, urlErr.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		fmt.Printf("Response: %s\n", string(bodyBytes))
+		apiErr := sdapi.ParseAPIError(resp.StatusCode, request.Method, request.URL.Path, bodyBytes)
+		fmt.Println("  api error:", apiErr.Kind, "-", apiErr)
+		return nil, apiErr
+	}
+	return bodyBytes, nil
+}
+
+func (a *ComfyAPIType) req(ctx context.Context, path string, postData []byte) ([]byte, error) {
+	path, err := url.JoinPath(a.Host, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var request *http.Request
+	if postData != nil {
+		request, err = http.NewRequestWithContext(ctx, "POST", path, bytes.NewBuffer(postData))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	} else {
+		request, err = http.NewRequestWithContext(ctx, "GET", path, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a.doReq(request)
+}
+
+func (a *ComfyAPIType) setProgress(update func(p *progressState)) {
+	a.progressMutex.Lock()
+	defer a.progressMutex.Unlock()
+	update(&a.progress)
+}
+
+// Reads progress messages from the ComfyUI websocket until the connection gets closed.
+func (a *ComfyAPIType) trackProgress(ws *websocket.Conn) {
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+
+		var wsMsg struct {
+			Type string `json:"type"`
+			Data struct {
+				PromptID string `json:"prompt_id"`
+				Value    int    `json:"value"`
+				Max      int    `json:"max"`
+			} `json:"data"`
+		}
+		if json.Unmarshal([]byte(msg), &wsMsg) != nil || wsMsg.Type != "progress" {
+			continue // Binary preview images and other messages are ignored.
+		}
+		a.setProgress(func(p *progressState) {
+			if wsMsg.Data.PromptID == "" || wsMsg.Data.PromptID == p.promptID {
+				p.value = wsMsg.Data.Value
+				p.max = wsMsg.Data.Max
+			}
+		})
+	}
+}
+
+// Connects to the websocket for progress tracking. Progress tracking is optional, so errors
+// are only logged.
+func (a *ComfyAPIType) startProgressTracking(ctx context.Context, iterCount int) (stop func()) {
+	a.setProgress(func(p *progressState) {
+		*p = progressState{iterCount: iterCount, startedAt: time.Now()}
+	})
+
+	wsURL, err := url.JoinPath(strings.Replace(a.Host, "http", "ws", 1), "/ws")
+	if err != nil {
+		fmt.Println("  comfyui websocket url error:", err)
+		return func() {}
+	}
+	ws, err := websocket.Dial(wsURL+"?clientId="+a.clientID, "", a.Host)
+	if err != nil {
+		fmt.Println("  comfyui websocket connect error:", err)
+		return func() {}
+	}
+	go a.trackProgress(ws)
+
+	stopCtx, stopCtxCancel := context.WithCancel(ctx)
+	go func() {
+		<-stopCtx.Done()
+		ws.Close()
+	}()
+	return stopCtxCancel
+}
+
+type historyImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+type historyEntry struct {
+	Status struct {
+		StatusStr string            `json:"status_str"`
+		Completed bool              `json:"completed"`
+		Messages  []json.RawMessage `json:"messages"`
+	} `json:"status"`
+	Outputs map[string]struct {
+		Images []historyImage `json:"images"`
+	} `json:"outputs"`
+}
+
+// Returns the exception message of the failed node from the history entry status messages.
+func executionError(entry historyEntry) error {
+	for _, m := range entry.Status.Messages {
+		var msg []json.RawMessage
+		if json.Unmarshal(m, &msg) != nil || len(msg) != 2 {
+			continue
+		}
+		var msgType string
+		var msgData struct {
+			NodeType         string `json:"node_type"`
+			ExceptionMessage string `json:"exception_message"`
+		}
+		if json.Unmarshal(msg[0], &msgType) != nil || msgType != "execution_error" || json.Unmarshal(msg[1], &msgData) != nil {
+			continue
+		}
+		detail := strings.TrimSpace(msgData.NodeType + ": " + msgData.ExceptionMessage)
+		return &sdapi.APIError{Kind: sdapi.ClassifyError(0, detail), Detail: detail}
+	}
+	return &sdapi.APIError{Detail: "workflow execution error"}
+}
+
+func (a *ComfyAPIType) getImage(ctx context.Context, img historyImage) ([]byte, error) {
+	q := url.Values{}
+	q.Set("filename", img.Filename)
+	q.Set("subfolder", img.Subfolder)
+	q.Set("type", img.Type)
+	path, err := url.JoinPath(a.Host, "/view")
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, "GET", path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return a.doReq(request)
+}
+
+// Queues the workflow, waits for it to finish and downloads the output images.
+func (a *ComfyAPIType) runWorkflow(ctx context.Context, workflow []byte) (imgs [][]byte, err error) {
+	postData, err := json.Marshal(map[string]any{
+		"prompt":    json.RawMessage(workflow),
+		"client_id": a.clientID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := a.req(ctx, "/prompt", postData)
+	if err != nil {
+		return nil, err
+	}
+	var promptRes struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err = json.Unmarshal(res, &promptRes); err != nil {
+		return nil, err
+	}
+	a.setProgress(func(p *progressState) {
+		p.promptID = promptRes.PromptID
+		p.value = 0
+	})
+
+	ticker := time.NewTicker(historyPollInterval)
+	defer ticker.Stop()
+	var entry historyEntry
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		res, err = a.req(ctx, "/history/"+promptRes.PromptID, nil)
+		if err != nil {
+			return nil, err
+		}
+		var history map[string]historyEntry
+		if err = json.Unmarshal(res, &history); err != nil {
+			return nil, err
+		}
+		var found bool
+		if entry, found = history[promptRes.PromptID]; !found {
+			continue
+		}
+		if entry.Status.StatusStr == "error" {
+			return nil, executionError(entry)
+		}
+		if entry.Status.Completed {
+			break
+		}
+	}
+
+	for _, output := range entry.Outputs {
+		for _, img := range output.Images {
+			if img.Type != "output" {
+				continue
+			}
+			d, err := a.getImage(ctx, img)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, d)
+		}
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("workflow has no output images")
+	}
+	return imgs, nil
+}
+
+// Features which are not in the built-in workflows are reported as unsupported.
+func (a *ComfyAPIType) Capabilities() sdapi.Capabilities {
+	return sdapi.Capabilities{
+		Flavor:    sdapi.FlavorComfyUI,
+		Scheduler: true,
+		LoRATags:  a.customRenderWorkflow,
+	}
+}
+
+type renderWorkflowData struct {
+	reqparams.ReqParamsRender
+	Seed      uint32
+	Scheduler string
+}
+
+func (a *ComfyAPIType) Render(ctx context.Context, p reqparams.ReqParams, _ []byte) (imgs [][]byte, err error) {
+	params := p.(reqparams.ReqParamsRender)
+	if err = a.Capabilities().CheckRender(params); err != nil {
+		return nil, err
+	}
+
+	if params.ModelName == "" {
+		// ComfyUI has no currently loaded model, so using the first one.
+		models, err := a.GetModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(models) == 0 {
+			return nil, fmt.Errorf("no models available")
+		}
+		params.ModelName = models[0]
+	}
+
+	nIter := int(math.Ceil(float64(params.NumOutputs) / float64(params.BatchSize)))
+	stopProgressTracking := a.startProgressTracking(ctx, nIter)
+	defer stopProgressTracking()
+
+	for i := 0; i < nIter; i++ {
+		data := renderWorkflowData{
+			ReqParamsRender: params,
+			Seed:            params.Seed + uint32(i*params.BatchSize),
+			Scheduler:       params.Scheduler,
+		}
+		if data.Scheduler == "" {
+			data.Scheduler = "normal"
+		}
+		workflow := new(bytes.Buffer)
+		if err = a.renderWorkflow.Execute(workflow, data); err != nil {
+			return nil, fmt.Errorf("render workflow template error: %w", err)
+		}
+
+		a.setProgress(func(p *progressState) { p.iter = i })
+		iterImgs, err := a.runWorkflow(ctx, workflow.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, iterImgs...)
+	}
+	return imgs, nil
+}
+
+func (a *ComfyAPIType) uploadImage(ctx context.Context, imageData []byte) (name string, err error) {
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	fw, err := form.CreateFormFile("image", "sd-telegram-bot-upscale.png")
+	if err != nil {
+		return "", err
+	}
+	if _, err = fw.Write(imageData); err != nil {
+		return "", err
+	}
+	_ = form.WriteField("overwrite", "true")
+	if err = form.Close(); err != nil {
+		return "", err
+	}
+
+	path, err := url.JoinPath(a.Host, "/upload/image")
+	if err != nil {
+		return "", err
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", path, body)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	res, err := a.doReq(request)
+	if err != nil {
+		return "", err
+	}
+
+	var uploadRes struct {
+		Name      string `json:"name"`
+		Subfolder string `json:"subfolder"`
+	}
+	if err = json.Unmarshal(res, &uploadRes); err != nil {
+		return "", err
+	}
+	if uploadRes.Subfolder != "" {
+		return uploadRes.Subfolder + "/" + uploadRes.Name, nil
+	}
+	return uploadRes.Name, nil
+}
+
+type upscaleWorkflowData struct {
+	reqparams.ReqParamsUpscale
+	ImageName string
+	Width     int
+	Height    int
+}
+
+func (a *ComfyAPIType) Upscale(ctx context.Context, p reqparams.ReqParams, imageData []byte) (imgs [][]byte, err error) {
+	params := p.(reqparams.ReqParamsUpscale)
+	if err = a.Capabilities().CheckUpscale(params); err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return nil, fmt.Errorf("image decode error: %w", err)
+	}
+	data := upscaleWorkflowData{
+		ReqParamsUpscale: params,
+		Width:            int(math.Round(float64(cfg.Width) * float64(params.Scale))),
+		Height:           int(math.Round(float64(cfg.Height) * float64(params.Scale))),
+	}
+	if params.TargetWidth > 0 {
+		data.Width = params.TargetWidth
+		data.Height = params.TargetHeight
+	}
+
+	stopProgressTracking := a.startProgressTracking(ctx, 1)
+	defer stopProgressTracking()
+
+	if data.ImageName, err = a.uploadImage(ctx, imageData); err != nil {
+		return nil, fmt.Errorf("image upload error: %w", err)
+	}
+	workflow := new(bytes.Buffer)
+	if err = a.upscaleWorkflow.Execute(workflow, data); err != nil {
+		return nil, fmt.Errorf("upscale workflow template error: %w", err)
+	}
+	return a.runWorkflow(ctx, workflow.Bytes())
+}
+
+func (a *ComfyAPIType) Interrupt(ctx context.Context) error {
+	_, err := a.req(ctx, "/interrupt", []byte{})
+	return err
+}
+
+func (a *ComfyAPIType) GetProgress(ctx context.Context) (progressPercent int, eta time.Duration, err error) {
+	a.progressMutex.Lock()
+	defer a.progressMutex.Unlock()
+
+	p := a.progress
+	if p.iterCount == 0 {
+		return 0, 0, nil
+	}
+	progress := float64(p.iter) / float64(p.iterCount)
+	if p.max > 0 {
+		progress += float64(p.value) / float64(p.max) / float64(p.iterCount)
+	}
+	if progress > 0 {
+		elapsed := time.Since(p.startedAt)
+		eta = time.Duration(float64(elapsed)/progress) - elapsed
+	}
+	return int(progress * 100), eta, nil
+}
+
+// Returns the valid values of a node input from the node's object info.
+func (a *ComfyAPIType) getNodeInputOptions(ctx context.Context, nodeClass, inputName string) (options []string, err error) {
+	res, err := a.req(ctx, "/object_info/"+nodeClass, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var objectInfo map[string]struct {
+		Input struct {
+			Required map[string][]json.RawMessage `json:"required"`
+		} `json:"input"`
+	}
+	if err = json.Unmarshal(res, &objectInfo); err != nil {
+		return nil, err
+	}
+	input := objectInfo[nodeClass].Input.Required[inputName]
+	if len(input) == 0 {
+		return nil, fmt.Errorf("node %s has no input %s", nodeClass, inputName)
+	}
+	if err = json.Unmarshal(input[0], &options); err != nil {
+		return nil, fmt.Errorf("node %s input %s is not a list", nodeClass, inputName)
+	}
+	return options, nil
+}
+
+func (a *ComfyAPIType) GetModels(ctx context.Context) (models []string, err error) {
+	return a.getNodeInputOptions(ctx, "CheckpointLoaderSimple", "ckpt_name")
+}
+
+// ComfyUI doesn't return model hashes without hashing the model files.
+func (a *ComfyAPIType) GetModelInfos(ctx context.Context) (models []sdapi.ModelInfo, err error) {
+	names, err := a.GetModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		models = append(models, sdapi.ModelInfo{Name: name})
+	}
+	return
+}
+
+func (a *ComfyAPIType) GetSamplers(ctx context.Context) (samplers []string, err error) {
+	return a.getNodeInputOptions(ctx, "KSampler", "sampler_name")
+}
+
+func (a *ComfyAPIType) GetSchedulers(ctx context.Context) (schedulers []string, err error) {
+	return a.getNodeInputOptions(ctx, "KSampler", "scheduler")
+}
+
+func (a *ComfyAPIType) GetUpscalers(ctx context.Context) (upscalers []string, err error) {
+	return a.getNodeInputOptions(ctx, "UpscaleModelLoader", "model_name")
+}
+
+func (a *ComfyAPIType) GetLoRAs(ctx context.Context) (loras []string, err error) {
+	return a.getNodeInputOptions(ctx, "LoraLoader", "lora_name")
+}
+
+func (a *ComfyAPIType) GetLoRAInfos(ctx context.Context) (loras []sdapi.LoRAInfo, err error) {
+	names, err := a.GetLoRAs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		loras = append(loras, sdapi.LoRAInfo{Name: name})
+	}
+	return
+}
+
+func (a *ComfyAPIType) GetVAEs(ctx context.Context) (vaes []string, err error) {
+	return a.getNodeInputOptions(ctx, "VAELoader", "vae_name")
+}
+
+func (a *ComfyAPIType) GetEmbeddings(ctx context.Context) (embs []string, err error) {
+	res, err := a.req(ctx, "/embeddings", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(res, &embs)
+	return
+}
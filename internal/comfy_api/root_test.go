@@ -0,0 +1,178 @@
+package comfyapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+	"golang.org/x/net/websocket"
+)
+
+// fakeComfyUI runs each queued workflow instantly and returns one image per SaveImage node.
+type fakeComfyUI struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	workflows []map[string]any
+	uploads   int
+}
+
+func newFakeComfyUI(t *testing.T) *fakeComfyUI {
+	t.Helper()
+	f := &fakeComfyUI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prompt", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt   map[string]any `json:"prompt"`
+			ClientID string         `json:"client_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ClientID == "" {
+			http.Error(w, "invalid prompt", http.StatusBadRequest)
+			return
+		}
+		f.mutex.Lock()
+		f.workflows = append(f.workflows, req.Prompt)
+		id := len(f.workflows)
+		f.mutex.Unlock()
+		fmt.Fprintf(w, `{"prompt_id": "p%d"}`, id)
+	})
+	mux.HandleFunc("/history/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/history/")
+		fmt.Fprintf(w, `{%q: {"status": {"status_str": "success", "completed": true},
+			"outputs": {"9": {"images": [{"filename": "out.png", "subfolder": "", "type": "output"}]}}}}`, id)
+	})
+	mux.HandleFunc("/view", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(sdapitest.GeneratePNG(8, 8, 1))
+	})
+	mux.HandleFunc("/upload/image", func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = io.Copy(io.Discard, file)
+		f.mutex.Lock()
+		f.uploads++
+		f.mutex.Unlock()
+		_, _ = w.Write([]byte(`{"name": "upload.png", "subfolder": "", "type": "input"}`))
+	})
+	mux.HandleFunc("/object_info/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"KSampler": {"input": {"required": {
+			"sampler_name": [["euler", "dpmpp_2m"]], "scheduler": [["normal", "karras"]]}}}}`)
+	})
+	mux.Handle("/ws", websocket.Handler(func(ws *websocket.Conn) {
+		_ = websocket.Message.Send(ws, `{"type": "progress", "data": {"value": 1, "max": 2}}`)
+		_, _ = io.Copy(io.Discard, ws)
+	}))
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newTestComfyAPI(t *testing.T) (*ComfyAPIType, *fakeComfyUI) {
+	t.Helper()
+	srv := newFakeComfyUI(t)
+	a, err := NewComfyAPI(srv.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, srv
+}
+
+func TestRender(t *testing.T) {
+	a, srv := newTestComfyAPI(t)
+
+	imgs, err := a.Render(context.Background(), reqparams.ReqParamsRender{
+		Prompt:      "a \"quoted\" cat",
+		Seed:        10,
+		Width:       64,
+		Height:      64,
+		BatchSize:   2,
+		NumOutputs:  4,
+		Steps:       5,
+		CFGScale:    7,
+		SamplerName: "euler",
+		ModelName:   "model.safetensors",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 {
+		t.Errorf("got %d images, expected 2", len(imgs))
+	}
+
+	if len(srv.workflows) != 2 {
+		t.Fatalf("got %d workflows, expected 2", len(srv.workflows))
+	}
+	for i, seed := range []float64{10, 12} {
+		sampler := srv.workflows[i]["3"].(map[string]any)["inputs"].(map[string]any)
+		if sampler["seed"] != seed || sampler["scheduler"] != "normal" {
+			t.Errorf("got sampler inputs %v", sampler)
+		}
+	}
+	prompt := srv.workflows[0]["6"].(map[string]any)["inputs"].(map[string]any)["text"]
+	if prompt != "a \"quoted\" cat" {
+		t.Errorf("got prompt %q", prompt)
+	}
+}
+
+func TestRenderUnsupported(t *testing.T) {
+	a, _ := newTestComfyAPI(t)
+
+	_, err := a.Render(context.Background(), reqparams.ReqParamsRender{
+		ModelName: "model.safetensors", BatchSize: 1, NumOutputs: 1, Tiling: true, ClipSkip: 2,
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "-clipskip, -tiling") {
+		t.Errorf("got error %v", err)
+	}
+
+	// The built-in workflows have no LoRA loader, so LoRA tags would silently end up in the prompt.
+	_, err = a.Render(context.Background(), reqparams.ReqParamsRender{
+		ModelName: "model.safetensors", BatchSize: 1, NumOutputs: 1, Prompt: "a cat <lora:add_detail:0.6>",
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "&lt;lora:...&gt; tags") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestUpscale(t *testing.T) {
+	a, srv := newTestComfyAPI(t)
+
+	imgs, err := a.Upscale(context.Background(), reqparams.ReqParamsUpscale{
+		Scale:    2,
+		Upscaler: "4x-UltraSharp.pth",
+	}, sdapitest.GeneratePNG(32, 16, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 || srv.uploads != 1 {
+		t.Fatalf("got %d images and %d uploads", len(imgs), srv.uploads)
+	}
+	scale := srv.workflows[0]["4"].(map[string]any)["inputs"].(map[string]any)
+	if scale["width"] != float64(64) || scale["height"] != float64(32) || scale["crop"] != "disabled" {
+		t.Errorf("got scale inputs %v", scale)
+	}
+}
+
+func TestGetSamplers(t *testing.T) {
+	a, _ := newTestComfyAPI(t)
+
+	samplers, err := a.GetSamplers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(samplers, ",") != "euler,dpmpp_2m" {
+		t.Errorf("got samplers %v", samplers)
+	}
+	if _, err = a.GetModels(context.Background()); err == nil {
+		t.Error("expected error for missing node input")
+	}
+}
@@ -8,11 +8,16 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 )
 
 type GenerationDefaults struct {
-	Model      string
-	Sampler    string
+	Model   string
+	Sampler string
+	// Upscalers of -u and -hr, the first upscaler of the backend is used if empty.
+	Upscaler   string
+	HRUpscaler string
 	Cnt        int
 	Batch      int
 	Steps      int
@@ -22,13 +27,21 @@ type GenerationDefaults struct {
 	HeightSDXL int
 	StepsSDXL  int
 	CFGScale   float64
+	// Output image format: jpg, png or webp.
+	OutputFormat string
+	// JPEG quality.
+	Quality int
+	// Upload mode: auto (PNGs as documents, others as photos), photo or document.
+	SendAs string
 }
 
 func (d GenerationDefaults) String() string {
 	return fmt.Sprintf(
-		"{model: %s, sampler: %s, cnt: %d, batch: %d, steps: %d, width: %d, height: %d, widthXL: %d, heightXL: %d, stepsXL: %d, cfg: %.2f}",
+		"{model: %s, sampler: %s, upscaler: %s, hrUpscaler: %s, cnt: %d, batch: %d, steps: %d, width: %d, height: %d, widthXL: %d, heightXL: %d, stepsXL: %d, cfg: %.2f, format: %s, quality: %d, sendAs: %s}",
 		d.Model,
 		d.Sampler,
+		d.Upscaler,
+		d.HRUpscaler,
 		d.Cnt,
 		d.Batch,
 		d.Steps,
@@ -38,31 +51,116 @@ func (d GenerationDefaults) String() string {
 		d.HeightSDXL,
 		d.StepsSDXL,
 		d.CFGScale,
+		d.OutputFormat,
+		d.Quality,
+		d.SendAs,
+	)
+}
+
+type GenerationLimits struct {
+	MinWidth    int
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	MinSteps    int
+	MaxSteps    int
+	MinCnt      int
+	MaxCnt      int
+	MinBatch    int
+	MaxBatch    int
+	MinCFGScale float64
+	MaxCFGScale float64
+}
+
+func (l GenerationLimits) String() string {
+	return fmt.Sprintf(
+		"{width: %d-%d, height: %d-%d, steps: %d-%d, cnt: %d-%d, batch: %d-%d, cfg: %.2f-%.2f}",
+		l.MinWidth,
+		l.MaxWidth,
+		l.MinHeight,
+		l.MaxHeight,
+		l.MinSteps,
+		l.MaxSteps,
+		l.MinCnt,
+		l.MaxCnt,
+		l.MinBatch,
+		l.MaxBatch,
+		l.MinCFGScale,
+		l.MaxCFGScale,
 	)
 }
 
 type AppParams struct {
 	StableDiffusionApiHost string
+	// Generation backend, "a1111" or "comfyui".
+	Backend                string
+	ComfyUIRenderWorkflow  string
+	ComfyUIUpscaleWorkflow string
 
 	BotToken        string
 	AllowedUserIDs  []int64
 	AdminUserIDs    []int64
 	AllowedGroupIDs []int64
 	ProcessTimeout  time.Duration
+	// How long the model, sampler, upscaler etc. lists of the backend are cached.
+	CatalogTTL time.Duration
+	// Retry renders failed with out of memory errors with smaller batch size and without highres.
+	OOMRecovery bool
+	// Upload each batch when it's finished instead of waiting for all images.
+	StreamBatches bool
+	// LibreTranslate compatible API for translating non-English prompts, disabled if empty.
+	TranslateApiHost string
+	TranslateApiKey  string
+	// Language code prompts are translated to.
+	TranslateTarget string
+	// OpenAI compatible API for /enhance and -enhance, disabled if empty.
+	EnhanceApiHost      string
+	EnhanceApiKey       string
+	EnhanceModel        string
+	EnhanceSystemPrompt string
+	EnhanceTimeout      time.Duration
+	// Path of the JSON file with prompt blocklists and mandatory negative prompts per chat.
+	SafetyRules string
+	// HTTP endpoint returning the NSFW score of the posted image, disabled if empty.
+	NSFWClassifier string
+	NSFWThreshold  float64
+	// Path of the JSON file where the /chatsettings of group chats are stored.
+	ChatSettings string
+	// Path of the JSON file where the languages chosen by users with /lang are stored.
+	UserLanguages string
+	// Path of the JSON file with the aliases of models, samplers and upscalers.
+	NameAliases string
 
 	Defaults GenerationDefaults
+	Limits   GenerationLimits
 }
 
 func (p AppParams) String() string {
 	return fmt.Sprintf(
-		"{sdAPI: %s, token: ...%s, admins: %v, allowedUsers: %v, allowedGroups: %v, processTimeout: %v, defaults: %v}",
+		"{sdAPI: %s, backend: %s, token: ...%s, admins: %v, allowedUsers: %v, allowedGroups: %v, processTimeout: %v, catalogTTL: %v, oomRecovery: %v, streamBatches: %v, translateAPI: %s, translateTarget: %s, enhanceAPI: %s, enhanceModel: %s, enhanceTimeout: %v, safetyRules: %s, nsfwClassifier: %s, nsfwThreshold: %.2f, chatSettings: %s, userLanguages: %s, nameAliases: %s, defaults: %v, limits: %v}",
 		p.StableDiffusionApiHost,
+		p.Backend,
 		p.BotToken[max(len(p.BotToken)-4, 0):],
 		p.AdminUserIDs,
 		p.AllowedUserIDs,
 		p.AllowedGroupIDs,
 		p.ProcessTimeout,
+		p.CatalogTTL,
+		p.OOMRecovery,
+		p.StreamBatches,
+		p.TranslateApiHost,
+		p.TranslateTarget,
+		p.EnhanceApiHost,
+		p.EnhanceModel,
+		p.EnhanceTimeout,
+		p.SafetyRules,
+		p.NSFWClassifier,
+		p.NSFWThreshold,
+		p.ChatSettings,
+		p.UserLanguages,
+		p.NameAliases,
 		p.Defaults,
+		p.Limits,
 	)
 }
 
@@ -71,7 +169,10 @@ func (p *AppParams) Init() error {
 	defaults := getDefaultsFromEnv()
 
 	flag.StringVar(&p.BotToken, "bot-token", "", "telegram bot token [required]")
-	flag.StringVar(&p.StableDiffusionApiHost, "sd-api", defaults.StableDiffusionApiHost, "address of running Stable Diffusion AUTOMATIC1111 API")
+	flag.StringVar(&p.StableDiffusionApiHost, "sd-api", defaults.StableDiffusionApiHost, "address of running Stable Diffusion AUTOMATIC1111 or ComfyUI API")
+	flag.StringVar(&p.Backend, "backend", defaults.Backend, "generation backend: a1111 or comfyui")
+	flag.StringVar(&p.ComfyUIRenderWorkflow, "comfyui-render-workflow", defaults.ComfyUIRenderWorkflow, "path to a custom ComfyUI render workflow template")
+	flag.StringVar(&p.ComfyUIUpscaleWorkflow, "comfyui-upscale-workflow", defaults.ComfyUIUpscaleWorkflow, "path to a custom ComfyUI upscale workflow template")
 	var allowedUserIDs string
 	flag.StringVar(&allowedUserIDs, "allowed-user-ids", defaults.AllowedUserIDs, "allowed telegram user ids")
 	var adminUserIDs string
@@ -79,8 +180,27 @@ func (p *AppParams) Init() error {
 	var allowedGroupIDs string
 	flag.StringVar(&allowedGroupIDs, "allowed-group-ids", defaults.AllowedGroupIDs, "allowed telegram group ids")
 	flag.DurationVar(&p.ProcessTimeout, "process-timeout", defaults.ProcessTimeout, "maximum time before generation auto-cancel")
+	flag.DurationVar(&p.CatalogTTL, "catalog-ttl", defaults.CatalogTTL, "how long model, sampler and upscaler lists of the backend are cached")
+	flag.BoolVar(&p.OOMRecovery, "oom-recovery", defaults.OOMRecovery, "retry renders failed with out of memory errors with smaller batch size, then without highres")
+	flag.BoolVar(&p.StreamBatches, "stream-batches", defaults.StreamBatches, "render one batch at a time and upload each batch when it's finished")
+	flag.StringVar(&p.TranslateApiHost, "translate-api", defaults.TranslateApiHost, "address of LibreTranslate compatible API for translating non-English prompts")
+	flag.StringVar(&p.TranslateApiKey, "translate-api-key", defaults.TranslateApiKey, "translate API key")
+	flag.StringVar(&p.TranslateTarget, "translate-target", defaults.TranslateTarget, "language code prompts are translated to")
+	flag.StringVar(&p.EnhanceApiHost, "enhance-api", defaults.EnhanceApiHost, "base URL of OpenAI compatible API for prompt enhancement (e.g. http://localhost:11434/v1)")
+	flag.StringVar(&p.EnhanceApiKey, "enhance-api-key", defaults.EnhanceApiKey, "prompt enhancement API key")
+	flag.StringVar(&p.EnhanceModel, "enhance-model", defaults.EnhanceModel, "prompt enhancement LLM model name")
+	flag.StringVar(&p.EnhanceSystemPrompt, "enhance-system-prompt", defaults.EnhanceSystemPrompt, "prompt enhancement system prompt, a built-in one is used if empty")
+	flag.DurationVar(&p.EnhanceTimeout, "enhance-timeout", defaults.EnhanceTimeout, "maximum time of prompt enhancement")
+	flag.StringVar(&p.SafetyRules, "safety-rules", defaults.SafetyRules, "path of the JSON file with prompt blocklists and mandatory negative prompts")
+	flag.StringVar(&p.NSFWClassifier, "nsfw-classifier", defaults.NSFWClassifier, "address of HTTP NSFW image classifier")
+	flag.Float64Var(&p.NSFWThreshold, "nsfw-threshold", defaults.NSFWThreshold, "NSFW classifier score from which images are flagged (0-1)")
+	flag.StringVar(&p.ChatSettings, "chat-settings", defaults.ChatSettings, "path of the JSON file where group chat settings are stored")
+	flag.StringVar(&p.UserLanguages, "user-languages", defaults.UserLanguages, "path of the JSON file where the languages chosen by users are stored")
+	flag.StringVar(&p.NameAliases, "name-aliases", defaults.NameAliases, "path of the JSON file with the aliases of models, samplers and upscalers")
 	flag.StringVar(&p.Defaults.Model, "default-model", defaults.Model, "default model name")
 	flag.StringVar(&p.Defaults.Sampler, "default-sampler", defaults.Sampler, "default sampler name")
+	flag.StringVar(&p.Defaults.Upscaler, "default-upscaler", defaults.Upscaler, "default upscaler name, the first upscaler of the backend if empty")
+	flag.StringVar(&p.Defaults.HRUpscaler, "default-hr-upscaler", defaults.HRUpscaler, "default highres upscaler name, the first upscaler of the backend if empty")
 	flag.IntVar(&p.Defaults.Cnt, "default-cnt", defaults.Cnt, "default images count")
 	flag.IntVar(&p.Defaults.Batch, "default-batch", defaults.Batch, "default images batch size")
 	flag.IntVar(&p.Defaults.Steps, "default-steps", defaults.Steps, "default generation steps")
@@ -90,13 +210,28 @@ func (p *AppParams) Init() error {
 	flag.IntVar(&p.Defaults.HeightSDXL, "default-height-sdxl", defaults.HeightSDXL, "default image height for SDXL models")
 	flag.IntVar(&p.Defaults.StepsSDXL, "default-cnt-sdxl", defaults.StepsSDXL, "default generation steps count for SDXL models")
 	flag.Float64Var(&p.Defaults.CFGScale, "default-cfg-scale", defaults.CFGScale, "default CFG scale")
+	flag.StringVar(&p.Defaults.OutputFormat, "default-format", defaults.OutputFormat, "default output image format: jpg, png or webp")
+	flag.IntVar(&p.Defaults.Quality, "default-quality", defaults.Quality, "default JPEG quality")
+	flag.StringVar(&p.Defaults.SendAs, "default-send-as", defaults.SendAs, "default upload mode: auto (PNGs as documents), photo or document")
+	flag.IntVar(&p.Limits.MinWidth, "limit-min-width", defaults.Limits.MinWidth, "minimum allowed image width")
+	flag.IntVar(&p.Limits.MaxWidth, "limit-max-width", defaults.Limits.MaxWidth, "maximum allowed image width")
+	flag.IntVar(&p.Limits.MinHeight, "limit-min-height", defaults.Limits.MinHeight, "minimum allowed image height")
+	flag.IntVar(&p.Limits.MaxHeight, "limit-max-height", defaults.Limits.MaxHeight, "maximum allowed image height")
+	flag.IntVar(&p.Limits.MinSteps, "limit-min-steps", defaults.Limits.MinSteps, "minimum allowed generation steps")
+	flag.IntVar(&p.Limits.MaxSteps, "limit-max-steps", defaults.Limits.MaxSteps, "maximum allowed generation steps")
+	flag.IntVar(&p.Limits.MinCnt, "limit-min-cnt", defaults.Limits.MinCnt, "minimum allowed images count")
+	flag.IntVar(&p.Limits.MaxCnt, "limit-max-cnt", defaults.Limits.MaxCnt, "maximum allowed images count")
+	flag.IntVar(&p.Limits.MinBatch, "limit-min-batch", defaults.Limits.MinBatch, "minimum allowed images batch size")
+	flag.IntVar(&p.Limits.MaxBatch, "limit-max-batch", defaults.Limits.MaxBatch, "maximum allowed images batch size")
+	flag.Float64Var(&p.Limits.MinCFGScale, "limit-min-cfg-scale", defaults.Limits.MinCFGScale, "minimum allowed CFG scale")
+	flag.Float64Var(&p.Limits.MaxCFGScale, "limit-max-cfg-scale", defaults.Limits.MaxCFGScale, "maximum allowed CFG scale")
 	flag.Parse()
 
 	if p.BotToken == "" {
 		p.BotToken = os.Getenv("BOT_TOKEN")
 	}
 	if p.BotToken == "" {
-		return fmt.Errorf("bot token not set")
+		return i18n.Errorf(i18n.ConfigBotTokenNotSet)
 	}
 
 	sa := strings.Split(allowedUserIDs, ",")
@@ -106,7 +241,7 @@ func (p *AppParams) Init() error {
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			return fmt.Errorf("allowed user ids contains invalid user ID: " + idStr)
+			return i18n.Errorf(i18n.ConfigInvalidUserID, idStr)
 		}
 		p.AllowedUserIDs = append(p.AllowedUserIDs, id)
 	}
@@ -118,7 +253,7 @@ func (p *AppParams) Init() error {
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			return fmt.Errorf("admin ids contains invalid user ID: " + idStr)
+			return i18n.Errorf(i18n.ConfigInvalidAdminID, idStr)
 		}
 		p.AdminUserIDs = append(p.AdminUserIDs, id)
 		if !slices.Contains(p.AllowedUserIDs, id) {
@@ -133,17 +268,45 @@ func (p *AppParams) Init() error {
 		}
 		id, err := strconv.ParseInt(idStr, 10, 64)
 		if err != nil {
-			return fmt.Errorf("allowed group ids contains invalid group ID: " + idStr)
+			return i18n.Errorf(i18n.ConfigInvalidGroupID, idStr)
 		}
 		p.AllowedGroupIDs = append(p.AllowedGroupIDs, id)
 	}
+
+	if p.Backend != "a1111" && p.Backend != "comfyui" {
+		return i18n.Errorf(i18n.ConfigUnknownBackend, p.Backend)
+	}
+
+	if !slices.Contains([]string{"jpg", "png", "webp"}, p.Defaults.OutputFormat) {
+		return i18n.Errorf(i18n.ConfigInvalidOutputFormat, p.Defaults.OutputFormat)
+	}
+	if p.Defaults.Quality < 1 || p.Defaults.Quality > 100 {
+		return i18n.Errorf(i18n.ConfigInvalidQuality)
+	}
+	if !slices.Contains([]string{"auto", "photo", "document"}, p.Defaults.SendAs) {
+		return i18n.Errorf(i18n.ConfigInvalidSendAs, p.Defaults.SendAs)
+	}
+	if p.NSFWThreshold <= 0 || p.NSFWThreshold > 1 {
+		return i18n.Errorf(i18n.ConfigInvalidNSFWThreshold)
+	}
+
+	if p.Limits.MinWidth > p.Limits.MaxWidth || p.Limits.MinHeight > p.Limits.MaxHeight ||
+		p.Limits.MinSteps > p.Limits.MaxSteps || p.Limits.MinCnt > p.Limits.MaxCnt ||
+		p.Limits.MinBatch > p.Limits.MaxBatch || p.Limits.MinCFGScale > p.Limits.MaxCFGScale {
+		return i18n.Errorf(i18n.ConfigInvalidLimits)
+	}
 	return nil
 }
 
 type defaultsFromEnv struct {
 	StableDiffusionApiHost string
+	Backend                string
+	ComfyUIRenderWorkflow  string
+	ComfyUIUpscaleWorkflow string
 	Model                  string
 	Sampler                string
+	Upscaler               string
+	HRUpscaler             string
 	Cnt                    int
 	Batch                  int
 	Steps                  int
@@ -153,10 +316,49 @@ type defaultsFromEnv struct {
 	HeightSDXL             int
 	StepsSDXL              int
 	CFGScale               float64
+	OutputFormat           string
+	Quality                int
+	SendAs                 string
 	AllowedUserIDs         string
 	AdminUserIDs           string
 	AllowedGroupIDs        string
 	ProcessTimeout         time.Duration
+	CatalogTTL             time.Duration
+	OOMRecovery            bool
+	StreamBatches          bool
+	TranslateApiHost       string
+	TranslateApiKey        string
+	TranslateTarget        string
+	EnhanceApiHost         string
+	EnhanceApiKey          string
+	EnhanceModel           string
+	EnhanceSystemPrompt    string
+	EnhanceTimeout         time.Duration
+	SafetyRules            string
+	NSFWClassifier         string
+	NSFWThreshold          float64
+	ChatSettings           string
+	UserLanguages          string
+	NameAliases            string
+	Limits                 GenerationLimits
+}
+
+func intFromEnv(name string, defaultValue int) int {
+	if value, isSet := os.LookupEnv(name); isSet {
+		if intValue, err := strconv.Atoi(value); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+func floatFromEnv(name string, defaultValue float64) float64 {
+	if value, isSet := os.LookupEnv(name); isSet {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
 }
 
 func getDefaultsFromEnv() (defaults defaultsFromEnv) {
@@ -166,6 +368,14 @@ func getDefaultsFromEnv() (defaults defaultsFromEnv) {
 		defaults.StableDiffusionApiHost = "http://localhost:7860"
 	}
 
+	if value, isSet := os.LookupEnv("BACKEND"); isSet {
+		defaults.Backend = value
+	} else {
+		defaults.Backend = "a1111"
+	}
+	defaults.ComfyUIRenderWorkflow = os.Getenv("COMFYUI_RENDER_WORKFLOW")
+	defaults.ComfyUIUpscaleWorkflow = os.Getenv("COMFYUI_UPSCALE_WORKFLOW")
+
 	if value, isSet := os.LookupEnv("DEFAULT_MODEL"); isSet {
 		defaults.Model = value
 	}
@@ -174,6 +384,9 @@ func getDefaultsFromEnv() (defaults defaultsFromEnv) {
 		defaults.Sampler = value
 	}
 
+	defaults.Upscaler = os.Getenv("DEFAULT_UPSCALER")
+	defaults.HRUpscaler = os.Getenv("DEFAULT_HR_UPSCALER")
+
 	if value, isSet := os.LookupEnv("DEFAULT_WIDTH"); isSet {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			defaults.Width = intValue
@@ -262,6 +475,17 @@ func getDefaultsFromEnv() (defaults defaultsFromEnv) {
 	} else {
 		defaults.CFGScale = 7.0
 	}
+	if value, isSet := os.LookupEnv("DEFAULT_FORMAT"); isSet {
+		defaults.OutputFormat = value
+	} else {
+		defaults.OutputFormat = "jpg"
+	}
+	defaults.Quality = intFromEnv("DEFAULT_QUALITY", 80)
+	if value, isSet := os.LookupEnv("DEFAULT_SEND_AS"); isSet {
+		defaults.SendAs = value
+	} else {
+		defaults.SendAs = "auto"
+	}
 	if value, isSet := os.LookupEnv("ALLOWED_USER_IDS"); isSet {
 		defaults.AllowedUserIDs = value
 	}
@@ -279,5 +503,67 @@ func getDefaultsFromEnv() (defaults defaultsFromEnv) {
 	} else {
 		defaults.ProcessTimeout = 15 * time.Minute
 	}
+	if value, isSet := os.LookupEnv("CATALOG_TTL"); isSet {
+		var err error
+		if defaults.CatalogTTL, err = time.ParseDuration(value); err != nil {
+			defaults.CatalogTTL = 10 * time.Minute
+		}
+	} else {
+		defaults.CatalogTTL = 10 * time.Minute
+	}
+
+	if value, isSet := os.LookupEnv("OOM_RECOVERY"); isSet {
+		defaults.OOMRecovery, _ = strconv.ParseBool(value)
+	}
+	if value, isSet := os.LookupEnv("STREAM_BATCHES"); isSet {
+		defaults.StreamBatches, _ = strconv.ParseBool(value)
+	}
+
+	defaults.TranslateApiHost = os.Getenv("TRANSLATE_API")
+	defaults.TranslateApiKey = os.Getenv("TRANSLATE_API_KEY")
+	if value, isSet := os.LookupEnv("TRANSLATE_TARGET"); isSet {
+		defaults.TranslateTarget = value
+	} else {
+		defaults.TranslateTarget = "en"
+	}
+
+	defaults.EnhanceApiHost = os.Getenv("ENHANCE_API")
+	defaults.EnhanceApiKey = os.Getenv("ENHANCE_API_KEY")
+	defaults.EnhanceModel = os.Getenv("ENHANCE_MODEL")
+	defaults.EnhanceSystemPrompt = os.Getenv("ENHANCE_SYSTEM_PROMPT")
+	if value, isSet := os.LookupEnv("ENHANCE_TIMEOUT"); isSet {
+		var err error
+		if defaults.EnhanceTimeout, err = time.ParseDuration(value); err != nil {
+			defaults.EnhanceTimeout = time.Minute
+		}
+	} else {
+		defaults.EnhanceTimeout = time.Minute
+	}
+
+	defaults.SafetyRules = os.Getenv("SAFETY_RULES")
+	defaults.NSFWClassifier = os.Getenv("NSFW_CLASSIFIER")
+	defaults.NSFWThreshold = floatFromEnv("NSFW_THRESHOLD", 0.7)
+	defaults.ChatSettings = os.Getenv("CHAT_SETTINGS")
+	if defaults.ChatSettings == "" {
+		defaults.ChatSettings = "chatsettings.json"
+	}
+	defaults.UserLanguages = os.Getenv("USER_LANGUAGES")
+	if defaults.UserLanguages == "" {
+		defaults.UserLanguages = "languages.json"
+	}
+	defaults.NameAliases = os.Getenv("NAME_ALIASES")
+
+	defaults.Limits.MinWidth = intFromEnv("LIMIT_MIN_WIDTH", 64)
+	defaults.Limits.MaxWidth = intFromEnv("LIMIT_MAX_WIDTH", 2048)
+	defaults.Limits.MinHeight = intFromEnv("LIMIT_MIN_HEIGHT", 64)
+	defaults.Limits.MaxHeight = intFromEnv("LIMIT_MAX_HEIGHT", 2048)
+	defaults.Limits.MinSteps = intFromEnv("LIMIT_MIN_STEPS", 1)
+	defaults.Limits.MaxSteps = intFromEnv("LIMIT_MAX_STEPS", 100)
+	defaults.Limits.MinCnt = intFromEnv("LIMIT_MIN_CNT", 1)
+	defaults.Limits.MaxCnt = intFromEnv("LIMIT_MAX_CNT", 10)
+	defaults.Limits.MinBatch = intFromEnv("LIMIT_MIN_BATCH", 1)
+	defaults.Limits.MaxBatch = intFromEnv("LIMIT_MAX_BATCH", 4)
+	defaults.Limits.MinCFGScale = floatFromEnv("LIMIT_MIN_CFG_SCALE", 1)
+	defaults.Limits.MaxCFGScale = floatFromEnv("LIMIT_MAX_CFG_SCALE", 30)
 	return
 }
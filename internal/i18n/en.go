@@ -0,0 +1,252 @@
+package i18n
+
+var en = map[Key]string{
+	Start: "🤖 Welcome! This is a Telegram Bot " +
+		"for rendering images with Stable Diffusion.\n\nMore info:" +
+		" https://github.com/kanootoko/stable-diffusion-telegram-bot",
+	BotStartedToAdmins: "🤖 Bot started, version %s, %s",
+	UsageNotAllowed:    "You need to contact bot hoster to enable the functionality",
+	Error:              "❌ Error: %s",
+	Done:               "✅ Done",
+	Canceled:           "⭕ Canceled",
+
+	QueuePosition:          "👨‍👦‍👦 Request queued at position #%d, %d request ahead|👨‍👦‍👦 Request queued at position #%d, %d requests ahead",
+	RequestUpdated:         "✏️ Request updated",
+	ImageReq:               "🩻 Please send the image file to process.",
+	ProcessStart:           "🛎 Starting render...",
+	Process:                "🔨 Working %s ETA: %s",
+	Downloading:            "⬇ Downloading... %s",
+	DownloadDone:           "✅ Done downloading",
+	Uploading:              "☁ Uploading...",
+	BatchProgress:          "📦 Batch %d/%d, %d image delivered|📦 Batch %d/%d, %d images delivered",
+	OOMRetry:               "🧠 Out of GPU memory, retrying with %s",
+	OOMDowngradeBatchSize:  "batch size %d",
+	OOMDowngradeHRDisabled: "highres disabled",
+	NSFWWithheld:           "🚫 %d image was flagged as NSFW and withheld|🚫 %d images were flagged as NSFW and withheld",
+	NSFWWithheldToAdmins:   "🚫 %d NSFW image(s) withheld from @%s #%d in chat %d, prompt: %s",
+	NoActiveRequest:        "no active request to cancel",
+	SDNotRunning:           "Stable Diffusion is not running and start is disabled",
+	Timeout:                "timeout",
+	ImageWaitTimeout:       "waiting for image data timeout",
+	NoImageData:            "got no image data",
+	InvalidImage:           "can't read the image: %s",
+	CantGetFile:            "can't get file: %s",
+
+	APIError:               "Stable Diffusion error: %s",
+	APIErrorOOM:            "🧠 Out of GPU memory: try a smaller size, batch size or highres scale",
+	APIErrorMissingModel:   "model not found: check the available models with /models",
+	APIErrorInvalidSampler: "invalid sampler: check the available samplers with /samplers",
+	APIErrorBusy:           "Stable Diffusion is busy: try again later",
+	APIErrorValidation:     "invalid request parameters: %s",
+	NotSupported:           "not supported by the %s backend: %s",
+
+	EmptyRequest:             "Request is empty, generation skipped",
+	MissingPrompt:            "missing prompt",
+	CantParseParams:          "can't parse render params: %s",
+	CantProcessPrompt:        "can't process prompt: %s",
+	ModelNotAllowed:          "model %s is not allowed in this chat, allowed models: %s",
+	PromptBlocked:            "🚫 The prompt contains words which are not allowed in this chat",
+	EditedMessageUnavailable: "the edited message is not available",
+	RerenderNotOwner:         "only the author of the request can re-render it",
+	RerenderEdited:           "✏️ The request was edited after it has been started.",
+	RerenderButton:           "🔁 Re-render with the edited prompt",
+
+	Enhancing:            "✨ Enhancing prompt...",
+	EnhancedPrompt:       "✨ Enhanced prompt:",
+	EnhanceRenderButton:  "🎨 Render this",
+	EnhanceEditButton:    "✏ Edit",
+	EnhanceEdit:          "✏ Copy the command, edit it and send it back:",
+	EnhanceNotConfigured: "prompt enhancement is not configured",
+	CantEnhance:          "can't enhance prompt: %s",
+
+	ChatSettings:                   "⚙ Chat settings:",
+	ChatSettingsUpdated:            "✅ Chat settings updated:",
+	ChatSettingsGroupOnly:          "chat settings are only available in group chats",
+	ChatSettingsNotConfigured:      "chat settings are not configured",
+	ChatSettingsAdminsOnly:         "only chat admins can change the settings",
+	ChatSettingsMissingValue:       "missing value",
+	ChatSettingsInvalidMax:         "invalid value, should be from %d to %d",
+	ChatSettingsInvalidOnOff:       "invalid value, valid values are on and off",
+	ChatSettingsInvalidInterval:    "invalid value, should be the number of seconds, at least %d",
+	ChatSettingsInvalidSpoilerMode: "invalid value, valid values are all, spoiler, blur and withhold",
+	ChatSettingsUnknown:            "unknown setting %s, valid settings are %s",
+	UnknownModel:                   "unknown model %s, get valid values with /models",
+
+	Language:              "🌐 Language: %s\nAvailable languages: %s\n\nChange it with <code>/lang ru</code>, or use the language of your Telegram app with <code>/lang auto</code>",
+	LanguageUpdated:       "🌐 Language set to %s",
+	LanguageUnknown:       "unknown language %s, available languages: %s",
+	LanguageNotConfigured: "language settings are not configured",
+
+	InlineHelp:      "🎨 Type a prompt to render",
+	InlineRendering: "⏳ Rendering, type the query again to see the results",
+
+	DefaultListItem:        "- <b>%s</b> (default)",
+	AvailableModels:        "🧩 Available models:\n%s",
+	NoModels:               "No available models.",
+	AvailableSamplers:      "🔭 Available samplers:\n%s",
+	NoSamplers:             "No available samplers.",
+	AvailableEmbeddings:    "Available embeddings: %s",
+	NoEmbeddings:           "No available embeddings.",
+	AvailableLoRAs:         "Available LoRAs: %s",
+	NoLoRAs:                "No available LoRAs.",
+	AvailableUpscalers:     "🔎 Available upscalers: %s",
+	NoUpscalers:            "🔎 No available upscalers.",
+	AvailableVAEs:          "Available VAEs: %s",
+	NoVAEs:                 "No available VAEs.",
+	LoRAInfo:               "LoRA <code>%s</code>",
+	LoRAAlias:              "Alias: <code>%s</code>",
+	LoRABaseModel:          "Base model: %s",
+	LoRATriggerWords:       "Trigger words: <code>%s</code>",
+	LoRAUsage:              "Usage: <code>-lora %s:1</code>",
+	GettingModelsError:     "error getting models: %s",
+	GettingSamplersError:   "error getting samplers: %s",
+	GettingSchedulersError: "error getting schedulers: %s",
+	GettingEmbeddingsError: "error getting embeddings: %s",
+	GettingLoRAsError:      "error getting LoRAs: %s",
+	GettingUpscalersError:  "error getting upscalers: %s",
+	NoDefaultUpscaler:      "no default upscaler, the backend has no upscalers",
+	NoDefaultSampler:       "no default sampler, the backend has no samplers",
+	GettingVAEsError:       "error getting VAEs: %s",
+	NvidiaSMIError:         "error running nvidia-smi: %s",
+	Refreshed:              "🔄 Lists refreshed, models: %d, LoRAs: %d",
+	RefreshNotSupported:    "refreshing lists is not supported by the backend",
+	RefreshError:           "error refreshing lists: %s",
+
+	DidYouMean:                 ", did you mean %s?",
+	ParamsAfterPrompt:          "params need to be after the prompt",
+	AttrError:                  "<code>%s</code>: %s",
+	AttrNoValue:                "this parameter takes no value",
+	AttrNotApplicable:          "%s can only be used with %s",
+	AmbiguousValue:             "ambiguous value, it matches %s",
+	MissingValue:               "missing value",
+	OutOfRange:                 "%s %d is out of the allowed range %d-%d",
+	CFGScaleOutOfRange:         "CFG scale %.1f is out of the allowed range %.1f-%.1f",
+	InvalidAspectRatio:         "invalid aspect ratio, use the W:H form",
+	InvalidAspectRatioWidth:    "invalid aspect ratio width",
+	InvalidAspectRatioHeight:   "invalid aspect ratio height",
+	AspectRatioWithSize:        "aspect ratio can't be used with both width and height set",
+	QualityWithWebP:            "quality can't be set for WebP images, they are lossless",
+	InvalidFraction:            "value should be between 0 and 1",
+	InvalidNumber:              "value should be a number",
+	InvalidSeed:                "invalid seed",
+	InvalidWidth:               "invalid width",
+	InvalidHeight:              "invalid height",
+	InvalidSteps:               "invalid steps",
+	InvalidBatchSize:           "invalid batch size",
+	InvalidCnt:                 "invalid output count",
+	InvalidFormat:              "invalid format, valid values are jpg, png and webp",
+	InvalidQuality:             "invalid quality, valid values are 1-100",
+	InvalidCFGScale:            "invalid CFG scale",
+	InvalidSampler:             "invalid sampler%s",
+	InvalidModel:               "invalid model%s",
+	InvalidRefinerSwitch:       "invalid refiner switch point, valid values are between 0 and 1",
+	InvalidRefinerModel:        "invalid refiner model%s",
+	InvalidVAE:                 "invalid VAE%s",
+	InvalidClipSkip:            "invalid clip skip, valid values are 1-12",
+	InvalidEta:                 "invalid eta, valid values are 0-1",
+	InvalidScheduler:           "invalid scheduler%s",
+	InvalidHRScale:             "invalid hr scale",
+	InvalidUpscaleRatio:        "invalid upscale ratio",
+	InvalidHRDenoise:           "invalid hr denoise strength, valid values are 0-1",
+	InvalidHRSteps:             "invalid hr second pass steps",
+	InvalidUpscaler:            "invalid upscaler%s",
+	InvalidUpscaler2Visibility: "invalid second upscaler visibility: %s",
+	InvalidTargetSize:          "invalid target size, use the WxH form",
+	TargetSizeTooLarge:         "target size is too large, maximum is %dx%d",
+	HRSizeTooLarge:             "highres size %dx%d is too large, maximum is %dx%d",
+	UpscaledSizeTooLarge:       "upscaled size %dx%d is too large, maximum is %dx%d",
+	InvalidGFPGAN:              "invalid GFPGAN visibility: %s",
+	InvalidCodeFormer:          "invalid CodeFormer visibility: %s",
+	InvalidCodeFormerWeight:    "invalid CodeFormer weight: %s",
+	InvalidLoRAWeight:          "invalid LoRA weight",
+	MissingLoRAName:            "missing LoRA name",
+	UnknownLoRA:                "unknown LoRA %s%s",
+	EmbeddingHint:              "💡 %s is not an embedding, did you mean %s?",
+
+	ParamWidth:     "width",
+	ParamHeight:    "height",
+	ParamSteps:     "steps",
+	ParamCnt:       "output count",
+	ParamBatchSize: "batch size",
+	ParamHRSteps:   "hr second pass steps",
+
+	HelpCommands:      "🤖 Stable Diffusion Telegram Bot\n\nAvailable commands:\n",
+	HelpAdminCommands: "Admin commands:\n",
+	HelpTriggers:      "Renders can also be started by mentioning the bot, and by replying to a rendered image with a new prompt or parameters.",
+	HelpRenderAttrs:   "Available render parameters at the end of the prompt, given as <code>-name value</code> or <code>-name=value</code>:\n",
+	HelpUpscaleAttrs:  "Available upscale parameters:\n",
+	HelpFooter: "Send <code>/help parameter</code> (like <code>/help hr</code>) to get the help of a parameter.\n\n" +
+		"For more information see https://github.com/kanootoko/stable-diffusion-telegram-bot",
+	AttrUsage:    "Example: <code>%s</code>",
+	AttrAliases:  "Aliases: %s",
+	AttrUsedWith: "Used with: %s",
+	UnknownAttr:  "unknown parameter %s%s",
+
+	CmdSD:           "render prompt (negative prompt can be put on the next line)",
+	CmdUpscale:      "upscale image",
+	CmdEnhance:      "expand a short idea to a detailed prompt",
+	CmdCancel:       "cancel ongoing request",
+	CmdModels:       "list available models",
+	CmdSamplers:     "list available samplers",
+	CmdEmbeddings:   "list available embeddings",
+	CmdLoRAs:        "list available LoRAs or show LoRA info",
+	CmdUpscalers:    "list available upscalers",
+	CmdVAEs:         "list available VAEs",
+	CmdChatSettings: "show or change the settings of a group chat",
+	CmdLang:         "show or change the language of the bot messages",
+	CmdSMI:          "get the output of nvidia-smi",
+	CmdRefresh:      "rescan models and LoRAs, and refresh the cached lists",
+	CmdHelp:         "show the help, or the help of a parameter",
+
+	AttrSeed:                "set seed",
+	AttrWidth:               "set output image width",
+	AttrHeight:              "set output image height",
+	AttrAspectRatio:         "set aspect ratio (e.g. 16:9), resolution is calculated for the model",
+	AttrPortrait:            "shorthand for the 2:3 aspect ratio",
+	AttrLandscape:           "shorthand for the 3:2 aspect ratio",
+	AttrSquare:              "shorthand for the 1:1 aspect ratio",
+	AttrSteps:               "set the number of steps",
+	AttrCnt:                 "set count of output images",
+	AttrBatch:               "set batch size of output images",
+	AttrPNG:                 "upload PNGs instead of JPEGs",
+	AttrFormat:              "set output format (jpg, png or webp, webp is lossless)",
+	AttrQuality:             "set JPEG quality (1-100)",
+	AttrDocument:            "upload images as documents",
+	AttrPhoto:               "upload images as photos",
+	AttrCFGScale:            "set CFG scale",
+	AttrSampler:             "set sampler, get valid values with /samplers",
+	AttrModel:               "set model, get valid values with /models",
+	AttrLoRA:                "add LoRA with weight (name:weight), can be used multiple times",
+	AttrVAE:                 "set VAE, get valid values with /vaes",
+	AttrClipSkip:            "set the number of last CLIP layers to skip (1-12)",
+	AttrEta:                 "set sampler eta (noise multiplier)",
+	AttrScheduler:           "set sampler scheduler",
+	AttrRefiner:             "set refiner model and switch point (0.8 by default)",
+	AttrRestoreFaces:        "enable face restoration",
+	AttrTiling:              "produce tileable images",
+	AttrEnhance:             "expand the prompt to a detailed one before rendering",
+	AttrUpscale:             "upscale output image with ratio",
+	AttrUpscaler:            "set upscaler method, get valid values with /upscalers",
+	AttrUpscaler2:           "set second upscaler method",
+	AttrUpscaler2Visibility: "set second upscaler visibility (0-1)",
+	AttrTo:                  "upscale to the given size instead of ratio",
+	AttrCrop:                "crop to fit the size given with -to",
+	AttrGFPGAN:              "set GFPGAN face restoration visibility (0-1)",
+	AttrCodeFormer:          "set CodeFormer face restoration visibility (0-1)",
+	AttrCodeFormerWeight:    "set CodeFormer weight (0-1)",
+	AttrHR:                  "enable highres mode and set upscale ratio",
+	AttrHRDenoise:           "set highres mode denoise strength",
+	AttrHRUpscaler:          "set highres mode upscaler, get valid values with /upscalers",
+	AttrHRSteps:             "set the number of highres mode second pass steps",
+
+	ConfigBotTokenNotSet:       "bot token not set",
+	ConfigInvalidUserID:        "allowed user ids contains invalid user ID: %s",
+	ConfigInvalidAdminID:       "admin ids contains invalid user ID: %s",
+	ConfigInvalidGroupID:       "allowed group ids contains invalid group ID: %s",
+	ConfigUnknownBackend:       "unknown backend: %s",
+	ConfigInvalidOutputFormat:  "invalid default output format: %s",
+	ConfigInvalidQuality:       "default quality should be between 1 and 100",
+	ConfigInvalidSendAs:        "invalid default upload mode: %s",
+	ConfigInvalidNSFWThreshold: "nsfw threshold should be between 0 and 1",
+	ConfigInvalidLimits:        "generation limits contain a minimum which is larger than the maximum",
+}
@@ -0,0 +1,166 @@
+// Package i18n provides the localized texts of the bot messages.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lang is a language code like "en". Unsupported and empty languages use English.
+type Lang string
+
+const (
+	English Lang = "en"
+	Russian Lang = "ru"
+)
+
+// Supported languages, in the order they're listed to users.
+var Languages = []Lang{English, Russian}
+
+var catalogs = map[Lang]map[Key]string{
+	English: en,
+	Russian: ru,
+}
+
+var names = map[Lang]string{
+	English: "English",
+	Russian: "Русский",
+}
+
+// Returns the supported language of a Telegram language code like "ru" or "pt-br", or
+// English if it's not supported.
+func Match(languageCode string) Lang {
+	code, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	if _, ok := catalogs[Lang(code)]; ok {
+		return Lang(code)
+	}
+	return English
+}
+
+// Returns the language and true if it's supported.
+func Parse(s string) (Lang, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, l := range Languages {
+		if s == string(l) || s == strings.ToLower(names[l]) {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+func (l Lang) Name() string {
+	if name, ok := names[l]; ok {
+		return name
+	}
+	return names[English]
+}
+
+func (l Lang) text(key Key) string {
+	if s, ok := catalogs[l][key]; ok {
+		return s
+	}
+	return en[key]
+}
+
+// Returns the text of the key formatted with args. Message and error args are localized.
+func (l Lang) T(key Key, args ...any) string {
+	return l.format(l.text(key), args)
+}
+
+// Returns the plural form of the text of the key for the count n, formatted with args.
+// Plural forms are separated with "|" in the catalogs.
+func (l Lang) N(key Key, n int, args ...any) string {
+	forms := strings.Split(l.text(key), "|")
+	return l.format(forms[min(l.pluralForm(n), len(forms)-1)], args)
+}
+
+// Returns the index of the plural form for the count n.
+func (l Lang) pluralForm(n int) int {
+	if n < 0 {
+		n = -n
+	}
+	switch l {
+	case Russian:
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return 1
+		default:
+			return 2
+		}
+	default:
+		if n == 1 {
+			return 0
+		}
+		return 1
+	}
+}
+
+func (l Lang) format(s string, args []any) string {
+	if len(args) == 0 {
+		return s
+	}
+	localized := make([]any, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case Message:
+			localized[i] = l.T(arg.Key, arg.Args...)
+		case error:
+			localized[i] = l.Error(arg)
+		default:
+			localized[i] = arg
+		}
+	}
+	return fmt.Sprintf(s, localized...)
+}
+
+// Returns the localized text of errors created with Errorf, and the error string of other
+// errors. Errors wrapping localized errors are not localized, as their context is not.
+func (l Lang) Error(err error) string {
+	if le, ok := err.(*LocalizedError); ok {
+		return l.T(le.Key, le.Args...)
+	}
+	return err.Error()
+}
+
+// Message is a text with its args which is localized when it's sent, it can be used as an
+// arg of other texts.
+type Message struct {
+	Key  Key
+	Args []any
+}
+
+func Msg(key Key, args ...any) Message {
+	return Message{Key: key, Args: args}
+}
+
+// Returns the English text.
+func (m Message) String() string {
+	return English.T(m.Key, m.Args...)
+}
+
+// LocalizedError is an error which is shown to users in their language.
+type LocalizedError struct {
+	Message
+}
+
+// Returns an error with the text of the key, args are formatted the same way as with T.
+func Errorf(key Key, args ...any) error {
+	return &LocalizedError{Msg(key, args...)}
+}
+
+// Returns the English text.
+func (e *LocalizedError) Error() string {
+	return e.String()
+}
+
+// Returns the first error arg, so wrapped errors can be checked.
+func (e *LocalizedError) Unwrap() error {
+	for _, arg := range e.Args {
+		if err, ok := arg.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
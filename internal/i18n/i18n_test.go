@@ -0,0 +1,118 @@
+package i18n
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var verbRegex = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z]`)
+
+func TestCatalogs(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for key, enText := range en {
+			text, ok := catalog[key]
+			if !ok {
+				t.Errorf("%s: missing key %d (%q)", lang, key, enText)
+				continue
+			}
+			forms := strings.Split(text, "|")
+			if slices.Contains(pluralKeys, key) {
+				if expected := lang.pluralForm(5) + 1; len(forms) != expected {
+					t.Errorf("%s: key %d has %d plural forms, expected %d", lang, key, len(forms), expected)
+				}
+			}
+			enVerbs := verbRegex.FindAllString(strings.Split(enText, "|")[0], -1)
+			for _, form := range forms {
+				if verbs := verbRegex.FindAllString(form, -1); !slices.Equal(verbs, enVerbs) {
+					t.Errorf("%s: key %d has verbs %v, expected %v", lang, key, verbs, enVerbs)
+				}
+			}
+		}
+		if len(catalog) != len(en) {
+			t.Errorf("%s: has %d keys, expected %d", lang, len(catalog), len(en))
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for code, expected := range map[string]Lang{"ru": Russian, "RU-ru": Russian, "en-US": English, "de": English, "": English} {
+		if lang := Match(code); lang != expected {
+			t.Errorf("got %s for %q", lang, code)
+		}
+	}
+	if lang, ok := Parse("Русский"); !ok || lang != Russian {
+		t.Errorf("got %q, %v", lang, ok)
+	}
+	if _, ok := Parse("de"); ok {
+		t.Error("parsed unsupported language")
+	}
+}
+
+func TestPlural(t *testing.T) {
+	for n, expected := range map[int]string{1: "запрос", 2: "запроса", 5: "запросов", 11: "запросов", 21: "запрос", 24: "запроса", 112: "запросов"} {
+		if s := Russian.N(QueuePosition, n, n+1, n); !strings.HasSuffix(s, fmt.Sprint(n, " ", expected)) {
+			t.Errorf("got %q for %d", s, n)
+		}
+	}
+	if s := English.N(QueuePosition, 1, 2, 1); s != "👨‍👦‍👦 Request queued at position #2, 1 request ahead" {
+		t.Errorf("got %q", s)
+	}
+	if s := Lang("de").N(BatchProgress, 2, 1, 3, 2); s != "📦 Batch 1/3, 2 images delivered" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func TestError(t *testing.T) {
+	inner := errors.New("connection refused")
+	err := Errorf(CantParseParams, Errorf(InvalidVAE, Msg(DidYouMean, "vae-ft-mse")))
+	if err.Error() != "can't parse render params: invalid VAE, did you mean vae-ft-mse?" {
+		t.Errorf("got %q", err.Error())
+	}
+	if s := Russian.Error(err); s != "не удалось разобрать параметры: неверный VAE, возможно вы имели в виду vae-ft-mse?" {
+		t.Errorf("got %q", s)
+	}
+	if s := Russian.T(InvalidVAE, Message{}); s != "неверный VAE" {
+		t.Errorf("got %q for an empty message arg", s)
+	}
+
+	err = Errorf(GettingModelsError, inner)
+	if !errors.Is(err, inner) {
+		t.Error("wrapped error not found")
+	}
+	if s := Russian.Error(fmt.Errorf("context: %w", err)); s != "context: error getting models: connection refused" {
+		t.Errorf("got %q for a wrapped localized error", s)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "languages.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(10, Russian); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(20, English); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(20, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lang, ok := store.Get(10); !ok || lang != Russian {
+		t.Errorf("got %q, %v", lang, ok)
+	}
+	if _, ok := store.Get(20); ok {
+		t.Error("removed language is kept")
+	}
+}
@@ -0,0 +1,272 @@
+package i18n
+
+// Key identifies a text in the catalogs.
+type Key int
+
+const (
+	// The zero key has an empty text, it's used for empty optional message args.
+	None Key = iota
+
+	Start
+	BotStartedToAdmins
+	UsageNotAllowed
+	Error
+	Done
+	Canceled
+
+	// Queue and render progress.
+	QueuePosition
+	RequestUpdated
+	ImageReq
+	ProcessStart
+	Process
+	Downloading
+	DownloadDone
+	Uploading
+	BatchProgress
+	OOMRetry
+	OOMDowngradeBatchSize
+	OOMDowngradeHRDisabled
+	NSFWWithheld
+	NSFWWithheldToAdmins
+	NoActiveRequest
+	SDNotRunning
+	Timeout
+	ImageWaitTimeout
+	NoImageData
+	InvalidImage
+	CantGetFile
+
+	// Backend errors.
+	APIError
+	APIErrorOOM
+	APIErrorMissingModel
+	APIErrorInvalidSampler
+	APIErrorBusy
+	APIErrorValidation
+	NotSupported
+
+	// Render requests.
+	EmptyRequest
+	MissingPrompt
+	CantParseParams
+	CantProcessPrompt
+	ModelNotAllowed
+	PromptBlocked
+	EditedMessageUnavailable
+	RerenderNotOwner
+	RerenderEdited
+	RerenderButton
+
+	// Prompt enhancement.
+	Enhancing
+	EnhancedPrompt
+	EnhanceRenderButton
+	EnhanceEditButton
+	EnhanceEdit
+	EnhanceNotConfigured
+	CantEnhance
+
+	// Chat settings.
+	ChatSettings
+	ChatSettingsUpdated
+	ChatSettingsGroupOnly
+	ChatSettingsNotConfigured
+	ChatSettingsAdminsOnly
+	ChatSettingsMissingValue
+	ChatSettingsInvalidMax
+	ChatSettingsInvalidOnOff
+	ChatSettingsInvalidInterval
+	ChatSettingsInvalidSpoilerMode
+	ChatSettingsUnknown
+	UnknownModel
+
+	// Languages.
+	Language
+	LanguageUpdated
+	LanguageUnknown
+	LanguageNotConfigured
+
+	// Inline mode.
+	InlineHelp
+	InlineRendering
+
+	// Lists.
+	DefaultListItem
+	AvailableModels
+	NoModels
+	AvailableSamplers
+	NoSamplers
+	AvailableEmbeddings
+	NoEmbeddings
+	AvailableLoRAs
+	NoLoRAs
+	AvailableUpscalers
+	NoUpscalers
+	AvailableVAEs
+	NoVAEs
+	LoRAInfo
+	LoRAAlias
+	LoRABaseModel
+	LoRATriggerWords
+	LoRAUsage
+	GettingModelsError
+	GettingSamplersError
+	GettingSchedulersError
+	GettingEmbeddingsError
+	GettingLoRAsError
+	GettingUpscalersError
+	NoDefaultUpscaler
+	NoDefaultSampler
+	GettingVAEsError
+	NvidiaSMIError
+	Refreshed
+	RefreshNotSupported
+	RefreshError
+
+	// Render params.
+	DidYouMean
+	ParamsAfterPrompt
+	AttrError
+	AttrNoValue
+	AttrNotApplicable
+	AmbiguousValue
+	MissingValue
+	OutOfRange
+	CFGScaleOutOfRange
+	InvalidAspectRatio
+	InvalidAspectRatioWidth
+	InvalidAspectRatioHeight
+	AspectRatioWithSize
+	QualityWithWebP
+	InvalidFraction
+	InvalidNumber
+	InvalidSeed
+	InvalidWidth
+	InvalidHeight
+	InvalidSteps
+	InvalidBatchSize
+	InvalidCnt
+	InvalidFormat
+	InvalidQuality
+	InvalidCFGScale
+	InvalidSampler
+	InvalidModel
+	InvalidRefinerSwitch
+	InvalidRefinerModel
+	InvalidVAE
+	InvalidClipSkip
+	InvalidEta
+	InvalidScheduler
+	InvalidHRScale
+	InvalidUpscaleRatio
+	InvalidHRDenoise
+	InvalidHRSteps
+	InvalidUpscaler
+	InvalidUpscaler2Visibility
+	InvalidTargetSize
+	TargetSizeTooLarge
+	HRSizeTooLarge
+	UpscaledSizeTooLarge
+	InvalidGFPGAN
+	InvalidCodeFormer
+	InvalidCodeFormerWeight
+	InvalidLoRAWeight
+	MissingLoRAName
+	UnknownLoRA
+	EmbeddingHint
+
+	// Names of the params in the out of range errors.
+	ParamWidth
+	ParamHeight
+	ParamSteps
+	ParamCnt
+	ParamBatchSize
+	ParamHRSteps
+
+	// Help.
+	HelpCommands
+	HelpAdminCommands
+	HelpTriggers
+	HelpRenderAttrs
+	HelpUpscaleAttrs
+	HelpFooter
+	AttrUsage
+	AttrAliases
+	AttrUsedWith
+	UnknownAttr
+
+	// Command descriptions.
+	CmdSD
+	CmdUpscale
+	CmdEnhance
+	CmdCancel
+	CmdModels
+	CmdSamplers
+	CmdEmbeddings
+	CmdLoRAs
+	CmdUpscalers
+	CmdVAEs
+	CmdChatSettings
+	CmdLang
+	CmdSMI
+	CmdRefresh
+	CmdHelp
+
+	// Render and upscale parameter descriptions.
+	AttrSeed
+	AttrWidth
+	AttrHeight
+	AttrAspectRatio
+	AttrPortrait
+	AttrLandscape
+	AttrSquare
+	AttrSteps
+	AttrCnt
+	AttrBatch
+	AttrPNG
+	AttrFormat
+	AttrQuality
+	AttrDocument
+	AttrPhoto
+	AttrCFGScale
+	AttrSampler
+	AttrModel
+	AttrLoRA
+	AttrVAE
+	AttrClipSkip
+	AttrEta
+	AttrScheduler
+	AttrRefiner
+	AttrRestoreFaces
+	AttrTiling
+	AttrEnhance
+	AttrUpscale
+	AttrUpscaler
+	AttrUpscaler2
+	AttrUpscaler2Visibility
+	AttrTo
+	AttrCrop
+	AttrGFPGAN
+	AttrCodeFormer
+	AttrCodeFormerWeight
+	AttrHR
+	AttrHRDenoise
+	AttrHRUpscaler
+	AttrHRSteps
+
+	// Config validation.
+	ConfigBotTokenNotSet
+	ConfigInvalidUserID
+	ConfigInvalidAdminID
+	ConfigInvalidGroupID
+	ConfigUnknownBackend
+	ConfigInvalidOutputFormat
+	ConfigInvalidQuality
+	ConfigInvalidSendAs
+	ConfigInvalidNSFWThreshold
+	ConfigInvalidLimits
+)
+
+// Keys with plural forms, used with Lang.N.
+var pluralKeys = []Key{QueuePosition, BatchProgress, NSFWWithheld}
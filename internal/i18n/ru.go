@@ -0,0 +1,252 @@
+package i18n
+
+var ru = map[Key]string{
+	Start: "🤖 Добро пожаловать! Это Telegram бот " +
+		"для генерации изображений с помощью Stable Diffusion.\n\nПодробнее:" +
+		" https://github.com/kanootoko/stable-diffusion-telegram-bot",
+	BotStartedToAdmins: "🤖 Бот запущен, версия %s, %s",
+	UsageNotAllowed:    "Чтобы пользоваться ботом, обратитесь к его владельцу",
+	Error:              "❌ Ошибка: %s",
+	Done:               "✅ Готово",
+	Canceled:           "⭕ Отменено",
+
+	QueuePosition:          "👨‍👦‍👦 Запрос в очереди на позиции #%d, перед ним %d запрос|👨‍👦‍👦 Запрос в очереди на позиции #%d, перед ним %d запроса|👨‍👦‍👦 Запрос в очереди на позиции #%d, перед ним %d запросов",
+	RequestUpdated:         "✏️ Запрос обновлён",
+	ImageReq:               "🩻 Отправьте файл изображения для обработки.",
+	ProcessStart:           "🛎 Начинаю генерацию...",
+	Process:                "🔨 Генерация %s осталось: %s",
+	Downloading:            "⬇ Загрузка... %s",
+	DownloadDone:           "✅ Загрузка завершена",
+	Uploading:              "☁ Отправка...",
+	BatchProgress:          "📦 Пакет %d/%d, отправлено %d изображение|📦 Пакет %d/%d, отправлено %d изображения|📦 Пакет %d/%d, отправлено %d изображений",
+	OOMRetry:               "🧠 Не хватило памяти GPU, повтор: %s",
+	OOMDowngradeBatchSize:  "размер пакета %d",
+	OOMDowngradeHRDisabled: "highres отключён",
+	NSFWWithheld:           "🚫 %d изображение отмечено как NSFW и скрыто|🚫 %d изображения отмечены как NSFW и скрыты|🚫 %d изображений отмечены как NSFW и скрыты",
+	NSFWWithheldToAdmins:   "🚫 Скрыто NSFW изображений: %d, от @%s #%d в чате %d, запрос: %s",
+	NoActiveRequest:        "нет активного запроса для отмены",
+	SDNotRunning:           "Stable Diffusion не запущен, а запуск отключён",
+	Timeout:                "превышено время ожидания",
+	ImageWaitTimeout:       "превышено время ожидания изображения",
+	NoImageData:            "изображение не получено",
+	InvalidImage:           "не удалось прочитать изображение: %s",
+	CantGetFile:            "не удалось получить файл: %s",
+
+	APIError:               "ошибка Stable Diffusion: %s",
+	APIErrorOOM:            "🧠 Не хватило памяти GPU: попробуйте уменьшить размер, размер пакета или коэффициент highres",
+	APIErrorMissingModel:   "модель не найдена: доступные модели можно посмотреть в /models",
+	APIErrorInvalidSampler: "неверный сэмплер: доступные сэмплеры можно посмотреть в /samplers",
+	APIErrorBusy:           "Stable Diffusion занят: попробуйте позже",
+	APIErrorValidation:     "неверные параметры запроса: %s",
+	NotSupported:           "не поддерживается бэкендом %s: %s",
+
+	EmptyRequest:             "Запрос пустой, генерация пропущена",
+	MissingPrompt:            "не указан запрос",
+	CantParseParams:          "не удалось разобрать параметры: %s",
+	CantProcessPrompt:        "не удалось обработать запрос: %s",
+	ModelNotAllowed:          "модель %s запрещена в этом чате, разрешённые модели: %s",
+	PromptBlocked:            "🚫 Запрос содержит слова, запрещённые в этом чате",
+	EditedMessageUnavailable: "изменённое сообщение недоступно",
+	RerenderNotOwner:         "перегенерировать может только автор запроса",
+	RerenderEdited:           "✏️ Запрос был изменён после начала генерации.",
+	RerenderButton:           "🔁 Сгенерировать с изменённым запросом",
+
+	Enhancing:            "✨ Улучшаю запрос...",
+	EnhancedPrompt:       "✨ Улучшенный запрос:",
+	EnhanceRenderButton:  "🎨 Сгенерировать",
+	EnhanceEditButton:    "✏ Изменить",
+	EnhanceEdit:          "✏ Скопируйте команду, измените её и отправьте обратно:",
+	EnhanceNotConfigured: "улучшение запросов не настроено",
+	CantEnhance:          "не удалось улучшить запрос: %s",
+
+	ChatSettings:                   "⚙ Настройки чата:",
+	ChatSettingsUpdated:            "✅ Настройки чата обновлены:",
+	ChatSettingsGroupOnly:          "настройки чата доступны только в групповых чатах",
+	ChatSettingsNotConfigured:      "настройки чатов не настроены",
+	ChatSettingsAdminsOnly:         "изменять настройки могут только админы чата",
+	ChatSettingsMissingValue:       "не указано значение",
+	ChatSettingsInvalidMax:         "неверное значение, должно быть от %d до %d",
+	ChatSettingsInvalidOnOff:       "неверное значение, допустимые значения: on и off",
+	ChatSettingsInvalidInterval:    "неверное значение, должно быть количеством секунд, не меньше %d",
+	ChatSettingsInvalidSpoilerMode: "неверное значение, допустимые значения: all, spoiler, blur и withhold",
+	ChatSettingsUnknown:            "неизвестная настройка %s, доступные настройки: %s",
+	UnknownModel:                   "неизвестная модель %s, доступные значения: /models",
+
+	Language:              "🌐 Язык: %s\nДоступные языки: %s\n\nИзменить его можно командой <code>/lang en</code>, или использовать язык приложения Telegram командой <code>/lang auto</code>",
+	LanguageUpdated:       "🌐 Установлен язык: %s",
+	LanguageUnknown:       "неизвестный язык %s, доступные языки: %s",
+	LanguageNotConfigured: "настройки языка не настроены",
+
+	InlineHelp:      "🎨 Введите запрос для генерации",
+	InlineRendering: "⏳ Генерация, введите запрос ещё раз, чтобы увидеть результаты",
+
+	DefaultListItem:        "- <b>%s</b> (по умолчанию)",
+	AvailableModels:        "🧩 Доступные модели:\n%s",
+	NoModels:               "Нет доступных моделей.",
+	AvailableSamplers:      "🔭 Доступные сэмплеры:\n%s",
+	NoSamplers:             "Нет доступных сэмплеров.",
+	AvailableEmbeddings:    "Доступные эмбеддинги: %s",
+	NoEmbeddings:           "Нет доступных эмбеддингов.",
+	AvailableLoRAs:         "Доступные LoRA: %s",
+	NoLoRAs:                "Нет доступных LoRA.",
+	AvailableUpscalers:     "🔎 Доступные апскейлеры: %s",
+	NoUpscalers:            "🔎 Нет доступных апскейлеров.",
+	AvailableVAEs:          "Доступные VAE: %s",
+	NoVAEs:                 "Нет доступных VAE.",
+	LoRAInfo:               "LoRA <code>%s</code>",
+	LoRAAlias:              "Псевдоним: <code>%s</code>",
+	LoRABaseModel:          "Базовая модель: %s",
+	LoRATriggerWords:       "Ключевые слова: <code>%s</code>",
+	LoRAUsage:              "Использование: <code>-lora %s:1</code>",
+	GettingModelsError:     "ошибка получения моделей: %s",
+	GettingSamplersError:   "ошибка получения сэмплеров: %s",
+	GettingSchedulersError: "ошибка получения планировщиков: %s",
+	GettingEmbeddingsError: "ошибка получения эмбеддингов: %s",
+	GettingLoRAsError:      "ошибка получения LoRA: %s",
+	GettingUpscalersError:  "ошибка получения апскейлеров: %s",
+	NoDefaultUpscaler:      "нет апскейлера по умолчанию, у бэкенда нет апскейлеров",
+	NoDefaultSampler:       "нет сэмплера по умолчанию, у бэкенда нет сэмплеров",
+	GettingVAEsError:       "ошибка получения VAE: %s",
+	NvidiaSMIError:         "ошибка запуска nvidia-smi: %s",
+	Refreshed:              "🔄 Списки обновлены, моделей: %d, LoRA: %d",
+	RefreshNotSupported:    "обновление списков не поддерживается бэкендом",
+	RefreshError:           "ошибка обновления списков: %s",
+
+	DidYouMean:                 ", возможно вы имели в виду %s?",
+	ParamsAfterPrompt:          "параметры должны быть после запроса",
+	AttrError:                  "<code>%s</code>: %s",
+	AttrNoValue:                "этот параметр не принимает значение",
+	AttrNotApplicable:          "%s можно использовать только с %s",
+	AmbiguousValue:             "неоднозначное значение, подходят: %s",
+	MissingValue:               "не указано значение",
+	OutOfRange:                 "%s %d вне допустимого диапазона %d-%d",
+	CFGScaleOutOfRange:         "CFG scale %.1f вне допустимого диапазона %.1f-%.1f",
+	InvalidAspectRatio:         "неверное соотношение сторон, используйте формат Ш:В",
+	InvalidAspectRatioWidth:    "неверная ширина в соотношении сторон",
+	InvalidAspectRatioHeight:   "неверная высота в соотношении сторон",
+	AspectRatioWithSize:        "соотношение сторон нельзя использовать, если заданы и ширина, и высота",
+	QualityWithWebP:            "качество нельзя задать для изображений WebP, они сохраняются без потерь",
+	InvalidFraction:            "значение должно быть от 0 до 1",
+	InvalidNumber:              "значение должно быть числом",
+	InvalidSeed:                "неверный сид",
+	InvalidWidth:               "неверная ширина",
+	InvalidHeight:              "неверная высота",
+	InvalidSteps:               "неверное количество шагов",
+	InvalidBatchSize:           "неверный размер пакета",
+	InvalidCnt:                 "неверное количество изображений",
+	InvalidFormat:              "неверный формат, допустимые значения: jpg, png и webp",
+	InvalidQuality:             "неверное качество, допустимые значения: 1-100",
+	InvalidCFGScale:            "неверный CFG scale",
+	InvalidSampler:             "неверный сэмплер%s",
+	InvalidModel:               "неверная модель%s",
+	InvalidRefinerSwitch:       "неверная точка переключения рефайнера, допустимые значения от 0 до 1",
+	InvalidRefinerModel:        "неверная модель рефайнера%s",
+	InvalidVAE:                 "неверный VAE%s",
+	InvalidClipSkip:            "неверный clip skip, допустимые значения: 1-12",
+	InvalidEta:                 "неверный eta, допустимые значения: 0-1",
+	InvalidScheduler:           "неверный планировщик%s",
+	InvalidHRScale:             "неверный коэффициент highres",
+	InvalidUpscaleRatio:        "неверный коэффициент увеличения",
+	InvalidHRDenoise:           "неверная сила шумоподавления highres, допустимые значения: 0-1",
+	InvalidHRSteps:             "неверное количество шагов второго прохода highres",
+	InvalidUpscaler:            "неверный апскейлер%s",
+	InvalidUpscaler2Visibility: "неверная видимость второго апскейлера: %s",
+	InvalidTargetSize:          "неверный размер, используйте формат ШxВ",
+	TargetSizeTooLarge:         "слишком большой размер, максимум %dx%d",
+	HRSizeTooLarge:             "слишком большой размер highres %dx%d, максимум %dx%d",
+	UpscaledSizeTooLarge:       "слишком большой размер после увеличения %dx%d, максимум %dx%d",
+	InvalidGFPGAN:              "неверная видимость GFPGAN: %s",
+	InvalidCodeFormer:          "неверная видимость CodeFormer: %s",
+	InvalidCodeFormerWeight:    "неверный вес CodeFormer: %s",
+	InvalidLoRAWeight:          "неверный вес LoRA",
+	MissingLoRAName:            "не указано имя LoRA",
+	UnknownLoRA:                "неизвестная LoRA %s%s",
+	EmbeddingHint:              "💡 %s — не эмбеддинг, возможно вы имели в виду %s?",
+
+	ParamWidth:     "ширина",
+	ParamHeight:    "высота",
+	ParamSteps:     "количество шагов",
+	ParamCnt:       "количество изображений",
+	ParamBatchSize: "размер пакета",
+	ParamHRSteps:   "шаги второго прохода highres",
+
+	HelpCommands:      "🤖 Stable Diffusion Telegram бот\n\nДоступные команды:\n",
+	HelpAdminCommands: "Команды администраторов:\n",
+	HelpTriggers:      "Генерацию также можно запустить, упомянув бота или ответив на сгенерированное изображение новым запросом или параметрами.",
+	HelpRenderAttrs:   "Параметры генерации в конце запроса, в виде <code>-имя значение</code> или <code>-имя=значение</code>:\n",
+	HelpUpscaleAttrs:  "Параметры увеличения:\n",
+	HelpFooter: "Отправьте <code>/help параметр</code> (например, <code>/help hr</code>), чтобы получить справку по параметру.\n\n" +
+		"Подробнее: https://github.com/kanootoko/stable-diffusion-telegram-bot",
+	AttrUsage:    "Пример: <code>%s</code>",
+	AttrAliases:  "Сокращения: %s",
+	AttrUsedWith: "Используется с: %s",
+	UnknownAttr:  "неизвестный параметр %s%s",
+
+	CmdSD:           "сгенерировать изображение (негативный запрос можно написать на следующей строке)",
+	CmdUpscale:      "увеличить изображение",
+	CmdEnhance:      "развернуть короткую идею в подробный запрос",
+	CmdCancel:       "отменить текущий запрос",
+	CmdModels:       "список доступных моделей",
+	CmdSamplers:     "список доступных сэмплеров",
+	CmdEmbeddings:   "список доступных эмбеддингов",
+	CmdLoRAs:        "список доступных LoRA или информация о LoRA",
+	CmdUpscalers:    "список доступных апскейлеров",
+	CmdVAEs:         "список доступных VAE",
+	CmdChatSettings: "показать или изменить настройки группового чата",
+	CmdLang:         "показать или изменить язык сообщений бота",
+	CmdSMI:          "вывод nvidia-smi",
+	CmdRefresh:      "пересканировать модели и LoRA и обновить списки",
+	CmdHelp:         "показать справку или справку по параметру",
+
+	AttrSeed:                "задать сид",
+	AttrWidth:               "задать ширину изображения",
+	AttrHeight:              "задать высоту изображения",
+	AttrAspectRatio:         "задать соотношение сторон (например, 16:9), разрешение вычисляется для модели",
+	AttrPortrait:            "соотношение сторон 2:3",
+	AttrLandscape:           "соотношение сторон 3:2",
+	AttrSquare:              "соотношение сторон 1:1",
+	AttrSteps:               "задать количество шагов",
+	AttrCnt:                 "задать количество изображений",
+	AttrBatch:               "задать размер пакета изображений",
+	AttrPNG:                 "загружать PNG вместо JPEG",
+	AttrFormat:              "задать формат (jpg, png или webp, webp без потерь)",
+	AttrQuality:             "задать качество JPEG (1-100)",
+	AttrDocument:            "загружать изображения как документы",
+	AttrPhoto:               "загружать изображения как фото",
+	AttrCFGScale:            "задать CFG scale",
+	AttrSampler:             "задать сэмплер, доступные значения: /samplers",
+	AttrModel:               "задать модель, доступные значения: /models",
+	AttrLoRA:                "добавить LoRA с весом (имя:вес), можно использовать несколько раз",
+	AttrVAE:                 "задать VAE, доступные значения: /vaes",
+	AttrClipSkip:            "задать количество пропускаемых последних слоёв CLIP (1-12)",
+	AttrEta:                 "задать eta сэмплера (множитель шума)",
+	AttrScheduler:           "задать планировщик сэмплера",
+	AttrRefiner:             "задать модель рефайнера и точку переключения (по умолчанию 0.8)",
+	AttrRestoreFaces:        "включить восстановление лиц",
+	AttrTiling:              "создавать бесшовные изображения",
+	AttrEnhance:             "развернуть запрос в подробный перед генерацией",
+	AttrUpscale:             "увеличить изображение в заданное число раз",
+	AttrUpscaler:            "задать апскейлер, доступные значения: /upscalers",
+	AttrUpscaler2:           "задать второй апскейлер",
+	AttrUpscaler2Visibility: "задать видимость второго апскейлера (0-1)",
+	AttrTo:                  "увеличить до заданного размера вместо множителя",
+	AttrCrop:                "обрезать по размеру, заданному -to",
+	AttrGFPGAN:              "задать видимость восстановления лиц GFPGAN (0-1)",
+	AttrCodeFormer:          "задать видимость восстановления лиц CodeFormer (0-1)",
+	AttrCodeFormerWeight:    "задать вес CodeFormer (0-1)",
+	AttrHR:                  "включить режим highres и задать множитель увеличения",
+	AttrHRDenoise:           "задать силу шумоподавления режима highres",
+	AttrHRUpscaler:          "задать апскейлер режима highres, доступные значения: /upscalers",
+	AttrHRSteps:             "задать количество шагов второго прохода режима highres",
+
+	ConfigBotTokenNotSet:       "не задан токен бота",
+	ConfigInvalidUserID:        "список разрешённых пользователей содержит неверный ID: %s",
+	ConfigInvalidAdminID:       "список администраторов содержит неверный ID: %s",
+	ConfigInvalidGroupID:       "список разрешённых групп содержит неверный ID: %s",
+	ConfigUnknownBackend:       "неизвестный бэкенд: %s",
+	ConfigInvalidOutputFormat:  "неверный формат по умолчанию: %s",
+	ConfigInvalidQuality:       "качество по умолчанию должно быть от 1 до 100",
+	ConfigInvalidSendAs:        "неверный режим загрузки по умолчанию: %s",
+	ConfigInvalidNSFWThreshold: "порог NSFW должен быть от 0 до 1",
+	ConfigInvalidLimits:        "в ограничениях генерации минимум больше максимума",
+}
@@ -0,0 +1,62 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
+)
+
+// Store keeps the languages chosen by the users with /lang in a JSON file.
+type Store struct {
+	path  string
+	mutex sync.Mutex
+	users map[int64]Lang
+}
+
+// Loads the languages from the file at path, the file is created on the first change.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, users: make(map[int64]Lang)}
+	d, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read user languages: %w", err)
+	}
+	if err = json.Unmarshal(d, &s.users); err != nil {
+		return nil, fmt.Errorf("can't parse user languages: %w", err)
+	}
+	return s, nil
+}
+
+// Returns the language chosen by the user, or false if the user has not chosen one.
+func (s *Store) Get(userID int64) (Lang, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	lang, ok := s.users[userID]
+	return lang, ok
+}
+
+// Stores the language of the user, an empty language removes the user's choice.
+func (s *Store) Set(userID int64, lang Lang) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lang == "" {
+		delete(s.users, userID)
+	} else {
+		s.users[userID] = lang
+	}
+
+	d, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = utils.WriteFileAtomic(s.path, d); err != nil {
+		return fmt.Errorf("can't save user languages: %w", err)
+	}
+	return nil
+}
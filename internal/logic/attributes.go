@@ -0,0 +1,199 @@
+package logic
+
+import (
+	"math"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+// Request types an attribute can be used with.
+type attrScope int
+
+const (
+	attrRender attrScope = 1 << iota
+	attrUpscale
+
+	attrAll = attrRender | attrUpscale
+)
+
+// attribute is a render or upscale parameter given as "-name value" or "-name=value" after the
+// prompt.
+type attribute struct {
+	// The first name is the canonical one, the others are aliases.
+	names []string
+	// Example value shown in the help, empty for flags.
+	example string
+	scope   attrScope
+	help    i18n.Key
+	// Flags are given without a value.
+	flag  bool
+	parse attrParseFunc
+}
+
+// All attributes, in the order they're listed in the help.
+var attributes = []attribute{
+	{names: []string{"seed", "s"}, example: "1234", scope: attrRender, help: i18n.AttrSeed,
+		parse: parseSeed},
+	{names: []string{"width", "w"}, example: "768", scope: attrRender, help: i18n.AttrWidth,
+		parse: intAttr(i18n.InvalidWidth, limitRange(i18n.ParamWidth, func(l config.GenerationLimits) (int, int) { return l.MinWidth, l.MaxWidth }),
+			func(p *attrParser, v int) { p.render.Width = v })},
+	{names: []string{"height", "h"}, example: "768", scope: attrRender, help: i18n.AttrHeight,
+		parse: intAttr(i18n.InvalidHeight, limitRange(i18n.ParamHeight, func(l config.GenerationLimits) (int, int) { return l.MinHeight, l.MaxHeight }),
+			func(p *attrParser, v int) { p.render.Height = v })},
+	{names: []string{"ar", "aspect-ratio"}, example: "16:9", scope: attrRender, help: i18n.AttrAspectRatio,
+		parse: parseAspectRatioAttr},
+	{names: []string{"portrait"}, scope: attrRender, help: i18n.AttrPortrait, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.aspectRatioW, p.aspectRatioH = 2, 3 })},
+	{names: []string{"landscape"}, scope: attrRender, help: i18n.AttrLandscape, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.aspectRatioW, p.aspectRatioH = 3, 2 })},
+	{names: []string{"square"}, scope: attrRender, help: i18n.AttrSquare, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.aspectRatioW, p.aspectRatioH = 1, 1 })},
+	{names: []string{"steps", "t"}, example: "30", scope: attrRender, help: i18n.AttrSteps,
+		parse: intAttr(i18n.InvalidSteps, limitRange(i18n.ParamSteps, func(l config.GenerationLimits) (int, int) { return l.MinSteps, l.MaxSteps }),
+			func(p *attrParser, v int) { p.render.Steps = v })},
+	{names: []string{"cnt", "o"}, example: "4", scope: attrRender, help: i18n.AttrCnt,
+		parse: intAttr(i18n.InvalidCnt, limitRange(i18n.ParamCnt, func(l config.GenerationLimits) (int, int) { return l.MinCnt, l.MaxCnt }),
+			func(p *attrParser, v int) { p.render.NumOutputs = v })},
+	{names: []string{"batch", "b"}, example: "2", scope: attrRender, help: i18n.AttrBatch,
+		parse: intAttr(i18n.InvalidBatchSize, limitRange(i18n.ParamBatchSize, func(l config.GenerationLimits) (int, int) { return l.MinBatch, l.MaxBatch }),
+			func(p *attrParser, v int) { p.render.BatchSize = v })},
+	{names: []string{"png", "p"}, scope: attrAll, help: i18n.AttrPNG, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.output.Format = "png" })},
+	{names: []string{"format"}, example: "webp", scope: attrAll, help: i18n.AttrFormat,
+		parse: parseFormat},
+	{names: []string{"quality", "q"}, example: "90", scope: attrAll, help: i18n.AttrQuality,
+		parse: intAttr(i18n.InvalidQuality, fixedRange(1, 100, i18n.InvalidQuality), func(p *attrParser, v int) { p.output.Quality = v })},
+	{names: []string{"doc", "document"}, scope: attrAll, help: i18n.AttrDocument, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.output.AsDocument = true })},
+	{names: []string{"photo"}, scope: attrAll, help: i18n.AttrPhoto, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.output.AsDocument = false })},
+	{names: []string{"cfg", "c"}, example: "7.5", scope: attrRender, help: i18n.AttrCFGScale,
+		parse: floatAttr(i18n.InvalidCFGScale, cfgScaleRange, func(p *attrParser, v float64) { p.render.CFGScale = v })},
+	{names: []string{"sampler", "r"}, example: "Euler", scope: attrRender, help: i18n.AttrSampler,
+		parse: nameAttr(samplerList, i18n.InvalidSampler, func(p *attrParser, v string) { p.render.SamplerName = v })},
+	{names: []string{"model", "m"}, example: "sd_xl_base_1.0", scope: attrRender, help: i18n.AttrModel,
+		parse: nameAttr(modelList, i18n.InvalidModel, func(p *attrParser, v string) { p.render.ModelName = v })},
+	{names: []string{"lora"}, example: "add_detail:0.5", scope: attrRender, help: i18n.AttrLoRA,
+		parse: parseLoRAAttr},
+	{names: []string{"vae"}, example: "Automatic", scope: attrRender, help: i18n.AttrVAE,
+		parse: listAttr(vaeList, i18n.InvalidVAE, func(p *attrParser, v string) { p.render.VAE = v })},
+	{names: []string{"clipskip"}, example: "2", scope: attrRender, help: i18n.AttrClipSkip,
+		parse: intAttr(i18n.InvalidClipSkip, fixedRange(1, 12, i18n.InvalidClipSkip), func(p *attrParser, v int) { p.render.ClipSkip = v })},
+	{names: []string{"eta"}, example: "0.5", scope: attrRender, help: i18n.AttrEta,
+		parse: floatAttr(i18n.InvalidEta, fixedFloatRange(0, 1, i18n.InvalidEta), func(p *attrParser, v float64) { p.render.Eta = v })},
+	{names: []string{"scheduler"}, example: "Karras", scope: attrRender, help: i18n.AttrScheduler,
+		parse: parseScheduler},
+	{names: []string{"refiner"}, example: "sd_xl_refiner_1.0:0.8", scope: attrRender, help: i18n.AttrRefiner,
+		parse: parseRefiner},
+	{names: []string{"restorefaces"}, scope: attrRender, help: i18n.AttrRestoreFaces, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.render.RestoreFaces = true })},
+	{names: []string{"tiling"}, scope: attrRender, help: i18n.AttrTiling, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.render.Tiling = true })},
+	{names: []string{"enhance"}, scope: attrRender, help: i18n.AttrEnhance, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.render.Enhance = true })},
+	{names: []string{"upscale", "u"}, example: "2", scope: attrAll, help: i18n.AttrUpscale,
+		parse: floatAttr(i18n.InvalidUpscaleRatio, fixedFloatRange(0, math.MaxFloat32, i18n.InvalidUpscaleRatio), func(p *attrParser, v float64) {
+			if p.render != nil {
+				p.render.Upscale.Scale = float32(v)
+			} else {
+				p.upscale.Scale = float32(v)
+			}
+		})},
+	{names: []string{"upscaler"}, example: "ESRGAN_4x", scope: attrAll, help: i18n.AttrUpscaler,
+		parse: nameAttr(upscalerList, i18n.InvalidUpscaler, (*attrParser).setUpscaler)},
+	{names: []string{"upscaler2"}, example: "ESRGAN_4x", scope: attrUpscale, help: i18n.AttrUpscaler2,
+		parse: nameAttr(upscalerList, i18n.InvalidUpscaler, func(p *attrParser, v string) { p.upscale.Upscaler2 = v })},
+	{names: []string{"upscaler2-visibility", "u2v"}, example: "0.5", scope: attrUpscale, help: i18n.AttrUpscaler2Visibility,
+		parse: unitAttr(i18n.InvalidUpscaler2Visibility, func(p *attrParser, v float32) { p.upscale.Upscaler2Visibility = v })},
+	{names: []string{"to"}, example: "2048x2048", scope: attrUpscale, help: i18n.AttrTo,
+		parse: parseTargetSize},
+	{names: []string{"crop"}, scope: attrUpscale, help: i18n.AttrCrop, flag: true,
+		parse: flagAttr(func(p *attrParser) { p.upscale.Crop = true })},
+	{names: []string{"gfpgan"}, example: "0.8", scope: attrUpscale, help: i18n.AttrGFPGAN,
+		parse: unitAttr(i18n.InvalidGFPGAN, func(p *attrParser, v float32) { p.upscale.GFPGANVisibility = v })},
+	{names: []string{"codeformer"}, example: "0.8", scope: attrUpscale, help: i18n.AttrCodeFormer,
+		parse: unitAttr(i18n.InvalidCodeFormer, func(p *attrParser, v float32) { p.upscale.CodeFormerVisibility = v })},
+	{names: []string{"codeformer-weight", "cfw"}, example: "0.5", scope: attrUpscale, help: i18n.AttrCodeFormerWeight,
+		parse: unitAttr(i18n.InvalidCodeFormerWeight, func(p *attrParser, v float32) { p.upscale.CodeFormerWeight = v })},
+	{names: []string{"hr"}, example: "2", scope: attrRender, help: i18n.AttrHR,
+		parse: floatAttr(i18n.InvalidHRScale, fixedFloatRange(0, math.MaxFloat32, i18n.InvalidHRScale), func(p *attrParser, v float64) { p.render.HR.Scale = float32(v) })},
+	{names: []string{"hr-denoisestrength", "hrd"}, example: "0.5", scope: attrRender, help: i18n.AttrHRDenoise,
+		parse: floatAttr(i18n.InvalidHRDenoise, fixedFloatRange(0, 1, i18n.InvalidHRDenoise), func(p *attrParser, v float64) { p.render.HR.DenoisingStrength = float32(v) })},
+	{names: []string{"hr-upscaler", "hru"}, example: "Latent", scope: attrRender, help: i18n.AttrHRUpscaler,
+		parse: nameAttr(upscalerList, i18n.InvalidUpscaler, func(p *attrParser, v string) { p.render.HR.Upscaler = v })},
+	{names: []string{"hr-steps", "hrt"}, example: "10", scope: attrRender, help: i18n.AttrHRSteps,
+		parse: intAttr(i18n.InvalidHRSteps, limitRange(i18n.ParamHRSteps, func(l config.GenerationLimits) (int, int) { return 0, l.MaxSteps }),
+			func(p *attrParser, v int) { p.render.HR.SecondPassSteps = v })},
+}
+
+// Returns the attribute with the given name or alias.
+func findAttribute(name string) (attribute, bool) {
+	name = strings.ToLower(name)
+	for _, a := range attributes {
+		for _, n := range a.names {
+			if n == name {
+				return a, true
+			}
+		}
+	}
+	return attribute{}, false
+}
+
+func attributeNames() (names []string) {
+	for _, a := range attributes {
+		names = append(names, a.names...)
+	}
+	return names
+}
+
+// Returns the names in the "-name/alias" form.
+func (a attribute) usage() string {
+	return "-" + strings.Join(a.names, "/")
+}
+
+// Returns an example request using the attribute.
+func (a attribute) exampleRequest() string {
+	s := "-" + a.names[0]
+	if !a.flag {
+		s += " " + a.example
+	}
+	if a.scope&attrRender == 0 {
+		return "/upscale " + s
+	}
+	return "a cat " + s
+}
+
+// Returns the help lines of the attributes usable in the scope.
+func attributesHelp(lang i18n.Lang, scope attrScope) string {
+	var lines []string
+	for _, a := range attributes {
+		if a.scope&scope != 0 {
+			lines = append(lines, a.usage()+" - "+lang.T(a.help))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the detailed help of the attribute.
+func (a attribute) details(lang i18n.Lang) string {
+	text := "<code>-" + a.names[0] + "</code> - " + lang.T(a.help)
+	if len(a.names) > 1 {
+		text += "\n" + lang.T(i18n.AttrAliases, "-"+strings.Join(a.names[1:], ", -"))
+	}
+	text += "\n" + lang.T(i18n.AttrUsage, a.exampleRequest())
+	return text + "\n" + lang.T(i18n.AttrUsedWith, a.commands())
+}
+
+// Returns the commands the attribute can be used with, like "/sd, /upscale".
+func (a attribute) commands() string {
+	var commands []string
+	if a.scope&attrRender != 0 {
+		commands = append(commands, "/sd")
+	}
+	if a.scope&attrUpscale != 0 {
+		commands = append(commands, "/upscale")
+	}
+	return strings.Join(commands, ", ")
+}
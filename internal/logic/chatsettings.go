@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"golang.org/x/exp/slices"
+)
+
+// Returns true if the user of the message is an admin of the chat or of the bot.
+func (c *CmdHandler) isChatAdmin(ctx context.Context, msg *models.Message) bool {
+	if c.us.IsAdmin(msg.From.ID) {
+		return true
+	}
+	isAdmin, err := c.bot.IsChatAdmin(ctx, msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		fmt.Println("  error getting chat member:", err)
+	}
+	return isAdmin
+}
+
+func (c *CmdHandler) chatSettingsCmd(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
+	if msg.Chat.ID >= 0 {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.ChatSettingsGroupOnly)))
+		return
+	}
+	if c.chatSettings == nil {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.ChatSettingsNotConfigured)))
+		return
+	}
+
+	settings := c.chatSettings.Get(msg.Chat.ID)
+	args := strings.TrimSpace(removeBotName(msg.Text))
+	if args == "" {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.ChatSettings)+"\n<code>"+html.EscapeString(settings.String())+"</code>")
+		return
+	}
+
+	if !c.isChatAdmin(ctx, msg) {
+		fmt.Println("  user is not a chat admin")
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.ChatSettingsAdminsOnly)))
+		return
+	}
+
+	key, value, _ := strings.Cut(args, " ")
+	if err := settings.Update(key, value, c.limits); err != nil {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, err))
+		return
+	}
+	if key == "models" && len(settings.AllowedModels) > 0 {
+		models, err := c.sdApi.GetModels(ctx)
+		if err != nil {
+			fmt.Println("  error getting models:", err)
+			c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingModelsError, err)))
+			return
+		}
+		for _, m := range settings.AllowedModels {
+			if !slices.Contains(models, m) {
+				c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.UnknownModel, m)))
+				return
+			}
+		}
+	}
+
+	if err := c.chatSettings.Set(msg.Chat.ID, settings); err != nil {
+		fmt.Println("  error saving chat settings:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, err))
+		return
+	}
+	fmt.Println("  chat settings updated:", key, value)
+	c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.ChatSettingsUpdated)+"\n<code>"+html.EscapeString(settings.String())+"</code>")
+}
@@ -0,0 +1,139 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+// command is a bot command, like /sd.
+type command struct {
+	name string
+	// Arguments shown in the help.
+	args string
+	help i18n.Key
+	// Admin commands are only listed for and usable by the bot admins.
+	admin bool
+	// Hidden commands are not listed, like aliases.
+	hidden  bool
+	handler func(ctx context.Context, msg *models.Message)
+}
+
+// Returns all commands, in the order they're listed in the help and in Telegram clients.
+func (c *CmdHandler) commands() []command {
+	return []command{
+		{name: "start", hidden: true, handler: c.Start},
+		{name: "sd", args: "[prompt]", help: i18n.CmdSD, handler: c.txt2img},
+		{name: "txt2img", hidden: true, handler: c.txt2img},
+		{name: "upscale", help: i18n.CmdUpscale, handler: c.upscale},
+		{name: "enhance", args: "[idea]", help: i18n.CmdEnhance, handler: c.enhance},
+		{name: "cancel", help: i18n.CmdCancel, handler: c.cancel},
+		{name: "models", help: i18n.CmdModels, handler: c.listModels},
+		{name: "samplers", help: i18n.CmdSamplers, handler: c.listSamplers},
+		{name: "embeddings", help: i18n.CmdEmbeddings, handler: c.listEmbeddings},
+		{name: "loras", args: "[name]", help: i18n.CmdLoRAs, handler: c.listLoRAs},
+		{name: "upscalers", help: i18n.CmdUpscalers, handler: c.listUpscalers},
+		{name: "vaes", help: i18n.CmdVAEs, handler: c.listVAEs},
+		{name: "chatsettings", args: "[setting value]", help: i18n.CmdChatSettings, handler: c.chatSettingsCmd},
+		{name: "lang", args: "[language]", help: i18n.CmdLang, handler: c.langCmd},
+		{name: "help", args: "[parameter]", help: i18n.CmdHelp, handler: c.help},
+		{name: "smi", help: i18n.CmdSMI, admin: true, handler: c.smi},
+		{name: "refresh", help: i18n.CmdRefresh, admin: true, handler: c.refresh},
+	}
+}
+
+// Returns the listed commands, admin commands are only included if admin is true.
+func (c *CmdHandler) listedCommands(admin bool) (res []command) {
+	for _, cmd := range c.commands() {
+		if !cmd.hidden && (admin || !cmd.admin) {
+			res = append(res, cmd)
+		}
+	}
+	return res
+}
+
+// Wraps the handler of admin commands to reply with an error to other users.
+func (c *CmdHandler) adminOnly(handler func(ctx context.Context, msg *models.Message)) func(ctx context.Context, msg *models.Message) {
+	return func(ctx context.Context, msg *models.Message) {
+		if !c.us.IsAdmin(msg.From.ID) {
+			fmt.Println("  user is not an admin, ignoring")
+			c.bot.SendReplyToMessage(ctx, msg, c.lang(msg.From).T(i18n.UsageNotAllowed))
+			return
+		}
+		handler(ctx, msg)
+	}
+}
+
+// Sets the command lists shown by Telegram clients in all supported languages. Admins get
+// a list with the admin commands.
+func (c *CmdHandler) RegisterCommands(ctx context.Context, adminUserIDs []int64) {
+	for _, lang := range i18n.Languages {
+		languageCode := string(lang)
+		if lang == i18n.English { // English is the default for all other languages.
+			languageCode = ""
+		}
+		if err := c.bot.SetMyCommands(ctx, botCommands(lang, c.listedCommands(false)), &models.BotCommandScopeDefault{}, languageCode); err != nil {
+			fmt.Println("  can't set commands:", err)
+			return
+		}
+		adminCommands := botCommands(lang, c.listedCommands(true))
+		for _, id := range adminUserIDs {
+			if err := c.bot.SetMyCommands(ctx, adminCommands, &models.BotCommandScopeChat{ChatID: id}, languageCode); err != nil {
+				fmt.Println("  can't set admin commands:", err)
+			}
+		}
+	}
+}
+
+func botCommands(lang i18n.Lang, commands []command) (res []models.BotCommand) {
+	for _, cmd := range commands {
+		res = append(res, models.BotCommand{Command: cmd.name, Description: lang.T(cmd.help)})
+	}
+	return res
+}
+
+func commandsHelp(lang i18n.Lang, commands []command) string {
+	var lines []string
+	for _, cmd := range commands {
+		line := "/" + cmd.name
+		if cmd.args != "" {
+			line += " " + cmd.args
+		}
+		lines = append(lines, line+" - "+lang.T(cmd.help))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Sends the help of the bot, or the help of the attribute given as the argument.
+func (c *CmdHandler) help(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
+	if name := strings.TrimPrefix(strings.TrimSpace(removeBotName(msg.Text)), "-"); name != "" {
+		a, ok := findAttribute(name)
+		if !ok {
+			c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.UnknownAttr, html.EscapeString(name), didYouMean(name, attributeNames()))))
+			return
+		}
+		c.bot.SendReplyToMessage(ctx, msg, a.details(lang))
+		return
+	}
+
+	text := lang.T(i18n.HelpCommands) + "\n" + commandsHelp(lang, c.listedCommands(false)) + "\n\n"
+	if c.us.IsAdmin(msg.From.ID) {
+		var adminCommands []command
+		for _, cmd := range c.listedCommands(true) {
+			if cmd.admin {
+				adminCommands = append(adminCommands, cmd)
+			}
+		}
+		text += lang.T(i18n.HelpAdminCommands) + "\n" + commandsHelp(lang, adminCommands) + "\n\n"
+	}
+	text += lang.T(i18n.HelpTriggers) + "\n\n" +
+		lang.T(i18n.HelpRenderAttrs) + "\n" + attributesHelp(lang, attrRender) + "\n\n" +
+		lang.T(i18n.HelpUpscaleAttrs) + "\n" + attributesHelp(lang, attrUpscale) + "\n\n" +
+		lang.T(i18n.HelpFooter)
+	c.bot.SendReplyToMessage(ctx, msg, text)
+}
@@ -0,0 +1,136 @@
+package logic
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+)
+
+func TestHelp(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/help"))
+	calls := waitForCalls(t, tgBot, "SendReplyToMessage", 1)
+	for _, a := range attributes {
+		if !strings.Contains(calls[0].Text, a.usage()+" - ") {
+			t.Errorf("attribute %s is not in the help", a.names[0])
+		}
+	}
+	if strings.Contains(calls[0].Text, "/smi") {
+		t.Error("admin command is in the help of a user")
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testAdminID, "/help"))
+	calls = waitForCalls(t, tgBot, "SendReplyToMessage", 2)
+	if !strings.Contains(calls[1].Text, "/smi") {
+		t.Error("admin command is not in the help of an admin")
+	}
+}
+
+func TestHelpAttribute(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/help -hru"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.AttrHRUpscaler)) ||
+		!tgBot.HasText("SendReplyToMessage", "a cat -hr-upscaler Latent") {
+		t.Errorf("attribute help not sent, got calls: %+v", tgBot.Calls(""))
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testUserID, "/help upscaler3"))
+	if !tgBot.HasText("SendReplyToMessage", "unknown parameter upscaler3, did you mean") {
+		t.Errorf("unknown attribute reply not sent, got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+func TestAdminCommand(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/smi"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.UsageNotAllowed)) {
+		t.Errorf("admin command is usable by a user, got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+func TestRefreshCommand(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/refresh"))
+	if len(sdSrv.Requests("/sdapi/v1/refresh-checkpoints")) != 0 {
+		t.Error("refresh is usable by a user")
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testAdminID, "/refresh"))
+	for _, path := range []string{"/sdapi/v1/refresh-checkpoints", "/sdapi/v1/refresh-loras"} {
+		if n := len(sdSrv.Requests(path)); n != 1 {
+			t.Errorf("got %d requests to %s, expected 1", n, path)
+		}
+	}
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.Refreshed, 2, 2)) {
+		t.Errorf("got calls: %+v", tgBot.Calls(""))
+	}
+
+	sdSrv.FailNext("/sdapi/v1/refresh-checkpoints", 1, 500, `{"error":"disk error"}`)
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(3, testAdminID, "/refresh"))
+	if !tgBot.HasText("SendReplyToMessage", "error refreshing lists") {
+		t.Errorf("got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+// noRefreshBackend hides the Refresh method of the wrapped backend.
+type noRefreshBackend struct {
+	sdapi.Backend
+}
+
+func TestRefreshCommandNotSupported(t *testing.T) {
+	api, sdSrv := newTestAPI(t)
+	tgBot := newTestBotWithBackend(t, sdapi.NewCatalog(noRefreshBackend{api}, time.Hour), nil, nil, nil)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testAdminID, "/refresh"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.Error, i18n.Msg(i18n.RefreshNotSupported))) {
+		t.Errorf("got calls: %+v", tgBot.Calls(""))
+	}
+	if n := len(sdSrv.Requests("/sdapi/v1/refresh-checkpoints")); n != 0 {
+		t.Errorf("got %d refresh requests", n)
+	}
+}
+
+func TestRegisterCommands(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+	c := &CmdHandler{bot: tgBot}
+
+	c.RegisterCommands(context.Background(), []int64{testAdminID})
+	calls := tgBot.Calls("SetMyCommands")
+	if len(calls) != 2*len(i18n.Languages) {
+		t.Fatalf("got %d calls, expected %d", len(calls), 2*len(i18n.Languages))
+	}
+	users, admins := calls[0], calls[1]
+	if users.ChatID != 0 || users.Text != "" || admins.ChatID != testAdminID {
+		t.Errorf("got scopes %d %q and %d", users.ChatID, users.Text, admins.ChatID)
+	}
+	if len(admins.Commands) != len(users.Commands)+2 || admins.Commands[len(admins.Commands)-1].Command != "refresh" {
+		t.Errorf("got admin commands %+v", admins.Commands)
+	}
+	if ru := calls[2]; ru.Text != string(i18n.Russian) || ru.Commands[0].Description != i18n.Russian.T(i18n.CmdSD) {
+		t.Errorf("got russian commands %+v", ru)
+	}
+}
+
+// The commands file for BotFather is kept in sync with the commands set on startup.
+func TestCommandsFile(t *testing.T) {
+	d, err := os.ReadFile("../../docs/resources/commands.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, cmd := range botCommands(i18n.English, (&CmdHandler{}).listedCommands(false)) {
+		lines = append(lines, cmd.Command+" - "+cmd.Description)
+	}
+	if expected := strings.Join(lines, "\n") + "\n"; string(d) != expected {
+		t.Errorf("commands.txt is out of date, expected:\n%s", expected)
+	}
+}
@@ -0,0 +1,74 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+// Callback data of the re-render button.
+const rerenderCallback = "rerender"
+
+// Amends the request of the edited message if it's waiting in the queue, otherwise offers
+// rendering it again.
+func (c *CmdHandler) editedMessage(ctx context.Context, msg *models.Message) {
+	if msg.Text == "" || strings.HasPrefix(msg.Text, "/") && !isRenderCmd(msg.Text) {
+		return
+	}
+	text, source, ok := c.renderRequest(msg)
+	if !ok {
+		return
+	}
+	fmt.Println("  message edited")
+
+	if c.reqQueue.IsQueued(msg) {
+		reqParams, ok := c.parseRenderRequest(ctx, msg, text, source)
+		if !ok {
+			return
+		}
+		if c.reqQueue.UpdateQueued(msg, reqParams) {
+			fmt.Println("  queued request updated")
+			return
+		}
+	}
+
+	lang := c.lang(msg.From)
+	offer := func() {
+		c.bot.SendReplyWithMarkup(ctx, msg, lang.T(i18n.RerenderEdited),
+			&models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+				{Text: lang.T(i18n.RerenderButton), CallbackData: rerenderCallback},
+			}}})
+	}
+	// Re-rendering is offered after the running request has finished, so it's not queued while
+	// the images of the original request are still coming.
+	if c.reqQueue.WhenFinished(msg, offer) {
+		fmt.Println("  offering re-render when the request is finished")
+		return
+	}
+	offer()
+}
+
+// Renders the edited message, which is the message the re-render button's message replies to.
+func (c *CmdHandler) rerender(ctx context.Context, cq *models.CallbackQuery) {
+	msg := cq.Message.ReplyToMessage
+	if msg == nil || msg.Text == "" || msg.From == nil {
+		_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, c.lang(&cq.Sender).T(i18n.Error, i18n.Msg(i18n.EditedMessageUnavailable)))
+		return
+	}
+	if cq.Sender.ID != msg.From.ID {
+		_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, c.lang(&cq.Sender).T(i18n.Error, i18n.Msg(i18n.RerenderNotOwner)))
+		return
+	}
+	_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, "")
+	_ = c.bot.DeleteMessage(ctx, cq.Message)
+
+	// Replies to images can't be derived again, as the replied image is not available here.
+	text, source, ok := c.renderRequest(msg)
+	if !ok {
+		text, source = strings.TrimSpace(removeBotName(msg.Text)), nil
+	}
+	c.render(ctx, msg, text, source)
+}
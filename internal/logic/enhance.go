@@ -0,0 +1,99 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+// Callback data of the buttons under the enhanced prompt. The prompt itself is read from the
+// message text, as the callback data is limited to 64 bytes.
+const (
+	enhanceRenderCallback = "enhance-render"
+	enhanceEditCallback   = "enhance-edit"
+)
+
+func (c *CmdHandler) enhance(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
+	if c.enhancer == nil {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.EnhanceNotConfigured)))
+		return
+	}
+
+	text := strings.Join(strings.Fields(removeBotName(msg.Text)), " ")
+	// Attributes are validated and kept as is, only the idea is sent to the LLM.
+	reqParams := c.defaultReqParamsRender(text)
+	firstCmdCharAt, err := ReqParamsParse(ctx, c.sdApi, c.defaults, c.limits, c.aliases, text, &reqParams)
+	if err != nil {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.CantParseParams, err)))
+		return
+	}
+	idea, attrs := text, ""
+	if firstCmdCharAt >= 0 {
+		idea, attrs = strings.TrimSpace(text[:firstCmdCharAt]), text[firstCmdCharAt:]
+	}
+	if idea == "" {
+		fmt.Println("  missing prompt")
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.MissingPrompt)))
+		return
+	}
+
+	statusMsg := c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Enhancing))
+	prompt, err := c.enhancer.Process(ctx, idea)
+	if statusMsg != nil {
+		_ = c.bot.DeleteMessage(ctx, statusMsg)
+	}
+	if err != nil {
+		fmt.Println("  enhance error:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.CantEnhance, err)))
+		return
+	}
+	if attrs != "" {
+		prompt += " " + attrs
+	}
+
+	c.bot.SendReplyWithMarkup(ctx, msg, lang.T(i18n.EnhancedPrompt)+"\n<code>"+html.EscapeString(prompt)+"</code>",
+		&models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{{
+			{Text: lang.T(i18n.EnhanceRenderButton), CallbackData: enhanceRenderCallback},
+			{Text: lang.T(i18n.EnhanceEditButton), CallbackData: enhanceEditCallback},
+		}}})
+}
+
+// Returns the prompt from the message sent by enhance.
+func enhancedPromptFromMessage(msg *models.Message) string {
+	_, prompt, _ := strings.Cut(msg.Text, "\n")
+	return strings.TrimSpace(prompt)
+}
+
+func (c *CmdHandler) enhanceRender(ctx context.Context, cq *models.CallbackQuery) {
+	prompt := enhancedPromptFromMessage(cq.Message)
+	if prompt == "" {
+		_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, c.lang(&cq.Sender).T(i18n.Error, i18n.Msg(i18n.MissingPrompt)))
+		return
+	}
+	_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, "")
+
+	// Rendering as if the user has sent the prompt as a reply to the enhanced prompt message.
+	c.txt2img(ctx, &models.Message{
+		ID:   cq.Message.ID,
+		Chat: cq.Message.Chat,
+		From: &cq.Sender,
+		Text: prompt,
+	})
+}
+
+func (c *CmdHandler) enhanceEdit(ctx context.Context, cq *models.CallbackQuery) {
+	lang := c.lang(&cq.Sender)
+	prompt := enhancedPromptFromMessage(cq.Message)
+	if prompt == "" {
+		_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, lang.T(i18n.Error, i18n.Msg(i18n.MissingPrompt)))
+		return
+	}
+	_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, "")
+
+	c.bot.SendReplyToMessage(ctx, cq.Message, lang.T(i18n.EnhanceEdit)+"\n<code>/sd "+html.EscapeString(prompt)+"</code>")
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/consts"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 	reqqueue "github.com/kanootoko/stable-diffusion-telegram-bot/internal/req_queue"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
 )
@@ -18,6 +19,7 @@ type WriteCounter struct {
 	TotalBytes            int64
 	ProgressPrintInterval time.Duration
 	LastProgressPrintAt   time.Time
+	Lang                  i18n.Lang
 	reqQueue              *reqqueue.ReqQueue
 }
 
@@ -28,7 +30,7 @@ func (wc *WriteCounter) Write(p []byte) (int, error) {
 	if time.Since(wc.LastProgressPrintAt) > wc.ProgressPrintInterval {
 		progressPercent := int(float64(wc.GotBytes) / float64(wc.TotalBytes) * 100)
 		fmt.Print("    progress: ", progressPercent, "%\n")
-		wc.reqQueue.SendReplyToCurrentEntry(wc.Ctx, consts.DownloadingStr+" "+utils.GetProgressbar(progressPercent, consts.ProgressBarLength))
+		wc.reqQueue.SendReplyToCurrentEntry(wc.Ctx, wc.Lang.T(i18n.Downloading, utils.GetProgressbar(progressPercent, consts.ProgressBarLength)))
 		wc.LastProgressPrintAt = time.Now()
 	}
 	return n, nil
@@ -2,6 +2,7 @@ package logic
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -10,52 +11,84 @@ import (
 
 	"github.com/go-telegram/bot"
 	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/consts"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/preprocess"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/userservice"
 	reqqueue "github.com/kanootoko/stable-diffusion-telegram-bot/internal/req_queue"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
 	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram"
+	"golang.org/x/exp/slices"
 )
 
 func NewCmdHandler(
-	sdApi *sdapi.SdAPIType,
+	sdApi sdapi.Backend,
 	reqQueue *reqqueue.ReqQueue,
 	generationDefaults config.GenerationDefaults,
+	generationLimits config.GenerationLimits,
 	userService userservice.UserService,
+	promptPreprocessors []preprocess.PromptPreprocessor,
+	enhancer preprocess.PromptPreprocessor,
+	safetyFilter *safety.Filter,
+	chatSettings *chatsettings.Store,
+	langs *i18n.Store,
+	aliases NameAliases,
 ) *CmdHandler {
 	c := CmdHandler{
-		sdApi:    sdApi,
-		reqQueue: reqQueue,
-		defaults: generationDefaults,
-		us:       userService,
+		sdApi:         sdApi,
+		reqQueue:      reqQueue,
+		defaults:      generationDefaults,
+		limits:        generationLimits,
+		us:            userService,
+		preprocessors: promptPreprocessors,
+		enhancer:      enhancer,
+		safety:        safetyFilter,
+		chatSettings:  chatSettings,
+		langs:         langs,
+		aliases:       aliases,
+		inline:        newInlineRenders(),
 	}
 	return &c
 }
 
 func (c *CmdHandler) AddHandlers(
-	bot *telegram.SDBot,
+	bot telegram.BotAPI,
 ) {
 	c.bot = bot
-	bot.RegisterPrefixHandler("/start", c.adaptHandler(c.Start))
-	bot.RegisterPrefixHandler("/sd", c.adaptHandler(c.txt2img))
-	bot.RegisterPrefixHandler("/txt2img", c.adaptHandler(c.txt2img))
-	bot.RegisterPrefixHandler("/upscale", c.adaptHandler(c.upscale))
-	bot.RegisterPrefixHandler("/cancel", c.adaptHandler(c.cancel))
-	bot.RegisterPrefixHandler("/smi", c.adaptHandler(c.smi))
-	bot.RegisterPrefixHandler("/help", c.adaptHandler(c.help))
-
-	bot.RegisterPrefixHandler("/models", c.adaptHandler(c.listModels))
-	bot.RegisterPrefixHandler("/samplers", c.adaptHandler(c.listSamplers))
-	bot.RegisterPrefixHandler("/embeddings", c.adaptHandler(c.listEmbeddings))
-	bot.RegisterPrefixHandler("/loras", c.adaptHandler(c.listLoRAs))
-	bot.RegisterPrefixHandler("/upscalers", c.adaptHandler(c.listUpscalers))
-	bot.RegisterPrefixHandler("/vaes", c.adaptHandler(c.listVAEs))
+	for _, cmd := range c.commands() {
+		handler := cmd.handler
+		if cmd.admin {
+			handler = c.adminOnly(handler)
+		}
+		bot.RegisterPrefixHandler("/"+cmd.name, c.adaptHandler(handler))
+	}
+	bot.RegisterCallbackHandler(enhanceRenderCallback, c.adaptCallbackHandler(c.enhanceRender))
+	bot.RegisterCallbackHandler(enhanceEditCallback, c.adaptCallbackHandler(c.enhanceEdit))
+	bot.RegisterCallbackHandler(rerenderCallback, c.adaptCallbackHandler(c.rerender))
 }
 
 func (c *CmdHandler) GetDefaultHandler() bot.HandlerFunc {
-	return c.adaptHandler(c.defaultHandler)
+	return c.adaptUpdateHandler(c.adaptHandler(c.defaultHandler))
+}
+
+// Adapts the handlers of edited messages and inline queries to the default handler, as these
+// updates are only sent to it.
+func (c *CmdHandler) adaptUpdateHandler(defaultHandler bot.HandlerFunc) bot.HandlerFunc {
+	editHandler := c.adaptHandler(c.editedMessage)
+	return func(ctx context.Context, b *bot.Bot, update *models.Update) {
+		switch {
+		case update.EditedMessage != nil:
+			editHandler(ctx, b, &models.Update{Message: update.EditedMessage})
+		case update.InlineQuery != nil:
+			c.inlineQuery(ctx, update.InlineQuery)
+		default:
+			defaultHandler(ctx, b, update)
+		}
+	}
 }
 
 func removeBotName(s string) string {
@@ -75,7 +108,7 @@ func removeBotName(s string) string {
 
 func (c *CmdHandler) adaptHandler(innerHandler func(context.Context, *models.Message)) bot.HandlerFunc {
 	return func(ctx context.Context, _ *bot.Bot, update *models.Update) {
-		if update.Message == nil { // edited message is ignored
+		if update.Message == nil {
 			return
 		}
 		fmt.Print("msg from ", update.Message.From.Username, "#", update.Message.From.ID, ": ", update.Message.Text, "\n")
@@ -87,14 +120,16 @@ func (c *CmdHandler) adaptHandler(innerHandler func(context.Context, *models.Mes
 		if update.Message.ReplyToMessage != nil &&
 			update.Message.Text != "" &&
 			update.Message.Text[0] != '/' {
-			fmt.Println("  skipping message as a reply to bot without a command")
-			return
+			if _, _, ok := c.getTrigger(update.Message); !ok {
+				fmt.Println("  skipping message as a reply to bot without a command")
+				return
+			}
 		}
 
 		if !c.us.IsUsageAllowed(update.Message.From.ID, update.Message.Chat.ID) {
 			fmt.Println("  user not allowed, ignoring")
 			if update.Message.Text != "" && update.Message.Text[0] == '/' || update.Message.From.ID == update.Message.Chat.ID {
-				c.bot.SendReplyToMessage(ctx, update.Message, consts.UsageNotAllowedStr)
+				c.bot.SendReplyToMessage(ctx, update.Message, c.lang(update.Message.From).T(i18n.UsageNotAllowed))
 			}
 			return
 		}
@@ -103,17 +138,74 @@ func (c *CmdHandler) adaptHandler(innerHandler func(context.Context, *models.Mes
 	}
 }
 
+func (c *CmdHandler) adaptCallbackHandler(innerHandler func(context.Context, *models.CallbackQuery)) bot.HandlerFunc {
+	return func(ctx context.Context, _ *bot.Bot, update *models.Update) {
+		cq := update.CallbackQuery
+		if cq == nil || cq.Message == nil {
+			return
+		}
+		fmt.Print("callback from ", cq.Sender.Username, "#", cq.Sender.ID, ": ", cq.Data, "\n")
+
+		if !c.us.IsUsageAllowed(cq.Sender.ID, cq.Message.Chat.ID) {
+			fmt.Println("  user not allowed, ignoring")
+			_ = c.bot.AnswerCallbackQuery(ctx, cq.ID, c.lang(&cq.Sender).T(i18n.UsageNotAllowed))
+			return
+		}
+
+		innerHandler(ctx, cq)
+	}
+}
+
 type CmdHandler struct {
-	sdApi    *sdapi.SdAPIType
-	bot      *telegram.SDBot
+	sdApi    sdapi.Backend
+	bot      telegram.BotAPI
 	reqQueue *reqqueue.ReqQueue
 	defaults config.GenerationDefaults
+	limits   config.GenerationLimits
 	us       userservice.UserService
+	// Applied to the prompt and the negative prompt in order.
+	preprocessors []preprocess.PromptPreprocessor
+	// Used for /enhance and the -enhance attribute, nil if not configured.
+	enhancer preprocess.PromptPreprocessor
+	// Prompt blocklists and mandatory negative prompts, nil if not configured.
+	safety *safety.Filter
+	// Settings of group chats, nil if not configured.
+	chatSettings *chatsettings.Store
+	// Languages chosen by the users, nil if not configured.
+	langs *i18n.Store
+	// Short names of models, samplers and upscalers.
+	aliases NameAliases
+	// Renders started by inline queries.
+	inline *inlineRenders
 }
 
-func (c *CmdHandler) txt2img(ctx context.Context, msg *models.Message) {
-	text := strings.TrimSpace(removeBotName(msg.Text))
-	reqParams := reqparams.ReqParamsRender{
+func (c *CmdHandler) getChatSettings(chatID int64) chatsettings.Settings {
+	if c.chatSettings == nil {
+		return chatsettings.Settings{}
+	}
+	return c.chatSettings.Get(chatID)
+}
+
+// Returns the generation limits lowered by the chat settings.
+func (c *CmdHandler) chatLimits(s chatsettings.Settings) config.GenerationLimits {
+	limits := c.limits
+	if s.MaxWidth > 0 {
+		limits.MaxWidth = min(limits.MaxWidth, s.MaxWidth)
+	}
+	if s.MaxHeight > 0 {
+		limits.MaxHeight = min(limits.MaxHeight, s.MaxHeight)
+	}
+	if s.MaxSteps > 0 {
+		limits.MaxSteps = min(limits.MaxSteps, s.MaxSteps)
+	}
+	if s.MaxCnt > 0 {
+		limits.MaxCnt = min(limits.MaxCnt, s.MaxCnt)
+	}
+	return limits
+}
+
+func (c *CmdHandler) defaultReqParamsRender(text string) reqparams.ReqParamsRender {
+	return reqparams.ReqParamsRender{
 		OriginalPromptText: text,
 		Seed:               rand.Uint32(),
 		Width:              c.defaults.Width,
@@ -123,15 +215,30 @@ func (c *CmdHandler) txt2img(ctx context.Context, msg *models.Message) {
 		CFGScale:           c.defaults.CFGScale,
 		SamplerName:        c.defaults.Sampler,
 		ModelName:          c.defaults.Model,
-		Upscale: reqparams.ReqParamsUpscale{
-			Upscaler: "LDSR",
+		Output: reqparams.ReqParamsOutput{
+			Format:  c.defaults.OutputFormat,
+			Quality: c.defaults.Quality,
 		},
 		HR: reqparams.ReqParamsRenderHR{
 			DenoisingStrength: 0.4,
-			Upscaler:          "R-ESRGAN 4x+",
 			SecondPassSteps:   15,
 		},
 	}
+}
+
+func (c *CmdHandler) txt2img(ctx context.Context, msg *models.Message) {
+	c.render(ctx, msg, strings.TrimSpace(removeBotName(msg.Text)), nil)
+}
+
+// Parses the render request text into reqParams, errors are replied to the message. Derived
+// renders pass the source prompt, which is used if the text has no prompt.
+func (c *CmdHandler) parseRender(ctx context.Context, msg *models.Message, text string, reqParams reqparams.ReqParamsRender, defaults config.GenerationDefaults, sourcePrompt string) (reqparams.ReqParamsRender, bool) {
+	lang := c.lang(msg.From)
+	chatSettings := c.getChatSettings(msg.Chat.ID)
+	limits := c.chatLimits(chatSettings)
+	if len(chatSettings.AllowedModels) > 0 && !slices.Contains(chatSettings.AllowedModels, reqParams.ModelName) {
+		reqParams.ModelName = chatSettings.AllowedModels[0]
+	}
 
 	var paramsLine *string
 	lines := strings.Split(text, "\n")
@@ -143,15 +250,20 @@ func (c *CmdHandler) txt2img(ctx context.Context, msg *models.Message) {
 		reqParams.Prompt = text
 		paramsLine = &reqParams.Prompt
 	}
-	firstCmdCharAt, err := ReqParamsParse(ctx, c.sdApi, c.defaults, *paramsLine, &reqParams)
+	firstCmdCharAt, err := ReqParamsParse(ctx, c.sdApi, defaults, limits, c.aliases, *paramsLine, &reqParams)
 	if err != nil {
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": can't parse render params: "+err.Error())
-		return
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.CantParseParams, err)))
+		return reqParams, false
+	}
+	if len(chatSettings.AllowedModels) > 0 && !slices.Contains(chatSettings.AllowedModels, reqParams.ModelName) {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.ModelNotAllowed, reqParams.ModelName,
+			strings.Join(chatSettings.AllowedModels, ", "))))
+		return reqParams, false
 	}
 	if firstCmdCharAt >= 0 { // Commands found? Removing them from the line.
-		if firstCmdCharAt == 0 {
-			c.bot.SendReplyToMessage(ctx, msg, consts.EmptyRequestErrorStr)
-			return
+		if firstCmdCharAt == 0 && sourcePrompt == "" {
+			c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.EmptyRequest))
+			return reqParams, false
 		}
 		*paramsLine = (*paramsLine)[:firstCmdCharAt]
 		if len(lines) > 1 {
@@ -162,35 +274,134 @@ func (c *CmdHandler) txt2img(ctx context.Context, msg *models.Message) {
 
 	reqParams.Prompt = strings.TrimSpace(reqParams.Prompt)
 	reqParams.NegativePrompt = strings.TrimSpace(reqParams.NegativePrompt)
+	reqParams.UserNegativePrompt = reqParams.NegativePrompt
+	if reqParams.Prompt == "" && sourcePrompt != "" {
+		reqParams.Prompt = sourcePrompt
+		reqParams.OriginalPromptText = sourcePrompt + reqParams.OriginalPromptText
+	}
 
 	if reqParams.Prompt == "" {
 		fmt.Println("  missing prompt")
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": missing prompt")
-		return
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.MissingPrompt)))
+		return reqParams, false
+	}
+
+	originalPrompt := reqParams.Prompt
+	if err = c.preprocessPrompts(ctx, &reqParams); err != nil {
+		fmt.Println("  prompt preprocess error:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.CantProcessPrompt, err)))
+		return reqParams, false
+	}
+
+	if c.safety != nil {
+		// Both prompts are checked, so blocked words can't be sneaked in through translation.
+		if c.safety.CheckPrompt(msg.Chat.ID, originalPrompt) != nil ||
+			c.safety.CheckPrompt(msg.Chat.ID, reqParams.PromptWithLoRAs()) != nil {
+			c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.PromptBlocked))
+			return reqParams, false
+		}
+		if negativePrompt := c.safety.NegativePrompt(msg.Chat.ID); negativePrompt != "" {
+			if reqParams.NegativePrompt != "" {
+				reqParams.NegativePrompt += ", "
+			}
+			reqParams.NegativePrompt += negativePrompt
+		}
+	}
+	if chatSettings.NegativePrompt != "" {
+		if reqParams.NegativePrompt != "" {
+			reqParams.NegativePrompt += ", "
+		}
+		reqParams.NegativePrompt += chatSettings.NegativePrompt
+	}
+
+	hint, err := validateExtraNetworks(ctx, c.sdApi, &reqParams)
+	if err != nil {
+		fmt.Println("  extra networks error:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, err))
+		return reqParams, false
+	}
+	if hint.Key != i18n.None {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(hint.Key, hint.Args...))
 	}
 
 	if reqParams.HR.Scale > 0 || reqParams.Upscale.Scale > 0 {
 		reqParams.NumOutputs = 1
 	}
 
+	return reqParams, true
+}
+
+// Parses the render request of the message, and adds the render to the queue. Source is the
+// params of the replied image for derived renders.
+func (c *CmdHandler) render(ctx context.Context, msg *models.Message, text string, source *reqparams.ReqParamsRender) {
+	reqParams, ok := c.parseRenderRequest(ctx, msg, text, source)
+	if !ok {
+		return
+	}
 	req := reqqueue.ReqQueueReq{
 		Type:    reqqueue.ReqTypeRender,
 		Message: msg,
 		Params:  reqParams,
+		Lang:    c.lang(msg.From),
 	}
 	c.reqQueue.Add(req)
 }
 
+func (c *CmdHandler) parseRenderRequest(ctx context.Context, msg *models.Message, text string, source *reqparams.ReqParamsRender) (reqparams.ReqParamsRender, bool) {
+	if source != nil {
+		return c.parseRender(ctx, msg, text, derivedReqParams(*source, text), derivedDefaults(c.defaults, *source), source.Prompt)
+	}
+	return c.parseRender(ctx, msg, text, c.defaultReqParamsRender(text), c.defaults, "")
+}
+
+func (c *CmdHandler) preprocessPrompts(ctx context.Context, reqParams *reqparams.ReqParamsRender) error {
+	prompt, negativePrompt := reqParams.Prompt, reqParams.NegativePrompt
+	var err error
+	for _, p := range c.preprocessors {
+		if prompt, err = p.Process(ctx, prompt); err != nil {
+			return err
+		}
+		if negativePrompt != "" {
+			if negativePrompt, err = p.Process(ctx, negativePrompt); err != nil {
+				return err
+			}
+		}
+	}
+	if reqParams.Enhance {
+		if c.enhancer == nil {
+			return i18n.Errorf(i18n.EnhanceNotConfigured)
+		}
+		if prompt, err = c.enhancer.Process(ctx, prompt); err != nil {
+			return err
+		}
+	}
+	if prompt == reqParams.Prompt && negativePrompt == reqParams.NegativePrompt {
+		return nil
+	}
+
+	reqParams.ProcessedPromptText = prompt
+	if negativePrompt != "" {
+		reqParams.ProcessedPromptText += "\n" + negativePrompt
+	}
+	reqParams.Prompt, reqParams.NegativePrompt = prompt, negativePrompt
+	return nil
+}
+
 func (c *CmdHandler) upscale(ctx context.Context, msg *models.Message) {
 	reqParams := reqparams.ReqParamsUpscale{
 		OriginalPromptText: msg.Text,
 		Scale:              2,
-		Upscaler:           "LDSR",
+		CodeFormerWeight:   0.5,
+		Output: reqparams.ReqParamsOutput{
+			Format:  c.defaults.OutputFormat,
+			Quality: c.defaults.Quality,
+		},
 	}
 
-	firstCmdCharAt, err := ReqParamsParse(ctx, c.sdApi, c.defaults, msg.Text, &reqParams)
+	limits := c.chatLimits(c.getChatSettings(msg.Chat.ID))
+	firstCmdCharAt, err := ReqParamsParse(ctx, c.sdApi, c.defaults, limits, c.aliases, msg.Text, &reqParams)
 	if err != nil {
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": can't parse render params: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, c.lang(msg.From).T(i18n.Error, i18n.Msg(i18n.CantParseParams, err)))
 		return
 	}
 	if firstCmdCharAt >= 0 {
@@ -201,26 +412,28 @@ func (c *CmdHandler) upscale(ctx context.Context, msg *models.Message) {
 		Type:    reqqueue.ReqTypeUpscale,
 		Message: msg,
 		Params:  reqParams,
+		Lang:    c.lang(msg.From),
 	}
 	c.reqQueue.Add(req)
 }
 
 func (c *CmdHandler) cancel(ctx context.Context, msg *models.Message) {
 	if err := c.reqQueue.CancelCurrentEntry(ctx); err != nil {
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, c.lang(msg.From).T(i18n.Error, err))
 	}
 }
 
 func (c *CmdHandler) listModels(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
 	models, err := c.sdApi.GetModels(ctx)
 	if err != nil {
 		fmt.Println("  error getting models:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting models: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingModelsError, err)))
 		return
 	}
 	for i := range models {
 		if models[i] == c.defaults.Model {
-			models[i] = "- <b>" + models[i] + "</b> (default)"
+			models[i] = lang.T(i18n.DefaultListItem, models[i])
 		} else {
 			models[i] = "- <code>" + models[i] + "</code>"
 		}
@@ -228,23 +441,24 @@ func (c *CmdHandler) listModels(ctx context.Context, msg *models.Message) {
 	res := strings.Join(models, "\n")
 	var text string
 	if res != "" {
-		text = "🧩 Available models:\n" + res
+		text = lang.T(i18n.AvailableModels, res)
 	} else {
-		text = "No available models."
+		text = lang.T(i18n.NoModels)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
 
 func (c *CmdHandler) listSamplers(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
 	samplers, err := c.sdApi.GetSamplers(ctx)
 	if err != nil {
 		fmt.Println("  error getting samplers:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting samplers: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingSamplersError, err)))
 		return
 	}
 	for i := range samplers {
 		if samplers[i] == c.defaults.Sampler {
-			samplers[i] = "- <b>" + samplers[i] + "</b> (default)"
+			samplers[i] = lang.T(i18n.DefaultListItem, samplers[i])
 		} else {
 			samplers[i] = "- <code>" + samplers[i] + "</code>"
 		}
@@ -252,18 +466,19 @@ func (c *CmdHandler) listSamplers(ctx context.Context, msg *models.Message) {
 	res := strings.Join(samplers, "\n")
 	var text string
 	if res != "" {
-		text = "🔭 Available samplers:\n" + res
+		text = lang.T(i18n.AvailableSamplers, res)
 	} else {
-		text = "No available samplers."
+		text = lang.T(i18n.NoSamplers)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
 
 func (c *CmdHandler) listEmbeddings(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
 	embs, err := c.sdApi.GetEmbeddings(ctx)
 	if err != nil {
 		fmt.Println("  error getting embeddings:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting embeddings: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingEmbeddingsError, err)))
 		return
 	}
 	for i := range embs {
@@ -272,38 +487,73 @@ func (c *CmdHandler) listEmbeddings(ctx context.Context, msg *models.Message) {
 	res := strings.Join(embs, "\n")
 	var text string
 	if res != "" {
-		text = "Available embeddings: " + res
+		text = lang.T(i18n.AvailableEmbeddings, res)
 	} else {
-		text = "No available embeddings."
+		text = lang.T(i18n.NoEmbeddings)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
 
 func (c *CmdHandler) listLoRAs(ctx context.Context, msg *models.Message) {
-	loras, err := c.sdApi.GetLoRAs(ctx)
+	lang := c.lang(msg.From)
+	loraInfos, err := c.sdApi.GetLoRAInfos(ctx)
 	if err != nil {
 		fmt.Println("  error getting loras:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting loras: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingLoRAsError, err)))
+		return
+	}
+
+	if name := strings.TrimSpace(removeBotName(msg.Text)); name != "" {
+		c.showLoRAInfo(ctx, msg, loraInfos, name)
 		return
 	}
-	for i := range loras {
-		loras[i] = "- <code>" + loras[i] + "</code>"
+
+	var loras []string
+	for i := range loraInfos {
+		loras = append(loras, "- <code>"+loraInfos[i].Name+"</code>")
 	}
 	res := strings.Join(loras, "\n")
 	var text string
 	if res != "" {
-		text = "Available LoRAs: " + res
+		text = lang.T(i18n.AvailableLoRAs, res)
 	} else {
-		text = "No available LoRAs."
+		text = lang.T(i18n.NoLoRAs)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
 
+func (c *CmdHandler) showLoRAInfo(ctx context.Context, msg *models.Message, loraInfos []sdapi.LoRAInfo, name string) {
+	lang := c.lang(msg.From)
+	var names []string
+	for _, l := range loraInfos {
+		if l.Name != name && l.Alias != name {
+			names = append(names, l.Name)
+			continue
+		}
+
+		text := lang.T(i18n.LoRAInfo, l.Name)
+		if l.Alias != "" && l.Alias != l.Name {
+			text += "\n" + lang.T(i18n.LoRAAlias, l.Alias)
+		}
+		if l.BaseModel != "" {
+			text += "\n" + lang.T(i18n.LoRABaseModel, l.BaseModel)
+		}
+		if len(l.TriggerWords) > 0 {
+			text += "\n" + lang.T(i18n.LoRATriggerWords, strings.Join(l.TriggerWords, ", "))
+		}
+		text += "\n" + lang.T(i18n.LoRAUsage, l.Name)
+		c.bot.SendReplyToMessage(ctx, msg, text)
+		return
+	}
+	c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.UnknownLoRA, name, didYouMean(name, names))))
+}
+
 func (c *CmdHandler) listUpscalers(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
 	ups, err := c.sdApi.GetUpscalers(ctx)
 	if err != nil {
 		fmt.Println("  error getting upscalers:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting upscalers: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingUpscalersError, err)))
 		return
 	}
 	for i := range ups {
@@ -312,18 +562,19 @@ func (c *CmdHandler) listUpscalers(ctx context.Context, msg *models.Message) {
 	res := strings.Join(ups, "\n")
 	var text string
 	if res != "" {
-		text = "🔎 Available upscalers: " + res
+		text = lang.T(i18n.AvailableUpscalers, res)
 	} else {
-		text = "🔎 No available upscalers."
+		text = lang.T(i18n.NoUpscalers)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
 
 func (c *CmdHandler) listVAEs(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
 	vaes, err := c.sdApi.GetVAEs(ctx)
 	if err != nil {
 		fmt.Println("  error getting vaes:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error getting vaes: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingVAEsError, err)))
 		return
 	}
 	for i := range vaes {
@@ -332,9 +583,9 @@ func (c *CmdHandler) listVAEs(ctx context.Context, msg *models.Message) {
 	res := strings.Join(vaes, "\n")
 	var text string
 	if res != "" {
-		text = "Available VAEs: " + res
+		text = lang.T(i18n.AvailableVAEs, res)
 	} else {
-		text = "No available VAEs."
+		text = lang.T(i18n.NoVAEs)
 	}
 	c.bot.SendReplyToMessage(ctx, msg, text)
 }
@@ -344,24 +595,46 @@ func (c *CmdHandler) smi(ctx context.Context, msg *models.Message) {
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		fmt.Println("  error running nvidia-smi:", err)
-		c.bot.SendReplyToMessage(ctx, msg, consts.ErrorStr+": error running nvidia-smi: "+err.Error())
+		c.bot.SendReplyToMessage(ctx, msg, c.lang(msg.From).T(i18n.Error, i18n.Msg(i18n.NvidiaSMIError, err)))
 		return
 	}
 	c.bot.SendReplyToMessage(ctx, msg, "<pre>"+string(out)+"</pre>")
 }
 
-func (c *CmdHandler) Start(ctx context.Context, msg *models.Message) {
-	if msg.Chat.ID >= 0 {
-		c.bot.SendReplyToMessage(ctx, msg, consts.StartStr)
+// Makes the backend rescan its models and LoRAs, and drops the cached lists.
+func (c *CmdHandler) refresh(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
+	err := sdapi.ErrRefreshNotSupported
+	if r, ok := c.sdApi.(sdapi.Refresher); ok {
+		err = r.Refresh(ctx)
+	}
+	if errors.Is(err, sdapi.ErrRefreshNotSupported) {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.RefreshNotSupported)))
+		return
+	} else if err != nil {
+		fmt.Println("  error refreshing lists:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.RefreshError, err)))
+		return
 	}
+	models, err := c.sdApi.GetModels(ctx)
+	if err != nil {
+		fmt.Println("  error getting models:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingModelsError, err)))
+		return
+	}
+	loras, err := c.sdApi.GetLoRAs(ctx)
+	if err != nil {
+		fmt.Println("  error getting LoRAs:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.GettingLoRAsError, err)))
+		return
+	}
+	c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Refreshed, len(models), len(loras)))
 }
 
-func (c *CmdHandler) help(ctx context.Context, msg *models.Message) {
-	c.bot.SendReplyToMessage(
-		ctx,
-		msg,
-		consts.HelpCommandStr,
-	)
+func (c *CmdHandler) Start(ctx context.Context, msg *models.Message) {
+	if msg.Chat.ID >= 0 {
+		c.bot.SendReplyToMessage(ctx, msg, c.lang(msg.From).T(i18n.Start))
+	}
 }
 
 func (c *CmdHandler) defaultHandler(ctx context.Context, msg *models.Message) {
@@ -372,8 +645,8 @@ func (c *CmdHandler) defaultHandler(ctx context.Context, msg *models.Message) {
 		c.handleImage(ctx, msg, msg.Photo[len(msg.Photo)-1].FileID, "image.jpg")
 		return
 	}
-	if msg.Chat.ID >= 0 {
-		c.txt2img(ctx, msg)
+	if text, source, ok := c.renderRequest(msg); ok {
+		c.render(ctx, msg, text, source)
 	}
 }
 
@@ -383,10 +656,12 @@ func (c *CmdHandler) handleImage(ctx context.Context, msg *models.Message, fileI
 		return
 	}
 
+	lang := c.lang(msg.From)
 	counter := &WriteCounter{
 		Ctx:                   ctx,
 		TotalBytes:            0,
 		ProgressPrintInterval: consts.GroupChatProgressUpdateInterval,
+		Lang:                  lang,
 		reqQueue:              c.reqQueue,
 	}
 
@@ -400,11 +675,11 @@ func (c *CmdHandler) handleImage(ctx context.Context, msg *models.Message, fileI
 	})
 
 	if err != nil {
-		c.reqQueue.SendReplyToCurrentEntry(ctx, consts.ErrorStr+": can't get file: "+err.Error())
+		c.reqQueue.SendReplyToCurrentEntry(ctx, lang.T(i18n.Error, i18n.Msg(i18n.CantGetFile, err)))
 		return
 	}
 
-	c.reqQueue.SendReplyToCurrentEntry(ctx, consts.DoneStr+" downloading\n"+c.reqQueue.CurrentEntryParams().String())
+	c.reqQueue.SendReplyToCurrentEntry(ctx, lang.T(i18n.DownloadDone)+"\n"+c.reqQueue.CurrentEntryParams().String())
 	c.reqQueue.GotImage(ctx, msg, &telegram.ImageFileData{
 		Data:     d,
 		Filename: filename,
@@ -0,0 +1,734 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/preprocess"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/logic/userservice"
+	reqqueue "github.com/kanootoko/stable-diffusion-telegram-bot/internal/req_queue"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram/telegramtest"
+)
+
+const testUserID = 10
+const testAdminID = 20
+const testGroupID = -100
+
+func newTestBot(t *testing.T, preprocessors ...preprocess.PromptPreprocessor) (*telegramtest.FakeBot, *sdapitest.Server) {
+	return newTestBotWithEnhancer(t, nil, preprocessors...)
+}
+
+func newTestBotWithEnhancer(t *testing.T, enhancer preprocess.PromptPreprocessor, preprocessors ...preprocess.PromptPreprocessor) (*telegramtest.FakeBot, *sdapitest.Server) {
+	return newTestBotWithSafety(t, nil, nil, enhancer, preprocessors...)
+}
+
+func newTestBotWithSafety(t *testing.T, safetyFilter *safety.Filter, chatSettings *chatsettings.Store, enhancer preprocess.PromptPreprocessor, preprocessors ...preprocess.PromptPreprocessor) (*telegramtest.FakeBot, *sdapitest.Server) {
+	t.Helper()
+	sdApi, sdSrv := newTestAPI(t)
+	return newTestBotWithBackend(t, sdApi, safetyFilter, chatSettings, enhancer, preprocessors...), sdSrv
+}
+
+func newTestBotWithBackend(t *testing.T, sdApi sdapi.Backend, safetyFilter *safety.Filter, chatSettings *chatsettings.Store, enhancer preprocess.PromptPreprocessor, preprocessors ...preprocess.PromptPreprocessor) *telegramtest.FakeBot {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	langs, err := i18n.NewStore(filepath.Join(t.TempDir(), "languages.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqQueue := &reqqueue.ReqQueue{ProcessTimeout: time.Minute, ChatSettings: chatSettings}
+	cmdHandler := NewCmdHandler(sdApi, reqQueue, testDefaults, testLimits,
+		userservice.NewUserServiceStatic([]int64{testUserID}, nil, []int64{testAdminID}), preprocessors, enhancer, safetyFilter, chatSettings, langs, NameAliases{})
+	tgBot := telegramtest.NewFakeBot(cmdHandler.GetDefaultHandler())
+	cmdHandler.AddHandlers(tgBot)
+	reqQueue.Init(ctx, sdApi, tgBot)
+	return tgBot
+}
+
+func newTestUpdate(msgID int, userID int64, text string) *models.Update {
+	return &models.Update{Message: &models.Message{
+		ID:   msgID,
+		Chat: models.Chat{ID: userID},
+		From: &models.User{ID: userID, Username: "test"},
+		Text: text,
+	}}
+}
+
+func newTestGroupUpdate(msgID int, userID int64, text string) *models.Update {
+	update := newTestUpdate(msgID, userID, text)
+	update.Message.Chat.ID = testGroupID
+	return update
+}
+
+func waitForCalls(t *testing.T, tgBot *telegramtest.FakeBot, method string, count int) []telegramtest.Call {
+	t.Helper()
+	if !tgBot.WaitFor(method, 5*time.Second, func(calls []telegramtest.Call) bool { return len(calls) >= count }) {
+		t.Fatalf("timeout waiting for %d %s calls, got calls: %+v", count, method, tgBot.Calls(""))
+	}
+	return tgBot.Calls(method)
+}
+
+func TestRenderEndToEnd(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd a cat in space -s 5 -o 2 -w 64 -h 64"))
+
+	uploads := waitForCalls(t, tgBot, "SendMediaGroup", 1)
+	if uploads[0].ReplyToID != 1 {
+		t.Errorf("upload is not a reply to the request")
+	}
+	media := uploads[0].Media
+	if len(media) != 2 {
+		t.Fatalf("got %d images, expected 2", len(media))
+	}
+	photo, ok := media[0].(*models.InputMediaPhoto)
+	if !ok {
+		t.Fatalf("got media type %T, expected photo", media[0])
+	}
+	if !strings.HasPrefix(photo.Caption, "a cat in space \nParameters: -s 5") || !strings.Contains(photo.Caption, "🌱<code>5</code>") {
+		t.Errorf("got caption %q", photo.Caption)
+	}
+
+	var req struct {
+		Prompt string `json:"prompt"`
+		Seed   uint32 `json:"seed"`
+		Width  int    `json:"width"`
+	}
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "a cat in space" || req.Seed != 5 || req.Width != 64 {
+		t.Errorf("got render request %+v", req)
+	}
+}
+
+type testTranslator map[string]string
+
+func (tr testTranslator) Process(ctx context.Context, prompt string) (string, error) {
+	if res, ok := tr[prompt]; ok {
+		return res, nil
+	}
+	return prompt, nil
+}
+
+func TestRenderTranslatedPrompt(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t, testTranslator{"кот в космосе": "cat in space", "размытый": "blurry"})
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd кот в космосе\nразмытый -o 1 -w 64 -h 64"))
+
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 1)
+	if !strings.Contains(uploads[0].Text, "📝 cat in space\nblurry") {
+		t.Errorf("got caption %q", uploads[0].Text)
+	}
+
+	var req struct {
+		Prompt         string `json:"prompt"`
+		NegativePrompt string `json:"negative_prompt"`
+	}
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "cat in space" || req.NegativePrompt != "blurry" {
+		t.Errorf("got render request %+v", req)
+	}
+}
+
+func TestEnhance(t *testing.T) {
+	tgBot, sdSrv := newTestBotWithEnhancer(t, testTranslator{"cat": "fluffy cat, <b>studio</b> light"})
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/enhance cat -o 1 -w 64 -h 64"))
+
+	replies := waitForCalls(t, tgBot, "SendReplyWithMarkup", 1)
+	if !strings.Contains(replies[0].Text, "<code>fluffy cat, &lt;b&gt;studio&lt;/b&gt; light -o 1 -w 64 -h 64</code>") {
+		t.Errorf("got reply %q", replies[0].Text)
+	}
+	if len(tgBot.Calls("DeleteMessage")) != 1 {
+		t.Error("enhancing status message is not deleted")
+	}
+
+	// The message text is received without the HTML markup.
+	enhancedMsg := &models.Message{
+		ID:   replies[0].MessageID,
+		Chat: models.Chat{ID: testUserID},
+		Text: i18n.English.T(i18n.EnhancedPrompt) + "\nfluffy cat, <b>studio</b> light -o 1 -w 64 -h 64",
+	}
+	tgBot.ProcessUpdate(context.Background(), &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:      "cq1",
+		Sender:  models.User{ID: testUserID},
+		Message: enhancedMsg,
+		Data:    enhanceRenderCallback,
+	}})
+
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 1)
+	if uploads[0].ReplyToID != replies[0].MessageID {
+		t.Errorf("render is not a reply to the enhanced prompt")
+	}
+	var req struct {
+		Prompt string `json:"prompt"`
+		Width  int    `json:"width"`
+	}
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "fluffy cat, <b>studio</b> light" || req.Width != 64 {
+		t.Errorf("got render request %+v", req)
+	}
+	if len(tgBot.Calls("AnswerCallbackQuery")) != 1 {
+		t.Error("callback query is not answered")
+	}
+}
+
+func TestEnhanceAttribute(t *testing.T) {
+	tgBot, sdSrv := newTestBotWithEnhancer(t, testTranslator{"cat": "fluffy cat"})
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd cat -enhance -o 1 -w 64 -h 64"))
+
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 1)
+	if !strings.Contains(uploads[0].Text, "📝 fluffy cat") {
+		t.Errorf("got caption %q", uploads[0].Text)
+	}
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "fluffy cat" {
+		t.Errorf("got render request %+v", req)
+	}
+}
+
+func TestEnhanceNotConfigured(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd cat -enhance"))
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testUserID, "/enhance cat"))
+	if len(tgBot.Calls("SendReplyToMessage")) != 2 || !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.EnhanceNotConfigured)) {
+		t.Errorf("got calls %+v", tgBot.Calls(""))
+	}
+}
+
+func TestSafetyRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"default": {"blocklist": ["gore"], "negative_prompt": "nsfw"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	safetyFilter, err := safety.NewFilter(path, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgBot, sdSrv := newTestBotWithSafety(t, safetyFilter, nil, nil, testTranslator{"кровь": "gore"})
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd кровь"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.PromptBlocked)) {
+		t.Errorf("translated blocked prompt is not rejected, got calls %+v", tgBot.Calls(""))
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testUserID, "/sd cat\nblurry -o 1 -w 64 -h 64"))
+	waitForCalls(t, tgBot, "SendPhoto", 1)
+	var req struct {
+		NegativePrompt string `json:"negative_prompt"`
+	}
+	if err = sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.NegativePrompt != "blurry, nsfw" {
+		t.Errorf("got negative prompt %q", req.NegativePrompt)
+	}
+}
+
+func newTestChatSettings(t *testing.T) *chatsettings.Store {
+	t.Helper()
+	store, err := chatsettings.NewStore(filepath.Join(t.TempDir(), "chatsettings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestChatSettingsCmd(t *testing.T) {
+	store := newTestChatSettings(t)
+	tgBot, _ := newTestBotWithSafety(t, nil, store, nil)
+
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(1, testUserID, "/chatsettings max-steps 10"))
+	if !tgBot.HasText("SendReplyToMessage", "only chat admins can change the settings") || store.Get(testGroupID).MaxSteps != 0 {
+		t.Errorf("settings changed by a non admin, got calls %+v", tgBot.Calls(""))
+	}
+
+	tgBot.ChatAdmins = []int64{testUserID}
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(2, testUserID, "/chatsettings models unknown-model"))
+	if !tgBot.HasText("SendReplyToMessage", "unknown model unknown-model") {
+		t.Errorf("unknown model is accepted, got calls %+v", tgBot.Calls(""))
+	}
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(3, testUserID, "/chatsettings max-steps 10"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.ChatSettingsUpdated)) || store.Get(testGroupID).MaxSteps != 10 {
+		t.Errorf("settings not changed, got calls %+v", tgBot.Calls(""))
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(4, testUserID, "/chatsettings"))
+	if !tgBot.HasText("SendReplyToMessage", "only available in group chats") {
+		t.Errorf("settings shown in a private chat, got calls %+v", tgBot.Calls(""))
+	}
+}
+
+func TestChatSettingsApplied(t *testing.T) {
+	store := newTestChatSettings(t)
+	err := store.Set(testGroupID, chatsettings.Settings{
+		AllowedModels:  []string{"sd_xl_base_1.0"},
+		MaxSteps:       10,
+		PlainMessages:  true,
+		NegativePrompt: "nsfw",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgBot, sdSrv := newTestBotWithSafety(t, nil, store, nil)
+
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(1, testUserID, "a cat -t 11"))
+	if !tgBot.HasText("SendReplyToMessage", "steps 11 is out of the allowed range") {
+		t.Errorf("chat steps limit is not applied, got calls %+v", tgBot.Calls(""))
+	}
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(2, testUserID, "a cat -m v1-5-pruned-emaonly"))
+	if !tgBot.HasText("SendReplyToMessage", "is not allowed in this chat") {
+		t.Errorf("not allowed model is accepted, got calls %+v", tgBot.Calls(""))
+	}
+
+	// Plain message is rendered with the default steps lowered to the chat limit.
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(3, testUserID, "a cat -o 1 -w 64 -h 64"))
+	waitForCalls(t, tgBot, "SendPhoto", 1)
+	var req struct {
+		NegativePrompt   string         `json:"negative_prompt"`
+		Steps            int            `json:"steps"`
+		OverrideSettings map[string]any `json:"override_settings"`
+	}
+	if err = sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.NegativePrompt != "nsfw" || req.Steps != 10 || req.OverrideSettings["sd_model_checkpoint"] != "sd_xl_base_1.0" {
+		t.Errorf("got render request %+v", req)
+	}
+}
+
+func TestChatSettingsAppliedToUpscale(t *testing.T) {
+	store := newTestChatSettings(t)
+	if err := store.Set(testGroupID, chatsettings.Settings{MaxWidth: 256, MaxHeight: 256}); err != nil {
+		t.Fatal(err)
+	}
+	tgBot, _ := newTestBotWithSafety(t, nil, store, nil)
+
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(1, testUserID, "/upscale -to 2048x2048"))
+	if !tgBot.HasText("SendReplyToMessage", "target size is too large, maximum is 1024x1024") {
+		t.Errorf("chat size limit is not applied, got calls %+v", tgBot.Calls(""))
+	}
+}
+
+func TestMentionTrigger(t *testing.T) {
+	store := newTestChatSettings(t)
+	tgBot, sdSrv := newTestBotWithSafety(t, nil, store, nil)
+
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(1, testUserID, "@sdbot2 a dog"))
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(2, testUserID, "@SDBot, a cat -o 1 -w 64 -h 64"))
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 1)
+	if uploads[0].ReplyToID != 2 {
+		t.Errorf("got upload %+v", uploads[0])
+	}
+	var req struct {
+		Prompt string `json:"prompt"`
+	}
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Prompt != "a cat" {
+		t.Errorf("got prompt %q", req.Prompt)
+	}
+
+	if err := store.Set(testGroupID, chatsettings.Settings{MentionsDisabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(3, testUserID, "@sdbot a cat -o 1 -w 64 -h 64"))
+	time.Sleep(100 * time.Millisecond)
+	if len(sdSrv.Requests("/sdapi/v1/txt2img")) != 1 {
+		t.Error("render started with mentions disabled")
+	}
+}
+
+func TestReplyDerivation(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestGroupUpdate(1, testUserID, "/sd a cat\nblurry -o 2 -s 5 -w 64 -h 64 -t 10 -photo"))
+	imageMsgID := waitForCalls(t, tgBot, "SendMediaGroup", 1)[0].MessageID + 1
+
+	type renderReq struct {
+		Prompt         string `json:"prompt"`
+		NegativePrompt string `json:"negative_prompt"`
+		Seed           uint32 `json:"seed"`
+		Steps          int    `json:"steps"`
+		Width          int    `json:"width"`
+		BatchSize      int    `json:"batch_size"`
+	}
+	reply := func(msgID int, text string, expected renderReq) {
+		t.Helper()
+		update := newTestGroupUpdate(msgID, testUserID, text)
+		update.Message.ReplyToMessage = &models.Message{ID: imageMsgID, Chat: update.Message.Chat}
+		tgBot.ProcessUpdate(context.Background(), update)
+		if !tgBot.WaitFor("SendPhoto", 5*time.Second, func(calls []telegramtest.Call) bool {
+			return len(calls) > 0 && calls[len(calls)-1].ReplyToID == msgID
+		}) {
+			t.Fatalf("derived render not uploaded, got calls %+v", tgBot.Calls(""))
+		}
+		var req renderReq
+		if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+			t.Fatal(err)
+		}
+		if req != expected {
+			t.Errorf("got render request %+v for %q, expected %+v", req, text, expected)
+		}
+	}
+	// Seed of the second image is kept.
+	reply(2, "-t 7", renderReq{Prompt: "a cat", NegativePrompt: "blurry", Seed: 6, Steps: 7, Width: 64, BatchSize: 1})
+	if photos := tgBot.Calls("SendPhoto"); !strings.HasPrefix(photos[len(photos)-1].Text, "a cat\nParameters: -t 7") {
+		t.Errorf("got caption %q", photos[len(photos)-1].Text)
+	}
+	reply(3, "a dog\nugly", renderReq{Prompt: "a dog", NegativePrompt: "ugly", Seed: 6, Steps: 10, Width: 64, BatchSize: 1})
+}
+
+func TestEditedMessage(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+	sdSrv.Latency = 300 * time.Millisecond
+
+	lastPrompt := func() string {
+		t.Helper()
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+			t.Fatal(err)
+		}
+		return req.Prompt
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd a cat -o 1 -w 64 -h 64"))
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testUserID, "/sd a dgo -o 1 -w 64 -h 64"))
+	edited := newTestUpdate(2, testUserID, "/sd a dog -o 1 -w 64 -h 64")
+	tgBot.ProcessUpdate(context.Background(), &models.Update{EditedMessage: edited.Message})
+	if !tgBot.HasText("EditMessage", i18n.English.T(i18n.RequestUpdated)) {
+		t.Errorf("queue reply is not updated, got calls %+v", tgBot.Calls(""))
+	}
+	waitForCalls(t, tgBot, "SendPhoto", 2)
+	if p := lastPrompt(); p != "a dog" {
+		t.Errorf("got prompt %q for the edited request", p)
+	}
+
+	// Finished requests can be rendered again.
+	edited = newTestUpdate(1, testUserID, "/sd a red cat -o 1 -w 64 -h 64")
+	tgBot.ProcessUpdate(context.Background(), &models.Update{EditedMessage: edited.Message})
+	offers := waitForCalls(t, tgBot, "SendReplyWithMarkup", 1)
+	if offers[0].ReplyToID != 1 || offers[0].Text != i18n.English.T(i18n.RerenderEdited) {
+		t.Fatalf("got offer %+v", offers[0])
+	}
+	tgBot.ProcessUpdate(context.Background(), &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:     "cq1",
+		Sender: models.User{ID: testUserID},
+		Message: &models.Message{
+			ID:             offers[0].MessageID,
+			Chat:           edited.Message.Chat,
+			ReplyToMessage: edited.Message,
+		},
+		Data: rerenderCallback,
+	}})
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 3)
+	if uploads[2].ReplyToID != 1 || lastPrompt() != "a red cat" {
+		t.Errorf("got upload %+v with prompt %q", uploads[2], lastPrompt())
+	}
+}
+
+func TestEditedMessageWhileRendering(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+	release := sdSrv.Block("/sdapi/v1/txt2img")
+	defer release()
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd a cat -o 1 -w 64 -h 64"))
+	edited := newTestUpdate(1, testUserID, "/sd a red cat -o 1 -w 64 -h 64")
+	tgBot.ProcessUpdate(context.Background(), &models.Update{EditedMessage: edited.Message})
+	time.Sleep(100 * time.Millisecond)
+	if offers := tgBot.Calls("SendReplyWithMarkup"); len(offers) != 0 {
+		t.Fatalf("re-render is offered while rendering: %+v", offers)
+	}
+
+	release()
+	waitForCalls(t, tgBot, "SendPhoto", 1)
+	offers := waitForCalls(t, tgBot, "SendReplyWithMarkup", 1)
+
+	// Only the author of the request can re-render it.
+	tgBot.ProcessUpdate(context.Background(), &models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:     "cq1",
+		Sender: models.User{ID: testAdminID},
+		Message: &models.Message{
+			ID:             offers[0].MessageID,
+			Chat:           edited.Message.Chat,
+			ReplyToMessage: edited.Message,
+		},
+		Data: rerenderCallback,
+	}})
+	if !tgBot.HasText("AnswerCallbackQuery", i18n.English.T(i18n.Error, i18n.Msg(i18n.RerenderNotOwner))) {
+		t.Errorf("re-render by another user is not rejected, got calls %+v", tgBot.Calls(""))
+	}
+	if n := len(sdSrv.Requests("/sdapi/v1/txt2img")); n != 1 {
+		t.Errorf("got %d render requests, expected 1", n)
+	}
+}
+
+func TestPrivateChatMessageRenders(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "a cat -o 1 -w 64 -h 64"))
+	waitForCalls(t, tgBot, "SendPhoto", 1)
+}
+
+func TestUpscaleEndToEnd(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+	tgBot.Files["file1"] = sdapitest.GeneratePNG(32, 32, 1)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/upscale -u 2 -upscaler Lanczos -png"))
+
+	imageMsg := newTestUpdate(2, testUserID, "")
+	imageMsg.Message.Document = &models.Document{FileID: "file1", FileName: "cat.png"}
+	// Images are ignored until the queue starts waiting for them, so resending until it's downloaded.
+	tgBot.WaitFor("GetFile", 5*time.Second, func(calls []telegramtest.Call) bool {
+		if len(calls) > 0 {
+			return true
+		}
+		tgBot.ProcessUpdate(context.Background(), imageMsg)
+		return false
+	})
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.ImageReq)) {
+		t.Error("image request reply not sent")
+	}
+
+	uploads := waitForCalls(t, tgBot, "SendDocument", 1)
+	doc, ok := uploads[0].Media[0].(*models.InputMediaDocument)
+	if !ok {
+		t.Fatalf("got media type %T, expected document", uploads[0].Media[0])
+	}
+	if doc.Media != "attach://cat-upscaled.png" {
+		t.Errorf("got media %q", doc.Media)
+	}
+}
+
+func TestUsageNotAllowed(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID+1, "/sd a cat"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.UsageNotAllowed)) {
+		t.Error("usage not allowed reply not sent")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(sdSrv.Requests("/sdapi/v1/txt2img")) != 0 {
+		t.Error("render started for not allowed user")
+	}
+}
+
+func TestParseErrorReply(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd a cat -w 99999"))
+	if !tgBot.HasText("SendReplyToMessage", "width 99999 is out of the allowed range") {
+		t.Errorf("parse error reply not sent, got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+func TestEmbeddingHint(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/sd a cat, easynegativ -o 2 -w 64 -h 64"))
+	if !tgBot.HasText("SendReplyToMessage", "easynegativ is not an embedding, did you mean easynegative?") {
+		t.Errorf("embedding hint not sent, got calls: %+v", tgBot.Calls(""))
+	}
+	// The render is started anyway.
+	waitForCalls(t, tgBot, "SendMediaGroup", 1)
+}
+
+func TestTelegramLanguage(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	update := newTestUpdate(1, testUserID, "/sd a cat -w 99999")
+	update.Message.From.LanguageCode = "ru"
+	tgBot.ProcessUpdate(context.Background(), update)
+	expected := i18n.Russian.T(i18n.OutOfRange, i18n.Msg(i18n.ParamWidth), 99999, testLimits.MinWidth, testLimits.MaxWidth)
+	if !tgBot.HasText("SendReplyToMessage", expected) {
+		t.Errorf("russian parse error reply not sent, got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+func TestLangCmd(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(1, testUserID, "/lang klingon"))
+	if !tgBot.HasText("SendReplyToMessage", "klingon") {
+		t.Errorf("unknown language reply not sent, got calls: %+v", tgBot.Calls(""))
+	}
+
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(2, testUserID, "/lang ru"))
+	tgBot.ProcessUpdate(context.Background(), newTestUpdate(3, testUserID, "/start"))
+	if !tgBot.HasText("SendReplyToMessage", i18n.Russian.T(i18n.Start)) {
+		t.Errorf("start reply is not in the chosen language, got calls: %+v", tgBot.Calls(""))
+	}
+
+	update := newTestUpdate(4, testUserID, "/lang auto")
+	update.Message.From.LanguageCode = "en-US"
+	tgBot.ProcessUpdate(context.Background(), update)
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.T(i18n.LanguageUpdated, "English")) {
+		t.Errorf("language is not reset, got calls: %+v", tgBot.Calls(""))
+	}
+}
+
+func TestInlineQuery(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+	defer func(delay time.Duration) { inlineDebounceDelay = delay }(inlineDebounceDelay)
+	inlineDebounceDelay = 50 * time.Millisecond
+
+	query := func(id, text string) {
+		tgBot.ProcessUpdate(context.Background(), &models.Update{InlineQuery: &models.InlineQuery{
+			ID:    id,
+			From:  &models.User{ID: testUserID, Username: "test"},
+			Query: text,
+		}})
+	}
+
+	// Only the last query is rendered when the user stops typing.
+	query("q1", "a c")
+	query("q2", "a cat  -o 1 -w 64 -h 64")
+	if answers := tgBot.Calls("AnswerInlineQuery"); len(answers) != 2 || answers[1].Text != i18n.English.T(i18n.InlineRendering) {
+		t.Fatalf("got answers %+v", answers)
+	}
+	uploads := waitForCalls(t, tgBot, "SendPhoto", 1)
+	if uploads[0].ChatID != testUserID || uploads[0].ReplyToID != 0 {
+		t.Errorf("got upload %+v, expected it in the private chat", uploads[0])
+	}
+	if n := len(sdSrv.Requests("/sdapi/v1/txt2img")); n != 1 {
+		t.Errorf("got %d renders", n)
+	}
+
+	// Querying again returns the uploaded image.
+	var results []models.InlineQueryResult
+	for deadline := time.Now().Add(5 * time.Second); len(results) == 0 && time.Now().Before(deadline); {
+		query("q3", "a cat -o 1 -w 64 -h 64")
+		answers := tgBot.Calls("AnswerInlineQuery")
+		results = answers[len(answers)-1].InlineResults
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got results %+v", results)
+	}
+	photo, ok := results[0].(*models.InlineQueryResultCachedPhoto)
+	if !ok || photo.PhotoFileID != fmt.Sprint("photo-", uploads[0].MessageID) || photo.Caption != "a cat -o 1 -w 64 -h 64" {
+		t.Errorf("got result %+v", results[0])
+	}
+	if n := len(sdSrv.Requests("/sdapi/v1/txt2img")); n != 1 {
+		t.Errorf("got %d renders after querying again", n)
+	}
+}
+
+// Blocks processing prompts starting with "slow" until the context is canceled.
+type blockingPreprocessor struct {
+	started  chan string
+	canceled chan string
+}
+
+func (bp blockingPreprocessor) Process(ctx context.Context, prompt string) (string, error) {
+	if !strings.HasPrefix(prompt, "slow") {
+		return prompt, nil
+	}
+	bp.started <- prompt
+	<-ctx.Done()
+	bp.canceled <- prompt
+	return "", ctx.Err()
+}
+
+func TestInlineQueryReplacedRenderCanceled(t *testing.T) {
+	bp := blockingPreprocessor{started: make(chan string, 1), canceled: make(chan string, 1)}
+	tgBot, sdSrv := newTestBot(t, bp)
+	defer func(delay time.Duration) { inlineDebounceDelay = delay }(inlineDebounceDelay)
+	inlineDebounceDelay = 10 * time.Millisecond
+
+	query := func(id, text string) {
+		tgBot.ProcessUpdate(context.Background(), &models.Update{InlineQuery: &models.InlineQuery{
+			ID:    id,
+			From:  &models.User{ID: testUserID, Username: "test"},
+			Query: text,
+		}})
+	}
+	receive := func(ch chan string, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for " + what)
+		}
+	}
+
+	query("q1", "slow cat -o 1 -w 64 -h 64")
+	receive(bp.started, "the first render to start")
+	// The newer query cancels the first render which is still being prepared.
+	query("q2", "a cat -o 1 -w 64 -h 64")
+	receive(bp.canceled, "the first render to be canceled")
+
+	waitForCalls(t, tgBot, "SendPhoto", 1)
+	if n := len(sdSrv.Requests("/sdapi/v1/txt2img")); n != 1 {
+		t.Errorf("got %d renders, expected 1", n)
+	}
+}
+
+func TestInlineQueryParseErrorRetried(t *testing.T) {
+	tgBot, _ := newTestBot(t)
+	defer func(delay time.Duration) { inlineDebounceDelay = delay }(inlineDebounceDelay)
+	inlineDebounceDelay = 10 * time.Millisecond
+
+	for i := 1; i <= 2; i++ {
+		tgBot.ProcessUpdate(context.Background(), &models.Update{InlineQuery: &models.InlineQuery{
+			ID:    fmt.Sprint("q", i),
+			From:  &models.User{ID: testUserID, Username: "test"},
+			Query: "a cat -w 99999",
+		}})
+		// Failed queries are not stored, so the same query is parsed again.
+		replies := waitForCalls(t, tgBot, "SendReplyToMessage", i)
+		if !strings.Contains(replies[i-1].Text, "width 99999 is out of the allowed range") {
+			t.Errorf("got reply %+v", replies[i-1])
+		}
+	}
+	if answers := tgBot.Calls("AnswerInlineQuery"); answers[1].Text != i18n.English.T(i18n.InlineRendering) {
+		t.Errorf("got answers %+v", answers)
+	}
+}
+
+func TestInlineQueryNotAllowed(t *testing.T) {
+	tgBot, sdSrv := newTestBot(t)
+	defer func(delay time.Duration) { inlineDebounceDelay = delay }(inlineDebounceDelay)
+	inlineDebounceDelay = 10 * time.Millisecond
+
+	tgBot.ProcessUpdate(context.Background(), &models.Update{InlineQuery: &models.InlineQuery{
+		ID:    "q1",
+		From:  &models.User{ID: testUserID + 1},
+		Query: "a cat",
+	}})
+	if !tgBot.HasText("AnswerInlineQuery", i18n.English.T(i18n.UsageNotAllowed)) {
+		t.Error("usage not allowed answer not sent")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(sdSrv.Requests("/sdapi/v1/txt2img")) != 0 {
+		t.Error("render started for not allowed user")
+	}
+}
@@ -0,0 +1,257 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	reqqueue "github.com/kanootoko/stable-diffusion-telegram-bot/internal/req_queue"
+	"golang.org/x/exp/slices"
+)
+
+// Telegram sends an inline query for each typed character, so the render is started only
+// after the user stops typing for this long.
+var inlineDebounceDelay = 1500 * time.Millisecond
+
+// Renders which don't finish in this time can be started again by querying again.
+const inlineRenderTimeout = 10 * time.Minute
+
+// Number of inline queries for which the rendered images are kept.
+const inlineHistorySize = 1000
+
+// Titles and captions of inline results are cut to this length.
+const maxInlineTitleLength = 256
+
+// Start parameter of the buttons shown above the inline results, the button opens the
+// private chat with the bot.
+const inlineStartParameter = "inline"
+
+type inlineKey struct {
+	userID int64
+	query  string
+}
+
+type inlineRender struct {
+	started time.Time
+	results []models.InlineQueryResult
+}
+
+// inlineRenders keeps the renders started by inline queries, and the uploaded images which
+// are sent as the results of the same query. The oldest entries are dropped when it's full.
+type inlineRenders struct {
+	mutex   sync.Mutex
+	keys    []inlineKey
+	renders map[inlineKey]*inlineRender
+	// Debounced renders of the users who are typing a query.
+	pending map[int64]*pendingInlineRender
+}
+
+// Render start which is waiting for the debounce delay or is being parsed. It's canceled when
+// the user types a newer query.
+type pendingInlineRender struct {
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+func newInlineRenders() *inlineRenders {
+	return &inlineRenders{
+		renders: make(map[inlineKey]*inlineRender),
+		pending: make(map[int64]*pendingInlineRender),
+	}
+}
+
+// Returns a copy of the render of the query, or false if there's no render or it has timed out.
+func (r *inlineRenders) get(key inlineKey) (inlineRender, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	render, ok := r.renders[key]
+	if !ok || render.results == nil && time.Since(render.started) > inlineRenderTimeout {
+		return inlineRender{}, false
+	}
+	return *render, true
+}
+
+func (r *inlineRenders) add(key inlineKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.renders[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.renders[key] = &inlineRender{started: time.Now()}
+	if len(r.keys) > inlineHistorySize {
+		delete(r.renders, r.keys[0])
+		r.keys = r.keys[1:]
+	}
+}
+
+// Removes the render of the query, so it can be started again.
+func (r *inlineRenders) remove(key inlineKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.renders, key)
+	r.keys = slices.DeleteFunc(r.keys, func(k inlineKey) bool { return k == key })
+}
+
+func (r *inlineRenders) update(key inlineKey, f func(render *inlineRender)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if render, ok := r.renders[key]; ok {
+		f(render)
+	}
+}
+
+// Starts f after the debounce delay with a child of ctx, replacing the pending start of the
+// user's previous query. The context of the replaced start is canceled, also if f is running.
+func (r *inlineRenders) debounce(ctx context.Context, userID int64, f func(ctx context.Context)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if p, ok := r.pending[userID]; ok {
+		p.timer.Stop()
+		p.cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &pendingInlineRender{cancel: cancel}
+	p.timer = time.AfterFunc(inlineDebounceDelay, func() {
+		defer cancel()
+		r.mutex.Lock()
+		replaced := r.pending[userID] != p
+		r.mutex.Unlock()
+		if replaced {
+			return
+		}
+		f(ctx)
+
+		r.mutex.Lock()
+		if r.pending[userID] == p {
+			delete(r.pending, userID)
+		}
+		r.mutex.Unlock()
+	})
+	r.pending[userID] = p
+}
+
+// Returns the inline query results of the sent images. Spoiler images are skipped, as the
+// spoiler can't be kept in inline results.
+func inlineResults(msgs []*models.Message, query string) (results []models.InlineQueryResult) {
+	title := query
+	if len(title) > maxInlineTitleLength {
+		title = title[:maxInlineTitleLength-3] + "..."
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg == nil || msg.HasMediaSpoiler:
+		case len(msg.Photo) > 0:
+			results = append(results, &models.InlineQueryResultCachedPhoto{
+				ID:          fmt.Sprint(msg.ID),
+				PhotoFileID: msg.Photo[len(msg.Photo)-1].FileID,
+				Title:       title,
+				Caption:     title,
+			})
+		case msg.Document != nil:
+			results = append(results, &models.InlineQueryResultCachedDocument{
+				ID:             fmt.Sprint(msg.ID),
+				DocumentFileID: msg.Document.FileID,
+				Title:          title,
+				Caption:        title,
+			})
+		}
+	}
+	return results
+}
+
+func (c *CmdHandler) answerInlineButton(ctx context.Context, iq *models.InlineQuery, text string) {
+	if err := c.bot.AnswerInlineQuery(ctx, iq.ID, []models.InlineQueryResult{},
+		&models.InlineQueryResultsButton{Text: text, StartParameter: inlineStartParameter}); err != nil {
+		fmt.Println("  can't answer inline query:", err)
+	}
+}
+
+// Answers the inline query with the images rendered for it. If there are no images yet, the
+// render is started after the user stops typing, and the query should be sent again to get
+// the images. Renders are sent to the private chat of the user.
+func (c *CmdHandler) inlineQuery(ctx context.Context, iq *models.InlineQuery) {
+	if iq.From == nil {
+		return
+	}
+	fmt.Print("inline query from ", iq.From.Username, "#", iq.From.ID, ": ", iq.Query, "\n")
+	lang := c.lang(iq.From)
+
+	if !c.us.IsUsageAllowed(iq.From.ID, iq.From.ID) {
+		fmt.Println("  user not allowed, ignoring")
+		c.answerInlineButton(ctx, iq, lang.T(i18n.UsageNotAllowed))
+		return
+	}
+
+	query := strings.Join(strings.Fields(iq.Query), " ")
+	if query == "" {
+		c.answerInlineButton(ctx, iq, lang.T(i18n.InlineHelp))
+		return
+	}
+
+	key := inlineKey{userID: iq.From.ID, query: query}
+	if render, ok := c.inline.get(key); ok {
+		switch {
+		case len(render.results) > 0:
+			if err := c.bot.AnswerInlineQuery(ctx, iq.ID, render.results, nil); err != nil {
+				fmt.Println("  can't answer inline query:", err)
+			}
+		default:
+			c.answerInlineButton(ctx, iq, lang.T(i18n.InlineRendering))
+		}
+		return
+	}
+
+	// The render is started with the bot's context, as the query is answered before it.
+	from := *iq.From
+	c.inline.debounce(ctx, from.ID, func(ctx context.Context) {
+		c.inlineRender(ctx, key, &from)
+	})
+	c.answerInlineButton(ctx, iq, lang.T(i18n.InlineRendering))
+}
+
+// Adds the render of the inline query to the queue. Queue messages, parse errors and the
+// images are sent to the private chat of the user, the images are also stored as the results
+// of the query.
+func (c *CmdHandler) inlineRender(ctx context.Context, key inlineKey, from *models.User) {
+	if _, ok := c.inline.get(key); ok {
+		return
+	}
+	fmt.Println("  starting inline render for", from.Username, "#", from.ID, ":", key.query)
+	c.inline.add(key)
+
+	// Messages sent to the private chat are not replies, as there's no message to reply to.
+	msg := &models.Message{
+		Chat: models.Chat{ID: from.ID},
+		From: from,
+		Text: key.query,
+	}
+	reqParams, ok := c.parseRenderRequest(ctx, msg, key.query, nil)
+	if ctx.Err() != nil {
+		// Replaced by a newer query or the bot is stopping, the query can be rendered later.
+		fmt.Println("  inline render canceled")
+		c.inline.remove(key)
+		return
+	}
+	if !ok {
+		// The error is sent to the private chat, and the query is parsed again when it's sent
+		// again, for example after the missing LoRA has been added.
+		c.inline.remove(key)
+		return
+	}
+	c.reqQueue.Add(reqqueue.ReqQueueReq{
+		Type:    reqqueue.ReqTypeRender,
+		Message: msg,
+		Params:  reqParams,
+		Lang:    c.lang(from),
+		OnUploaded: func(msgs []*models.Message) {
+			results := inlineResults(msgs, key.query)
+			c.inline.update(key, func(render *inlineRender) {
+				render.results = append(render.results, results...)
+			})
+		},
+	})
+}
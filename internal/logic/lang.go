@@ -0,0 +1,67 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+// Value of /lang for using the language of the user's Telegram app.
+const langAuto = "auto"
+
+// Returns the language chosen by the user with /lang, or the language of the user's
+// Telegram app.
+func (c *CmdHandler) lang(user *models.User) i18n.Lang {
+	if user == nil {
+		return i18n.English
+	}
+	if c.langs != nil {
+		if lang, ok := c.langs.Get(user.ID); ok {
+			return lang
+		}
+	}
+	return i18n.Match(user.LanguageCode)
+}
+
+func languageNames() string {
+	var names []string
+	for _, l := range i18n.Languages {
+		names = append(names, fmt.Sprintf("<code>%s</code> (%s)", l, l.Name()))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Shows or changes the language of the user.
+func (c *CmdHandler) langCmd(ctx context.Context, msg *models.Message) {
+	lang := c.lang(msg.From)
+	arg := strings.TrimSpace(removeBotName(msg.Text))
+	if arg == "" {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Language, lang.Name(), languageNames()))
+		return
+	}
+	if c.langs == nil {
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.LanguageNotConfigured)))
+		return
+	}
+
+	var newLang i18n.Lang
+	if !strings.EqualFold(arg, langAuto) {
+		var ok bool
+		if newLang, ok = i18n.Parse(arg); !ok {
+			c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, i18n.Msg(i18n.LanguageUnknown, html.EscapeString(arg), languageNames())))
+			return
+		}
+	}
+	if err := c.langs.Set(msg.From.ID, newLang); err != nil {
+		fmt.Println("  error saving user language:", err)
+		c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.Error, err))
+		return
+	}
+	fmt.Println("  user language set to", arg)
+	lang = c.lang(msg.From)
+	c.bot.SendReplyToMessage(ctx, msg, lang.T(i18n.LanguageUpdated, lang.Name()))
+}
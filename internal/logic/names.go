@@ -0,0 +1,94 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"golang.org/x/exp/slices"
+)
+
+// Max. number of candidates listed in the ambiguous value errors.
+const maxAmbiguousCandidates = 10
+
+// NameAliases are the short names of models, samplers and upscalers defined by the admins,
+// read from a JSON file like {"models": {"jugg": "juggernautXL_v9Rundiffusionphoto2"}}.
+type NameAliases struct {
+	Models    map[string]string `json:"models"`
+	Samplers  map[string]string `json:"samplers"`
+	Upscalers map[string]string `json:"upscalers"`
+}
+
+// Reads the aliases from the JSON file at path, there are no aliases if path is empty.
+func LoadNameAliases(path string) (aliases NameAliases, err error) {
+	if path == "" {
+		return aliases, nil
+	}
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return aliases, fmt.Errorf("can't read name aliases: %w", err)
+	}
+	if err = json.Unmarshal(d, &aliases); err != nil {
+		return aliases, fmt.Errorf("can't parse name aliases: %w", err)
+	}
+	// Aliases are matched case-insensitively.
+	for _, m := range []*map[string]string{&aliases.Models, &aliases.Samplers, &aliases.Upscalers} {
+		lower := make(map[string]string, len(*m))
+		for alias, name := range *m {
+			lower[strings.ToLower(alias)] = name
+		}
+		*m = lower
+	}
+	return aliases, nil
+}
+
+// Resolves the name given by the user to one of the names. Exact matches, aliases, hashes (of
+// models), and then case-insensitive matches, prefixes and substrings are tried. Returns an
+// empty name if nothing matches, and an error listing the candidates if several names match.
+func resolveName(s string, names []string, aliases map[string]string, hashes map[string]string) (string, error) {
+	if slices.Contains(names, s) {
+		return s, nil
+	}
+	lower := strings.ToLower(s)
+	if lower == "" {
+		return "", nil
+	}
+	if name, ok := aliases[lower]; ok {
+		s, lower = name, strings.ToLower(name)
+		if slices.Contains(names, s) {
+			return s, nil
+		}
+	}
+	if name, ok := hashes[lower]; ok {
+		return name, nil
+	}
+
+	for _, matches := range []func(name string) bool{
+		func(name string) bool { return strings.ToLower(name) == lower },
+		func(name string) bool { return strings.HasPrefix(strings.ToLower(name), lower) },
+		func(name string) bool { return strings.Contains(strings.ToLower(name), lower) },
+	} {
+		var candidates []string
+		for _, name := range names {
+			if matches(name) {
+				candidates = append(candidates, name)
+			}
+		}
+		switch {
+		case len(candidates) == 1:
+			return candidates[0], nil
+		case len(candidates) > maxAmbiguousCandidates:
+			candidates = append(candidates[:maxAmbiguousCandidates], "…")
+			fallthrough
+		case len(candidates) > 1:
+			for i := range candidates {
+				candidates[i] = html.EscapeString(candidates[i])
+			}
+			return "", i18n.Errorf(i18n.AmbiguousValue, strings.Join(candidates, ", "))
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,90 @@
+package logic
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveName(t *testing.T) {
+	names := []string{"juggernautXL_v9Rundiffusionphoto2", "juggernaut_reborn", "dreamshaper_8", "DreamShaperXL_Turbo"}
+	aliases := map[string]string{"jxl": "juggernautXL_v9Rundiffusionphoto2", "gone": "removed_model"}
+	hashes := map[string]string{"879db523c3": "dreamshaper_8"}
+
+	tests := []struct {
+		s              string
+		expected       string
+		expectedErrStr string
+	}{
+		{"dreamshaper_8", "dreamshaper_8", ""},
+		{"JXL", "juggernautXL_v9Rundiffusionphoto2", ""},
+		{"879DB523C3", "dreamshaper_8", ""},
+		{"DREAMSHAPER_8", "dreamshaper_8", ""},
+		{"juggernautx", "juggernautXL_v9Rundiffusionphoto2", ""},
+		{"reborn", "juggernaut_reborn", ""},
+		{"turbo", "DreamShaperXL_Turbo", ""},
+		{"jugg", "", "ambiguous value, it matches juggernautXL_v9Rundiffusionphoto2, juggernaut_reborn"},
+		{"xl", "", "ambiguous value, it matches juggernautXL_v9Rundiffusionphoto2, DreamShaperXL_Turbo"},
+		{"gone", "", ""},
+		{"sdxl", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			name, err := resolveName(tt.s, names, aliases, hashes)
+			if tt.expectedErrStr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErrStr) {
+					t.Errorf("got error %v, expected %q", err, tt.expectedErrStr)
+				}
+				return
+			}
+			if err != nil || name != tt.expected {
+				t.Errorf("got %q, error %v, expected %q", name, err, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveNameManyCandidates(t *testing.T) {
+	var names []string
+	for _, c := range "abcdefghijkl" {
+		names = append(names, "model_"+string(c))
+	}
+	_, err := resolveName("model", names, nil, nil)
+	if err == nil || !strings.HasSuffix(err.Error(), "model_j, …") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestResolveNameEscapesCandidates(t *testing.T) {
+	_, err := resolveName("model", []string{"model<1>", "model&2"}, nil, nil)
+	if err == nil || !strings.HasSuffix(err.Error(), "model&lt;1&gt;, model&amp;2") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestLoadNameAliases(t *testing.T) {
+	aliases, err := LoadNameAliases("")
+	if err != nil || aliases.Models != nil {
+		t.Errorf("got %+v, error %v", aliases, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "aliases.json")
+	if err = os.WriteFile(path, []byte(`{"models": {"Jugg": "juggernautXL_v9"}, "upscalers": {"ESR": "R-ESRGAN 4x+"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if aliases, err = LoadNameAliases(path); err != nil {
+		t.Fatal(err)
+	}
+	if aliases.Models["jugg"] != "juggernautXL_v9" || aliases.Upscalers["esr"] != "R-ESRGAN 4x+" || len(aliases.Samplers) != 0 {
+		t.Errorf("got %+v", aliases)
+	}
+
+	if err = os.WriteFile(path, []byte(`{"models": [`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadNameAliases(path); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
@@ -0,0 +1,99 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
+	"golang.org/x/exp/slices"
+)
+
+var loraTagRegex = regexp.MustCompile(`<(?:lora|lyco):([^:>]+)[^>]*>`)
+var promptWordRegex = regexp.MustCompile(`[\p{L}\p{N}_.-]+`)
+
+// Parses LoRAs in the "name:weight" form. Weight is optional and defaults to 1.
+func parseLoRA(s string) (lora reqparams.ReqParamsLoRA, err error) {
+	lora.Name = s
+	lora.Weight = 1
+	if colonAt := strings.LastIndex(s, ":"); colonAt >= 0 {
+		lora.Name = s[:colonAt]
+		lora.Weight, err = strconv.ParseFloat(s[colonAt+1:], 64)
+		if err != nil {
+			return lora, i18n.Errorf(i18n.InvalidLoRAWeight)
+		}
+	}
+	if lora.Name == "" {
+		return lora, i18n.Errorf(i18n.MissingLoRAName)
+	}
+	return lora, nil
+}
+
+// Returns the suggestion of the closest candidates, or an empty message if there are none.
+func didYouMean(s string, candidates []string) i18n.Message {
+	matches := utils.ClosestMatches(s, candidates, 3)
+	if len(matches) == 0 {
+		return i18n.Message{}
+	}
+	return i18n.Msg(i18n.DidYouMean, strings.Join(matches, ", "))
+}
+
+// Checks that the LoRAs of the -lora attributes and the <lora:...> tags exist. Words in the
+// prompts which are very similar to, but not exactly matching available embedding names are
+// returned as a hint, as they can be ordinary words.
+func validateExtraNetworks(ctx context.Context, sdApi sdapi.Backend, r *reqparams.ReqParamsRender) (hint i18n.Message, err error) {
+	var loraNames []string
+	for _, l := range r.LoRAs {
+		loraNames = append(loraNames, l.Name)
+	}
+	prompts := []string{r.Prompt, r.NegativePrompt}
+	for _, p := range prompts {
+		for _, match := range loraTagRegex.FindAllStringSubmatch(p, -1) {
+			loraNames = append(loraNames, match[1])
+		}
+	}
+
+	var knownLoRAs []string
+	if len(loraNames) > 0 {
+		loras, err := sdApi.GetLoRAInfos(ctx)
+		if err != nil {
+			return hint, i18n.Errorf(i18n.GettingLoRAsError, err)
+		}
+		for _, l := range loras {
+			knownLoRAs = append(knownLoRAs, l.Name)
+			if l.Alias != "" && l.Alias != l.Name {
+				knownLoRAs = append(knownLoRAs, l.Alias)
+			}
+		}
+		for _, name := range loraNames {
+			if !slices.Contains(knownLoRAs, name) {
+				return hint, i18n.Errorf(i18n.UnknownLoRA, name, didYouMean(name, knownLoRAs))
+			}
+		}
+	}
+
+	embs, err := sdApi.GetEmbeddings(ctx)
+	if err != nil { // Embeddings are only hints, so not failing the request.
+		fmt.Println("  error getting embeddings:", err)
+		return hint, nil
+	}
+	for _, p := range prompts {
+		p = loraTagRegex.ReplaceAllString(p, "")
+		for _, word := range promptWordRegex.FindAllString(p, -1) {
+			if len(word) < 5 || slices.Contains(embs, word) || slices.Contains(knownLoRAs, word) {
+				continue
+			}
+			for _, emb := range embs {
+				if utils.EditDistance(strings.ToLower(word), strings.ToLower(emb)) <= 1 {
+					return i18n.Msg(i18n.EmbeddingHint, word, emb), nil
+				}
+			}
+		}
+	}
+	return hint, nil
+}
@@ -3,37 +3,468 @@ package logic
 import (
 	"context"
 	"fmt"
+	"html"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/google/shlex"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
 	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
 	"golang.org/x/exp/slices"
 )
 
-// Returns -1 as firstCmdCharAt if no params have been found in the given string.
-func ReqParamsParse(ctx context.Context, sdApi *sdapi.SdAPIType, defaults config.GenerationDefaults, s string, reqParams reqparams.ReqParams) (firstCmdCharAt int, err error) {
+func isSDXLModel(modelName string) bool {
+	return strings.Contains(strings.ToLower(modelName), "xl")
+}
+
+// Parses aspect ratios in the "16:9" form.
+func parseAspectRatio(s string) (w, h float64, err error) {
+	sides := strings.Split(s, ":")
+	if len(sides) != 2 {
+		return 0, 0, i18n.Errorf(i18n.InvalidAspectRatio)
+	}
+	if w, err = strconv.ParseFloat(sides[0], 64); err != nil || w <= 0 {
+		return 0, 0, i18n.Errorf(i18n.InvalidAspectRatioWidth)
+	}
+	if h, err = strconv.ParseFloat(sides[1], 64); err != nil || h <= 0 {
+		return 0, 0, i18n.Errorf(i18n.InvalidAspectRatioHeight)
+	}
+	return w, h, nil
+}
+
+// Parses floats in the 0-1 range.
+func parseUnitFloat(s string) (float32, error) {
+	valFloat, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, i18n.Errorf(i18n.InvalidNumber)
+	}
+	if valFloat < 0 || valFloat > 1 {
+		return 0, i18n.Errorf(i18n.InvalidFraction)
+	}
+	return float32(valFloat), nil
+}
+
+func snapToMultiple(v float64, multiple int) int {
+	return max(int(math.Round(v/float64(multiple)))*multiple, multiple)
+}
+
+func snapDownToMultiple(v float64, multiple int) int {
+	return max(int(v/float64(multiple))*multiple, multiple)
+}
+
+// Calculates the output resolution for the given aspect ratio. The pixel count stays close to
+// the default resolution's pixel count of the model family, lowered to fit in the max. size of
+// the limits. If only one side is given by the user, then only the other side is calculated.
+func applyAspectRatio(r *reqparams.ReqParamsRender, defaults config.GenerationDefaults, limits config.GenerationLimits, arW, arH float64, gotWidth, gotHeight bool) {
+	pixelBudget := defaults.Width * defaults.Height
+	snap := 8
+	if isSDXLModel(r.ModelName) {
+		pixelBudget = defaults.WidthSDXL * defaults.HeightSDXL
+		snap = 64
+	}
+
+	switch {
+	case gotWidth:
+		r.Height = snapToMultiple(float64(r.Width)*arH/arW, snap)
+	case gotHeight:
+		r.Width = snapToMultiple(float64(r.Height)*arW/arH, snap)
+	default:
+		width := math.Sqrt(float64(pixelBudget) * arW / arH)
+		height := width * arH / arW
+		if fit := min(float64(limits.MaxWidth)/width, float64(limits.MaxHeight)/height); fit < 1 {
+			r.Width = snapDownToMultiple(width*fit, snap)
+			r.Height = snapDownToMultiple(height*fit, snap)
+		} else {
+			r.Width = snapToMultiple(width, snap)
+			r.Height = snapToMultiple(height, snap)
+		}
+	}
+}
+
+func validateRenderLimits(r *reqparams.ReqParamsRender, limits config.GenerationLimits) error {
+	if r.Width < limits.MinWidth || r.Width > limits.MaxWidth {
+		return i18n.Errorf(i18n.OutOfRange, i18n.Msg(i18n.ParamWidth), r.Width, limits.MinWidth, limits.MaxWidth)
+	}
+	if r.Height < limits.MinHeight || r.Height > limits.MaxHeight {
+		return i18n.Errorf(i18n.OutOfRange, i18n.Msg(i18n.ParamHeight), r.Height, limits.MinHeight, limits.MaxHeight)
+	}
+	if r.Steps < limits.MinSteps || r.Steps > limits.MaxSteps {
+		return i18n.Errorf(i18n.OutOfRange, i18n.Msg(i18n.ParamSteps), r.Steps, limits.MinSteps, limits.MaxSteps)
+	}
+	if r.NumOutputs < limits.MinCnt || r.NumOutputs > limits.MaxCnt {
+		return i18n.Errorf(i18n.OutOfRange, i18n.Msg(i18n.ParamCnt), r.NumOutputs, limits.MinCnt, limits.MaxCnt)
+	}
+	if r.BatchSize < limits.MinBatch || r.BatchSize > limits.MaxBatch {
+		return i18n.Errorf(i18n.OutOfRange, i18n.Msg(i18n.ParamBatchSize), r.BatchSize, limits.MinBatch, limits.MaxBatch)
+	}
+	if r.CFGScale < limits.MinCFGScale || r.CFGScale > limits.MaxCFGScale {
+		return i18n.Errorf(i18n.CFGScaleOutOfRange, r.CFGScale, limits.MinCFGScale, limits.MaxCFGScale)
+	}
+	// The highres second pass renders at the scaled size, so it has to fit in the limits. The
+	// upscaler is not used with highres.
+	if r.HR.Scale > 0 {
+		width, height := reqparams.ScaledSize(r.Width, r.Height, r.HR.Scale)
+		if width > limits.MaxWidth || height > limits.MaxHeight {
+			return i18n.Errorf(i18n.HRSizeTooLarge, width, height, limits.MaxWidth, limits.MaxHeight)
+		}
+	} else if r.Upscale.Scale > 0 {
+		width, height := reqparams.ScaledSize(r.Width, r.Height, r.Upscale.Scale)
+		maxWidth, maxHeight := maxUpscaledSize(limits)
+		if width > maxWidth || height > maxHeight {
+			return i18n.Errorf(i18n.UpscaledSizeTooLarge, width, height, maxWidth, maxHeight)
+		}
+	}
+	return nil
+}
+
+// Returns the max. size of upscaled images, which is 4 times the max. render size.
+func maxUpscaledSize(limits config.GenerationLimits) (width, height int) {
+	return limits.MaxWidth * 4, limits.MaxHeight * 4
+}
+
+// Parses the value of an attribute, the value is empty for flags.
+type attrParseFunc func(p *attrParser, val string) error
+
+// attrParser keeps the state of parsing the attributes of a request.
+type attrParser struct {
+	ctx     context.Context
+	sdApi   sdapi.Backend
+	caps    sdapi.Capabilities
+	limits  config.GenerationLimits
+	aliases NameAliases
+
+	// Only one of render and upscale is set, output is the output of the set one.
+	render  *reqparams.ReqParamsRender
+	upscale *reqparams.ReqParamsUpscale
+	output  *reqparams.ReqParamsOutput
+
+	// Canonical names of the given attributes.
+	given        map[string]bool
+	aspectRatioW float64
+	aspectRatioH float64
+	// Value lists fetched from the API, each list is fetched at most once per request.
+	lists map[valueList][]string
+	// Model names by their short and SHA256 hashes, set when the model list is fetched.
+	modelHashes map[string]string
+}
+
+// Lists of valid attribute values which are fetched from the API.
+type valueList int
+
+const (
+	samplerList valueList = iota
+	modelList
+	vaeList
+	schedulerList
+	upscalerList
+)
+
+func (p *attrParser) list(l valueList) ([]string, error) {
+	if values, ok := p.lists[l]; ok {
+		return values, nil
+	}
+	var values []string
+	var err error
+	var errKey i18n.Key
+	switch l {
+	case samplerList:
+		values, err = p.sdApi.GetSamplers(p.ctx)
+		errKey = i18n.GettingSamplersError
+	case modelList:
+		var models []sdapi.ModelInfo
+		models, err = p.sdApi.GetModelInfos(p.ctx)
+		errKey = i18n.GettingModelsError
+		p.modelHashes = make(map[string]string)
+		for _, m := range models {
+			values = append(values, m.Name)
+			for _, hash := range []string{m.Hash, m.SHA256} {
+				if hash != "" {
+					p.modelHashes[strings.ToLower(hash)] = m.Name
+				}
+			}
+		}
+	case vaeList:
+		values, err = p.sdApi.GetVAEs(p.ctx)
+		errKey = i18n.GettingVAEsError
+		values = append(append([]string{}, values...), "Automatic", "None")
+	case schedulerList:
+		values, err = p.sdApi.GetSchedulers(p.ctx)
+		errKey = i18n.GettingSchedulersError
+	case upscalerList:
+		values, err = p.sdApi.GetUpscalers(p.ctx)
+		errKey = i18n.GettingUpscalersError
+	}
+	if err != nil {
+		return nil, i18n.Errorf(errKey, err)
+	}
+	p.lists[l] = values
+	return values, nil
+}
+
+// intRange and floatRange validate parsed numbers.
+type intRange func(p *attrParser, v int) error
+type floatRange func(p *attrParser, v float64) error
+
+// Returns a range of the generation limits, which are reported in the out of range errors.
+func limitRange(param i18n.Key, bounds func(l config.GenerationLimits) (int, int)) intRange {
+	return func(p *attrParser, v int) error {
+		lo, hi := bounds(p.limits)
+		if v < lo || v > hi {
+			return i18n.Errorf(i18n.OutOfRange, i18n.Msg(param), v, lo, hi)
+		}
+		return nil
+	}
+}
+
+func cfgScaleRange(p *attrParser, v float64) error {
+	if v < p.limits.MinCFGScale || v > p.limits.MaxCFGScale {
+		return i18n.Errorf(i18n.CFGScaleOutOfRange, v, p.limits.MinCFGScale, p.limits.MaxCFGScale)
+	}
+	return nil
+}
+
+// Returns a range with the invalid value error, which should list the valid values.
+func fixedRange(lo, hi int, invalid i18n.Key) intRange {
+	return func(p *attrParser, v int) error {
+		if v < lo || v > hi {
+			return i18n.Errorf(invalid)
+		}
+		return nil
+	}
+}
+
+func fixedFloatRange(lo, hi float64, invalid i18n.Key) floatRange {
+	return func(p *attrParser, v float64) error {
+		if v < lo || v > hi {
+			return i18n.Errorf(invalid)
+		}
+		return nil
+	}
+}
+
+func intAttr(invalid i18n.Key, valid intRange, set func(p *attrParser, v int)) attrParseFunc {
+	return func(p *attrParser, val string) error {
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return i18n.Errorf(invalid)
+		}
+		if valid != nil {
+			if err = valid(p, v); err != nil {
+				return err
+			}
+		}
+		set(p, v)
+		return nil
+	}
+}
+
+func floatAttr(invalid i18n.Key, valid floatRange, set func(p *attrParser, v float64)) attrParseFunc {
+	return func(p *attrParser, val string) error {
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return i18n.Errorf(invalid)
+		}
+		if valid != nil {
+			if err = valid(p, v); err != nil {
+				return err
+			}
+		}
+		set(p, v)
+		return nil
+	}
+}
+
+// Returns a parser of floats in the 0-1 range, the invalid error gets the reason as its arg.
+func unitAttr(invalid i18n.Key, set func(p *attrParser, v float32)) attrParseFunc {
+	return func(p *attrParser, val string) error {
+		v, err := parseUnitFloat(val)
+		if err != nil {
+			return i18n.Errorf(invalid, err)
+		}
+		set(p, v)
+		return nil
+	}
+}
+
+// Returns a parser of values which have to be in the list, the closest values are suggested
+// for invalid ones.
+func listAttr(l valueList, invalid i18n.Key, set func(p *attrParser, v string)) attrParseFunc {
+	return func(p *attrParser, val string) error {
+		values, err := p.list(l)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(values, val) {
+			return i18n.Errorf(invalid, didYouMean(val, values))
+		}
+		set(p, val)
+		return nil
+	}
+}
+
+// Returns a parser of models, samplers and upscalers, which can also be given by their aliases,
+// hashes (of models), and case-insensitive prefixes and substrings of their names.
+func nameAttr(l valueList, invalid i18n.Key, set func(p *attrParser, v string)) attrParseFunc {
+	return func(p *attrParser, val string) error {
+		name, err := p.resolveName(l, val, invalid)
+		if err != nil {
+			return err
+		}
+		set(p, name)
+		return nil
+	}
+}
+
+func (p *attrParser) resolveName(l valueList, val string, invalid i18n.Key) (string, error) {
+	names, err := p.list(l)
+	if err != nil {
+		return "", err
+	}
+	var aliases, hashes map[string]string
+	switch l {
+	case modelList:
+		aliases, hashes = p.aliases.Models, p.modelHashes
+	case samplerList:
+		aliases = p.aliases.Samplers
+	case upscalerList:
+		aliases = p.aliases.Upscalers
+	}
+	name, err := resolveName(val, names, aliases, hashes)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", i18n.Errorf(invalid, didYouMean(val, names))
+	}
+	return name, nil
+}
+
+func flagAttr(set func(p *attrParser)) attrParseFunc {
+	return func(p *attrParser, _ string) error {
+		set(p)
+		return nil
+	}
+}
+
+// Sets both the render and the upscale field, only one of them is used by the request.
+func (p *attrParser) setUpscaler(v string) {
+	if p.render != nil {
+		p.render.Upscale.Upscaler = v
+	} else {
+		p.upscale.Upscaler = v
+	}
+}
+
+func parseSeed(p *attrParser, val string) error {
+	seed, err := strconv.ParseUint(strings.TrimPrefix(val, "🌱"), 10, 32)
+	if err != nil {
+		return i18n.Errorf(i18n.InvalidSeed)
+	}
+	p.render.Seed = uint32(seed)
+	return nil
+}
+
+func parseFormat(p *attrParser, val string) error {
+	val = strings.ToLower(val)
+	if val == "jpeg" {
+		val = "jpg"
+	}
+	if !slices.Contains([]string{"jpg", "png", "webp"}, val) {
+		return i18n.Errorf(i18n.InvalidFormat)
+	}
+	p.output.Format = val
+	return nil
+}
+
+func parseAspectRatioAttr(p *attrParser, val string) (err error) {
+	p.aspectRatioW, p.aspectRatioH, err = parseAspectRatio(val)
+	return err
+}
+
+func parseLoRAAttr(p *attrParser, val string) error {
+	lora, err := parseLoRA(val)
+	if err != nil {
+		return err
+	}
+	p.render.LoRAs = append(p.render.LoRAs, lora)
+	return nil
+}
+
+// Parses refiners in the "model:switchAt" form, switchAt is optional and defaults to 0.8.
+func parseRefiner(p *attrParser, val string) error {
+	if !p.caps.Refiner {
+		return p.caps.NotSupportedError("-refiner")
+	}
+	p.render.RefinerSwitchAt = 0.8
+	if i := strings.LastIndex(val, ":"); i > 0 {
+		if switchAt, err := strconv.ParseFloat(val[i+1:], 64); err == nil {
+			if switchAt <= 0 || switchAt >= 1 {
+				return i18n.Errorf(i18n.InvalidRefinerSwitch)
+			}
+			val = val[:i]
+			p.render.RefinerSwitchAt = switchAt
+		}
+	}
+	model, err := p.resolveName(modelList, val, i18n.InvalidRefinerModel)
+	if err != nil {
+		return err
+	}
+	p.render.Refiner = model
+	return nil
+}
+
+func parseScheduler(p *attrParser, val string) error {
+	if !p.caps.Scheduler {
+		return p.caps.NotSupportedError("-scheduler")
+	}
+	return listAttr(schedulerList, i18n.InvalidScheduler, func(p *attrParser, v string) { p.render.Scheduler = v })(p, val)
+}
+
+// Parses upscale target sizes in the "WxH" form.
+func parseTargetSize(p *attrParser, val string) error {
+	var width, height int
+	if _, err := fmt.Sscanf(strings.ToLower(val), "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return i18n.Errorf(i18n.InvalidTargetSize)
+	}
+	if maxWidth, maxHeight := maxUpscaledSize(p.limits); width > maxWidth || height > maxHeight {
+		return i18n.Errorf(i18n.TargetSizeTooLarge, maxWidth, maxHeight)
+	}
+	p.upscale.TargetWidth = width
+	p.upscale.TargetHeight = height
+	return nil
+}
+
+// Parses the "-attr value" and "-attr=value" params at the end of the prompt. Returns -1 as
+// firstCmdCharAt if no params have been found in the given string.
+func ReqParamsParse(ctx context.Context, sdApi sdapi.Backend, defaults config.GenerationDefaults, limits config.GenerationLimits, aliases NameAliases, s string, reqParams reqparams.ReqParams) (firstCmdCharAt int, err error) {
 	lexer := shlex.NewLexer(strings.NewReader(s))
 
-	var reqParamsRender *reqparams.ReqParamsRender
-	var reqParamsUpscale *reqparams.ReqParamsUpscale
+	p := &attrParser{
+		ctx:     ctx,
+		sdApi:   sdApi,
+		caps:    sdApi.Capabilities(),
+		limits:  limits,
+		aliases: aliases,
+		given:   make(map[string]bool),
+		lists:   make(map[valueList][]string),
+	}
+	var scope attrScope
 	switch v := reqParams.(type) {
 	case *reqparams.ReqParamsRender:
-		reqParamsRender = v
+		p.render = v
+		p.output = &v.Output
+		scope = attrRender
 	case *reqparams.ReqParamsUpscale:
-		reqParamsUpscale = v
+		p.upscale = v
+		p.output = &v.Output
+		scope = attrUpscale
 	default:
 		return 0, fmt.Errorf("invalid reqParams type")
 	}
 
-	gotWidth := false
-	gotHeight := false
-	gotSteps := false
-	gotNumOutputs := false
-	gotBatchSize := false
-
 	firstCmdCharAt = -1
 	for {
 		token, lexErr := lexer.Next()
@@ -43,299 +474,192 @@ func ReqParamsParse(ctx context.Context, sdApi *sdapi.SdAPIType, defaults config
 
 		if token[0] != '-' {
 			if firstCmdCharAt > -1 {
-				return 0, fmt.Errorf("params need to be after the prompt")
+				return 0, i18n.Errorf(i18n.ParamsAfterPrompt)
 			}
 			continue // Ignore tokens not starting with -
 		}
 
-		attr := strings.ToLower(token[1:])
-		validAttr := false
-
-		switch attr {
-		case "seed", "s":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			val = strings.TrimPrefix(val, "🌱")
-			valInt, err := strconv.ParseUint(val, 10, 32)
-			if err != nil {
-				return 0, fmt.Errorf("invalid seed")
-			}
-			reqParamsRender.Seed = uint32(valInt)
-			validAttr = true
-		case "width", "w":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid width")
-			}
-			reqParamsRender.Width = valInt
-			validAttr = true
-			gotWidth = true
-		case "height", "h":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid height")
-			}
-			reqParamsRender.Height = valInt
-			validAttr = true
-			gotHeight = true
-		case "steps", "t":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid steps")
-			}
-			reqParamsRender.Steps = valInt
-			validAttr = true
-			gotSteps = true
-		case "batch", "b":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid batch size")
-			}
-			reqParamsRender.BatchSize = valInt
-			validAttr = true
-			gotBatchSize = true
-		case "cnt", "o":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid output count")
-			}
-			reqParamsRender.NumOutputs = valInt
-			validAttr = true
-			gotNumOutputs = true
-		case "png", "p":
-			if reqParamsRender != nil {
-				reqParamsRender.OutputPNG = true
-			} else if reqParamsUpscale != nil {
-				reqParamsUpscale.OutputPNG = true
-			}
-			validAttr = true
-		case "cfg", "c":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valFloat, err := strconv.ParseFloat(val, 64)
-			if err != nil {
-				return 0, fmt.Errorf("  invalid CFG scale")
-			}
-			reqParamsRender.CFGScale = valFloat
-			validAttr = true
-		case "sampler", "r":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			samplers, err := sdApi.GetSamplers(ctx)
-			if err != nil {
-				return 0, fmt.Errorf("error getting samplers: %w", err)
-			}
-			if !slices.Contains(samplers, val) {
-				return 0, fmt.Errorf("invalid sampler")
-			}
-			reqParamsRender.SamplerName = val
-			validAttr = true
-		case "model", "m":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			models, err := sdApi.GetModels(ctx)
-			if err != nil {
-				return 0, fmt.Errorf("error getting models: %w", err)
-			}
-			if !slices.Contains(models, val) {
-				return 0, fmt.Errorf(" invalid model")
-			}
-			reqParamsRender.ModelName = val
-			validAttr = true
-		case "upscale", "u":
-			if reqParamsRender == nil && reqParamsUpscale == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valFloat, err := strconv.ParseFloat(val, 32)
-			if err != nil {
-				return 0, fmt.Errorf("invalid hr scale")
-			}
-			if reqParamsRender != nil {
-				reqParamsRender.Upscale.Scale = float32(valFloat)
-			} else if reqParamsUpscale != nil {
-				reqParamsUpscale.Scale = float32(valFloat)
-			}
-			validAttr = true
-		case "upscaler":
-			if reqParamsRender == nil && reqParamsUpscale == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			upscalers, err := sdApi.GetUpscalers(ctx)
-			if err != nil {
-				return 0, fmt.Errorf("error getting upscalers: %w", err)
-			}
-			if !slices.Contains(upscalers, val) {
-				return 0, fmt.Errorf("invalid upscaler")
-			}
-			if reqParamsRender != nil {
-				reqParamsRender.Upscale.Upscaler = val
-			} else if reqParamsUpscale != nil {
-				reqParamsUpscale.Upscaler = val
-			}
-			validAttr = true
-		case "hr":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valFloat, err := strconv.ParseFloat(val, 32)
-			if err != nil {
-				return 0, fmt.Errorf("invalid hr scale")
-			}
-			reqParamsRender.HR.Scale = float32(valFloat)
-			validAttr = true
-		case "hr-denoisestrength", "hrd":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valFloat, err := strconv.ParseFloat(val, 32)
-			if err != nil {
-				return 0, fmt.Errorf("invalid hr denoise strength")
-			}
-			reqParamsRender.HR.DenoisingStrength = float32(valFloat)
-			validAttr = true
-		case "hr-upscaler", "hru":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			upscalers, err := sdApi.GetUpscalers(ctx)
-			if err != nil {
-				return 0, fmt.Errorf("error getting upscalers: %w", err)
+		name, val, hasVal := strings.Cut(token[1:], "=")
+		a, ok := findAttribute(name)
+		if !ok || a.scope&scope == 0 {
+			// Unknown attributes and attributes of the other request type are kept in the
+			// prompt, but they can't be after the params.
+			switch {
+			case firstCmdCharAt == -1:
+				continue
+			case ok:
+				return 0, i18n.Errorf(i18n.AttrNotApplicable, "-"+name, a.commands())
+			default:
+				return 0, i18n.Errorf(i18n.UnknownAttr, html.EscapeString("-"+name), didYouMean(name, attributeNames()))
 			}
-			if !slices.Contains(upscalers, val) {
-				return 0, fmt.Errorf("invalid upscaler")
-			}
-			reqParamsRender.HR.Upscaler = val
-			validAttr = true
-		case "hr-steps", "hrt":
-			if reqParamsRender == nil {
-				break
-			}
-			val, lexErr := lexer.Next()
-			if lexErr != nil {
-				return 0, fmt.Errorf(attr + " is missing value")
-			}
-			valInt, err := strconv.Atoi(val)
-			if err != nil {
-				return 0, fmt.Errorf("invalid hr second pass steps")
-			}
-			reqParamsRender.HR.SecondPassSteps = valInt
-			validAttr = true
 		}
 
-		if validAttr && firstCmdCharAt == -1 {
+		given := token
+		switch {
+		case a.flag && hasVal:
+			return 0, i18n.Errorf(i18n.AttrError, html.EscapeString(given), i18n.Msg(i18n.AttrNoValue))
+		case !a.flag && !hasVal:
+			if val, lexErr = lexer.Next(); lexErr != nil {
+				return 0, i18n.Errorf(i18n.AttrError, html.EscapeString(given), i18n.Msg(i18n.MissingValue))
+			}
+			given += " " + val
+		}
+		if err = a.parse(p, val); err != nil {
+			return 0, i18n.Errorf(i18n.AttrError, html.EscapeString(given), err)
+		}
+		p.given[a.names[0]] = true
+
+		if firstCmdCharAt == -1 {
 			firstCmdCharAt = strings.Index(s, token)
+			if firstCmdCharAt == -1 { // The value is quoted, like -w="640".
+				firstCmdCharAt = strings.Index(s, "-"+name+"=")
+			}
 		}
 	}
 
-	if reqParamsRender != nil {
-		if !gotNumOutputs {
-			reqParamsRender.NumOutputs = defaults.Cnt
+	if p.render != nil {
+		if err = p.applyRenderDefaults(defaults); err != nil {
+			return 0, err
 		}
-		if !gotBatchSize {
-			reqParamsRender.BatchSize = defaults.Batch
+	}
+
+	// WebP images are always lossless.
+	if p.given["quality"] && p.output.Format == "webp" {
+		return 0, i18n.Errorf(i18n.QualityWithWebP)
+	}
+
+	if !p.given["doc"] && !p.given["photo"] {
+		switch defaults.SendAs {
+		case "document":
+			p.output.AsDocument = true
+		case "photo":
+			p.output.AsDocument = false
+		default:
+			p.output.AsDocument = p.output.Format == "png"
 		}
-		if strings.Contains(strings.ToLower(reqParamsRender.ModelName), "xl") {
-			if !gotWidth {
-				reqParamsRender.Width = defaults.WidthSDXL
-			}
-			if !gotHeight {
-				reqParamsRender.Height = defaults.HeightSDXL
-			}
-			if !gotSteps {
-				reqParamsRender.Steps = defaults.Steps
-			}
-		} else {
-			if !gotWidth {
-				reqParamsRender.Width = defaults.Width
-			}
-			if !gotHeight {
-				reqParamsRender.Height = defaults.Height
-			}
-			if !gotSteps {
-				reqParamsRender.Steps = defaults.StepsSDXL
+	}
+
+	if p.upscale != nil {
+		if err = p.caps.CheckUpscale(*p.upscale); err != nil {
+			return 0, err
+		}
+		if p.upscale.Upscaler == "" {
+			if p.upscale.Upscaler, err = p.defaultUpscaler(defaults.Upscaler, defaultUpscaler); err != nil {
+				return 0, err
 			}
 		}
-
-		// Don't allow upscaler while HR is enabled.
-		if reqParamsRender.HR.Scale > 0 {
-			reqParamsRender.Upscale.Scale = 0
+		if p.upscale.Upscaler2 != "" && p.upscale.Upscaler2Visibility == 0 {
+			p.upscale.Upscaler2Visibility = 0.5
 		}
+		// The size of the image is checked when it's received.
+		p.upscale.MaxWidth, p.upscale.MaxHeight = maxUpscaledSize(p.limits)
 	}
 
 	return
 }
+
+// Sets the defaults of the params which are not given, and validates the render params.
+func (p *attrParser) applyRenderDefaults(defaults config.GenerationDefaults) error {
+	r, limits := p.render, p.limits
+	// Defaults are lowered to the limits, which can be lower than the defaults in group
+	// chats with chat settings.
+	if !p.given["cnt"] {
+		r.NumOutputs = min(defaults.Cnt, limits.MaxCnt)
+	}
+	if !p.given["batch"] {
+		r.BatchSize = min(defaults.Batch, limits.MaxBatch)
+	}
+	if isSDXLModel(r.ModelName) {
+		if !p.given["width"] {
+			r.Width = min(defaults.WidthSDXL, limits.MaxWidth)
+		}
+		if !p.given["height"] {
+			r.Height = min(defaults.HeightSDXL, limits.MaxHeight)
+		}
+		if !p.given["steps"] {
+			r.Steps = min(defaults.StepsSDXL, limits.MaxSteps)
+		}
+	} else {
+		if !p.given["width"] {
+			r.Width = min(defaults.Width, limits.MaxWidth)
+		}
+		if !p.given["height"] {
+			r.Height = min(defaults.Height, limits.MaxHeight)
+		}
+		if !p.given["steps"] {
+			r.Steps = min(defaults.Steps, limits.MaxSteps)
+		}
+	}
+
+	if p.aspectRatioW > 0 {
+		if p.given["width"] && p.given["height"] {
+			return i18n.Errorf(i18n.AspectRatioWithSize)
+		}
+		applyAspectRatio(r, defaults, limits, p.aspectRatioW, p.aspectRatioH, p.given["width"], p.given["height"])
+	}
+
+	if err := validateRenderLimits(r, limits); err != nil {
+		return err
+	}
+
+	if err := p.caps.CheckRender(*r); err != nil {
+		return err
+	}
+
+	// Don't allow upscaler while HR is enabled.
+	if r.HR.Scale > 0 {
+		r.Upscale.Scale = 0
+	}
+
+	var err error
+	if p.caps.Flavor == sdapi.FlavorComfyUI && !p.given["sampler"] {
+		if r.SamplerName, err = p.backendDefault(samplerList, r.SamplerName, i18n.NoDefaultSampler); err != nil {
+			return err
+		}
+	}
+	if r.HR.Scale > 0 && r.HR.Upscaler == "" {
+		r.HR.Upscaler, err = p.defaultUpscaler(defaults.HRUpscaler, defaultHRUpscaler)
+	} else if r.Upscale.Scale > 0 && r.Upscale.Upscaler == "" {
+		r.Upscale.Upscaler, err = p.defaultUpscaler(defaults.Upscaler, defaultUpscaler)
+	}
+	return err
+}
+
+// Default upscalers of the AUTOMATIC1111 based backends.
+const (
+	defaultUpscaler   = "LDSR"
+	defaultHRUpscaler = "R-ESRGAN 4x+"
+)
+
+// Returns the configured default upscaler, or the AUTOMATIC1111 default. ComfyUI has different
+// upscaler names, so the first upscaler of the backend is used there if it lacks the default.
+func (p *attrParser) defaultUpscaler(configured, a1111Default string) (string, error) {
+	name := configured
+	if name == "" {
+		name = a1111Default
+	}
+	if p.caps.Flavor != sdapi.FlavorComfyUI {
+		return name, nil
+	}
+	return p.backendDefault(upscalerList, name, i18n.NoDefaultUpscaler)
+}
+
+// Returns name if the backend has it, or the first value of the list otherwise.
+func (p *attrParser) backendDefault(l valueList, name string, noneKey i18n.Key) (string, error) {
+	values, err := p.list(l)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(values, name) {
+		return name, nil
+	}
+	for _, v := range values {
+		if v != "None" {
+			if name != "" {
+				fmt.Printf("  default %q is not available, using %q\n", name, v)
+			}
+			return v, nil
+		}
+	}
+	return "", i18n.Errorf(noneKey)
+}
@@ -0,0 +1,679 @@
+package logic
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+)
+
+var testDefaults = config.GenerationDefaults{
+	Model:      "v1-5-pruned-emaonly",
+	Sampler:    "Euler a",
+	Cnt:        2,
+	Batch:      1,
+	Steps:      30,
+	Width:      512,
+	Height:     512,
+	WidthSDXL:  1024,
+	HeightSDXL: 1024,
+	StepsSDXL:  25,
+	CFGScale:   7,
+}
+
+var testLimits = config.GenerationLimits{
+	MinWidth:    64,
+	MaxWidth:    2048,
+	MinHeight:   64,
+	MaxHeight:   2048,
+	MinSteps:    1,
+	MaxSteps:    100,
+	MinCnt:      1,
+	MaxCnt:      10,
+	MinBatch:    1,
+	MaxBatch:    4,
+	MinCFGScale: 1,
+	MaxCFGScale: 30,
+}
+
+func newTestAPI(t *testing.T) (*sdapi.SdAPIType, *sdapitest.Server) {
+	t.Helper()
+	srv := sdapitest.NewServer()
+	t.Cleanup(srv.Close)
+	return &sdapi.SdAPIType{SdHost: srv.URL}, srv
+}
+
+func parseRender(t *testing.T, api *sdapi.SdAPIType, s string) (reqparams.ReqParamsRender, int, error) {
+	t.Helper()
+	r := reqparams.ReqParamsRender{
+		ModelName:   testDefaults.Model,
+		SamplerName: testDefaults.Sampler,
+		CFGScale:    testDefaults.CFGScale,
+	}
+	firstCmdCharAt, err := ReqParamsParse(context.Background(), api, testDefaults, testLimits, NameAliases{}, s, &r)
+	return r, firstCmdCharAt, err
+}
+
+func TestReqParamsParseRender(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r, firstCmdCharAt, err := parseRender(t, api, "a cat -s 🌱42 -w 640 -h 384 -t 20 -o 3 -b 2 -c 5.5 -r \"DPM++ 2M Karras\" -png -lora add_detail:0.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstCmdCharAt != len("a cat ") {
+		t.Errorf("got first command char at %d", firstCmdCharAt)
+	}
+	expected := reqparams.ReqParamsRender{
+		Seed:        42,
+		Width:       640,
+		Height:      384,
+		Steps:       20,
+		NumOutputs:  3,
+		BatchSize:   2,
+		CFGScale:    5.5,
+		SamplerName: "DPM++ 2M Karras",
+		ModelName:   testDefaults.Model,
+		Output:      reqparams.ReqParamsOutput{Format: "png", AsDocument: true},
+		LoRAs:       []reqparams.ReqParamsLoRA{{Name: "add_detail", Weight: 0.6}},
+	}
+	if r.String() != expected.String() || r.PromptWithLoRAs() != expected.PromptWithLoRAs() {
+		t.Errorf("got %s, expected %s", r.String(), expected.String())
+	}
+}
+
+func TestReqParamsParseDefaults(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r, firstCmdCharAt, err := parseRender(t, api, "a cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstCmdCharAt != -1 {
+		t.Errorf("got first command char at %d, expected -1", firstCmdCharAt)
+	}
+	if r.Width != 512 || r.Height != 512 || r.Steps != 30 || r.NumOutputs != 2 || r.BatchSize != 1 {
+		t.Errorf("defaults not applied: %+v", r)
+	}
+
+	r, _, err = parseRender(t, api, "a cat -m sd_xl_base_1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Width != 1024 || r.Height != 1024 || r.Steps != 25 {
+		t.Errorf("SDXL defaults not applied: got %dx%d, %d steps", r.Width, r.Height, r.Steps)
+	}
+
+	// Given steps are kept for both model types.
+	for _, s := range []string{"a cat -t 40", "a cat -m sd_xl_base_1.0 -t 40"} {
+		if r, _, err = parseRender(t, api, s); err != nil {
+			t.Fatal(err)
+		}
+		if r.Steps != 40 {
+			t.Errorf("got %d steps for %q, expected 40", r.Steps, s)
+		}
+	}
+}
+
+func TestReqParamsParseAspectRatio(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	tests := []struct {
+		s              string
+		width, height  int
+		expectedErrStr string
+	}{
+		{s: "cat -square", width: 512, height: 512},
+		{s: "cat -ar 16:9", width: 680, height: 384},
+		{s: "cat -portrait", width: 416, height: 624},
+		{s: "cat -landscape -m sd_xl_base_1.0", width: 1280, height: 832},
+		{s: "cat -ar 2:1 -w 800", width: 800, height: 400},
+		{s: "cat -ar 1:2 -h 800", width: 400, height: 800},
+		{s: "cat -ar 1:2 -h 800 -w 100", expectedErrStr: "aspect ratio"},
+		{s: "cat -ar 16x9", expectedErrStr: "aspect ratio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			r, _, err := parseRender(t, api, tt.s)
+			if tt.expectedErrStr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErrStr) {
+					t.Fatalf("got error %v, expected %q", err, tt.expectedErrStr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Width != tt.width || r.Height != tt.height {
+				t.Errorf("got %dx%d, expected %dx%d", r.Width, r.Height, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestReqParamsParseDefaultsFitLimits(t *testing.T) {
+	api, _ := newTestAPI(t)
+	defaults := testDefaults
+	defaults.Batch = 4
+	limits := testLimits
+	limits.MaxWidth, limits.MaxBatch = 512, 2
+
+	for s, expected := range map[string]reqparams.ReqParamsRender{
+		"cat":          {Width: 512, Height: 512, BatchSize: 2},
+		"cat -ar 16:9": {Width: 512, Height: 288, BatchSize: 2},
+		"cat -ar 1:2":  {Width: 360, Height: 728, BatchSize: 2},
+	} {
+		r := reqparams.ReqParamsRender{ModelName: testDefaults.Model, CFGScale: testDefaults.CFGScale}
+		if _, err := ReqParamsParse(context.Background(), api, defaults, limits, NameAliases{}, s, &r); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if r.Width != expected.Width || r.Height != expected.Height || r.BatchSize != expected.BatchSize {
+			t.Errorf("got %dx%d batch %d for %q", r.Width, r.Height, r.BatchSize, s)
+		}
+	}
+}
+
+func TestReqParamsParseErrors(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	tests := []struct {
+		s              string
+		expectedErrStr string
+	}{
+		{"cat -w 4096", "width 4096 is out of the allowed range"},
+		{"cat -h 32", "height 32 is out of the allowed range"},
+		{"cat -t 150", "steps 150 is out of the allowed range"},
+		{"cat -o 50", "output count 50 is out of the allowed range"},
+		{"cat -b 16", "batch size 16 is out of the allowed range"},
+		{"cat -c 0.5", "CFG scale 0.5 is out of the allowed range"},
+		{"cat -w", "missing value"},
+		{"cat -w abc", "invalid width"},
+		{"cat -r Euler_a", "invalid sampler"},
+		{"cat -m nonexistent", "invalid model"},
+		{"cat -hru nonexistent", "invalid upscaler"},
+		{"cat -clipskip 20", "invalid clip skip"},
+		{"cat -lora add_detail:x", "invalid LoRA weight"},
+		{"cat -s 1 dog", "params need to be after the prompt"},
+		{"cat -refiner sd_xl_base_1.0:1.5", "invalid refiner switch point"},
+		{"cat -refiner nonexistent", "invalid refiner model"},
+
+		// Errors point at the offending token.
+		{"cat -s 1 -w 4096", "<code>-w 4096</code>: width 4096 is out of the allowed range 64-2048"},
+		{"cat -width=abc", "<code>-width=abc</code>: invalid width"},
+		{"cat -t 20 -w", "<code>-w</code>: missing value"},
+		{"cat -w=", "<code>-w=</code>: invalid width"},
+		{"cat -png=1", "<code>-png=1</code>: this parameter takes no value"},
+
+		// Invalid values get the closest valid values suggested.
+		{"cat -r euler_a", "invalid sampler, did you mean Euler a"},
+		{"cat -m sd_xl_bsae_1.0", "invalid model, did you mean sd_xl_base_1.0"},
+		{"cat -upscaler lancsoz", "invalid upscaler, did you mean Lanczos"},
+		{"cat -vae automatc", "invalid VAE, did you mean Automatic"},
+		{"cat -scheduler karas", "invalid scheduler, did you mean Karras"},
+
+		// Unknown and upscale-only attributes after the params.
+		{"cat -w 512 -widht 512", "unknown parameter -widht, did you mean width"},
+		{"cat -w 512 -crop", "-crop can only be used with /upscale"},
+		{"cat -w 512 -to=1024x1024", "-to can only be used with /upscale"},
+
+		{"cat -seed -1", "invalid seed"},
+		{"cat -seed 4294967296", "invalid seed"},
+		{"cat -ar 16", "invalid aspect ratio"},
+		{"cat -c abc", "invalid CFG scale"},
+		{"cat -c 31", "CFG scale 31.0 is out of the allowed range 1.0-30.0"},
+		{"cat -eta 1.5", "invalid eta"},
+		{"cat -clipskip 0", "invalid clip skip"},
+		{"cat -u -1", "invalid upscale ratio"},
+		{"cat -u x", "invalid upscale ratio"},
+		{"cat -hr -2", "invalid hr scale"},
+		{"cat -hrd 1.5", "invalid hr denoise strength"},
+		{"cat -hrt -1", "hr second pass steps -1 is out of the allowed range 0-100"},
+		{"cat -hrt 500", "hr second pass steps 500 is out of the allowed range 0-100"},
+
+		// The size after highres and upscaling is limited too.
+		{"cat -w 512 -h 512 -hr 16", "highres size 8192x8192 is too large, maximum is 2048x2048"},
+		{"cat -w 1024 -h 512 -hr 2.5", "highres size 2560x1280 is too large"},
+		{"cat -u 64", "upscaled size 32768x32768 is too large, maximum is 8192x8192"},
+		{"cat -w 2048 -h 1024 -u 4.5", "upscaled size 9216x4608 is too large"},
+		{"cat -lora :0.5", "missing LoRA name"},
+		{"cat -r eul", "<code>-r eul</code>: ambiguous value, it matches Euler a, Euler"},
+		{"cat -m=", "invalid model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			_, _, err := parseRender(t, api, tt.s)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErrStr) {
+				t.Errorf("got error %v, expected %q", err, tt.expectedErrStr)
+			}
+		})
+	}
+}
+
+func TestReqParamsParseAttributes(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	tests := []struct {
+		s     string
+		check func(r reqparams.ReqParamsRender) bool
+	}{
+		{"cat -seed 7", func(r reqparams.ReqParamsRender) bool { return r.Seed == 7 }},
+		{"cat -s=🌱7", func(r reqparams.ReqParamsRender) bool { return r.Seed == 7 }},
+		{"cat -width 576", func(r reqparams.ReqParamsRender) bool { return r.Width == 576 }},
+		{"cat -H 320", func(r reqparams.ReqParamsRender) bool { return r.Height == 320 }},
+		{"cat -steps=12", func(r reqparams.ReqParamsRender) bool { return r.Steps == 12 }},
+		{"cat -cnt 5", func(r reqparams.ReqParamsRender) bool { return r.NumOutputs == 5 }},
+		{"cat -batch 3", func(r reqparams.ReqParamsRender) bool { return r.BatchSize == 3 }},
+		{"cat -cfg=12.5", func(r reqparams.ReqParamsRender) bool { return r.CFGScale == 12.5 }},
+		{"cat -sampler=Euler", func(r reqparams.ReqParamsRender) bool { return r.SamplerName == "Euler" }},
+		{"cat -model sd_xl_base_1.0", func(r reqparams.ReqParamsRender) bool {
+			return r.ModelName == "sd_xl_base_1.0" && r.Width == 1024 && r.Height == 1024
+		}},
+		{"cat -lora add_detail -lora=pixel-art-xl:0.3", func(r reqparams.ReqParamsRender) bool {
+			return len(r.LoRAs) == 2 && r.LoRAs[0] == reqparams.ReqParamsLoRA{Name: "add_detail", Weight: 1} &&
+				r.LoRAs[1] == reqparams.ReqParamsLoRA{Name: "pixel-art-xl", Weight: 0.3}
+		}},
+		{"cat -vae None", func(r reqparams.ReqParamsRender) bool { return r.VAE == "None" }},
+		{"cat -vae=vae-ft-mse-840000-ema-pruned.safetensors", func(r reqparams.ReqParamsRender) bool {
+			return r.VAE == "vae-ft-mse-840000-ema-pruned.safetensors"
+		}},
+		{"cat -clipskip 2", func(r reqparams.ReqParamsRender) bool { return r.ClipSkip == 2 }},
+		{"cat -eta 0.25", func(r reqparams.ReqParamsRender) bool { return r.Eta == 0.25 }},
+		{"cat -scheduler=Exponential", func(r reqparams.ReqParamsRender) bool { return r.Scheduler == "Exponential" }},
+		{"cat -refiner sd_xl_base_1.0", func(r reqparams.ReqParamsRender) bool {
+			return r.Refiner == "sd_xl_base_1.0" && r.RefinerSwitchAt == 0.8
+		}},
+		{"cat -restorefaces -tiling -enhance", func(r reqparams.ReqParamsRender) bool {
+			return r.RestoreFaces && r.Tiling && r.Enhance
+		}},
+		{"cat -u 2 -upscaler=LDSR", func(r reqparams.ReqParamsRender) bool {
+			return r.Upscale.Scale == 2 && r.Upscale.Upscaler == "LDSR"
+		}},
+		{"cat -u 2 -hr 1.5 -hrd=0.4 -hru \"R-ESRGAN 4x+\" -hrt 8", func(r reqparams.ReqParamsRender) bool {
+			return r.HR == reqparams.ReqParamsRenderHR{Scale: 1.5, DenoisingStrength: 0.4, Upscaler: "R-ESRGAN 4x+", SecondPassSteps: 8} &&
+				r.Upscale.Scale == 0
+		}},
+		{"cat -landscape", func(r reqparams.ReqParamsRender) bool { return r.Width > r.Height }},
+		{"cat -ar=2:3", func(r reqparams.ReqParamsRender) bool { return r.Width < r.Height }},
+		{"cat -w=\"640\"", func(r reqparams.ReqParamsRender) bool { return r.Width == 640 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			r, firstCmdCharAt, err := parseRender(t, api, tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if firstCmdCharAt != len("cat ") {
+				t.Errorf("got first command char at %d", firstCmdCharAt)
+			}
+			if !tt.check(r) {
+				t.Errorf("got %+v", r)
+			}
+		})
+	}
+}
+
+func TestReqParamsParseNames(t *testing.T) {
+	api, _ := newTestAPI(t)
+	aliases := NameAliases{
+		Models:    map[string]string{"base": "sd_xl_base_1.0"},
+		Samplers:  map[string]string{"dpm": "DPM++ 2M Karras"},
+		Upscalers: map[string]string{"esr": "R-ESRGAN 4x+"},
+	}
+
+	tests := []struct {
+		s     string
+		check func(r reqparams.ReqParamsRender) bool
+	}{
+		{"cat -m sd_xl", func(r reqparams.ReqParamsRender) bool { return r.ModelName == "sd_xl_base_1.0" }},
+		{"cat -m V1-5", func(r reqparams.ReqParamsRender) bool { return r.ModelName == "v1-5-pruned-emaonly" }},
+		{"cat -m XL", func(r reqparams.ReqParamsRender) bool { return r.ModelName == "sd_xl_base_1.0" }},
+		{"cat -m " + sdapitest.ModelHash("sd_xl_base_1.0")[:10], func(r reqparams.ReqParamsRender) bool { return r.ModelName == "sd_xl_base_1.0" }},
+		{"cat -m " + strings.ToUpper(sdapitest.ModelHash("sd_xl_base_1.0")), func(r reqparams.ReqParamsRender) bool { return r.ModelName == "sd_xl_base_1.0" }},
+		{"cat -m BASE", func(r reqparams.ReqParamsRender) bool { return r.ModelName == "sd_xl_base_1.0" }},
+		{"cat -refiner xl:0.7", func(r reqparams.ReqParamsRender) bool {
+			return r.Refiner == "sd_xl_base_1.0" && r.RefinerSwitchAt == 0.7
+		}},
+		{"cat -r euler", func(r reqparams.ReqParamsRender) bool { return r.SamplerName == "Euler" }},
+		{"cat -r dpm", func(r reqparams.ReqParamsRender) bool { return r.SamplerName == "DPM++ 2M Karras" }},
+		{"cat -r karras", func(r reqparams.ReqParamsRender) bool { return r.SamplerName == "DPM++ 2M Karras" }},
+		{"cat -upscaler r-esr", func(r reqparams.ReqParamsRender) bool { return r.Upscale.Upscaler == "R-ESRGAN 4x+" }},
+		{"cat -upscaler esr", func(r reqparams.ReqParamsRender) bool { return r.Upscale.Upscaler == "R-ESRGAN 4x+" }},
+		{"cat -hr 2 -hru ldsr", func(r reqparams.ReqParamsRender) bool { return r.HR.Upscaler == "LDSR" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			r := reqparams.ReqParamsRender{ModelName: testDefaults.Model, SamplerName: testDefaults.Sampler, CFGScale: testDefaults.CFGScale}
+			if _, err := ReqParamsParse(context.Background(), api, testDefaults, testLimits, aliases, tt.s, &r); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(r) {
+				t.Errorf("got %+v", r)
+			}
+		})
+	}
+}
+
+// Unknown attributes and attributes of upscale requests are part of the prompt if they're
+// before the params.
+func TestReqParamsParseUnknownInPrompt(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	for s, expectedFirstCmdCharAt := range map[string]int{
+		"a -cat -crop":               -1,
+		"a -cat -crop -w 512":        len("a -cat -crop "),
+		"-90 degrees -foo=bar -t 20": len("-90 degrees -foo=bar "),
+	} {
+		_, firstCmdCharAt, err := parseRender(t, api, s)
+		if err != nil {
+			t.Fatalf("got error %v for %q", err, s)
+		}
+		if firstCmdCharAt != expectedFirstCmdCharAt {
+			t.Errorf("got first command char at %d for %q, expected %d", firstCmdCharAt, s, expectedFirstCmdCharAt)
+		}
+	}
+}
+
+func TestReqParamsParseDefaultUpscalers(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	for s, expected := range map[string]reqparams.ReqParamsRender{
+		"cat -u 2":  {Upscale: reqparams.ReqParamsUpscale{Scale: 2, Upscaler: "LDSR"}},
+		"cat -hr 2": {HR: reqparams.ReqParamsRenderHR{Scale: 2, Upscaler: "R-ESRGAN 4x+"}},
+		"cat":       {},
+	} {
+		r, _, err := parseRender(t, api, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Upscale.Upscaler != expected.Upscale.Upscaler || r.HR.Upscaler != expected.HR.Upscaler {
+			t.Errorf("got upscaler %q and hr upscaler %q for %q", r.Upscale.Upscaler, r.HR.Upscaler, s)
+		}
+	}
+
+	defaults := testDefaults
+	defaults.Upscaler, defaults.HRUpscaler = "Lanczos", "Lanczos"
+	r := reqparams.ReqParamsRender{CFGScale: testDefaults.CFGScale}
+	if _, err := ReqParamsParse(context.Background(), api, defaults, testLimits, NameAliases{}, "cat -hr 2", &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.HR.Upscaler != "Lanczos" {
+		t.Errorf("got hr upscaler %q", r.HR.Upscaler)
+	}
+	u := reqparams.ReqParamsUpscale{Scale: 2}
+	if _, err := ReqParamsParse(context.Background(), api, defaults, testLimits, NameAliases{}, "/upscale", &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Upscaler != "Lanczos" {
+		t.Errorf("got upscaler %q", u.Upscaler)
+	}
+}
+
+// comfyFlavored reports the ComfyUI flavor for the fake AUTOMATIC1111 API.
+type comfyFlavored struct {
+	*sdapi.SdAPIType
+}
+
+func (comfyFlavored) Capabilities() sdapi.Capabilities {
+	caps := sdapi.DefaultCapabilities()
+	caps.Flavor = sdapi.FlavorComfyUI
+	return caps
+}
+
+func TestReqParamsParseComfyUIDefaults(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Samplers = []string{"euler", "euler_ancestral"}
+	srv.Upscalers = []string{"4x-UltraSharp.pth"}
+
+	parse := func(s string) (reqparams.ReqParamsRender, error) {
+		r := reqparams.ReqParamsRender{
+			ModelName:   testDefaults.Model,
+			SamplerName: testDefaults.Sampler,
+			CFGScale:    testDefaults.CFGScale,
+		}
+		_, err := ReqParamsParse(context.Background(), comfyFlavored{api}, testDefaults, testLimits, NameAliases{}, s, &r)
+		return r, err
+	}
+
+	r, err := parse("cat -u 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.SamplerName != "euler" || r.Upscale.Upscaler != "4x-UltraSharp.pth" {
+		t.Errorf("got sampler %q and upscaler %q", r.SamplerName, r.Upscale.Upscaler)
+	}
+
+	if r, err = parse("cat -r euler_ancestral"); err != nil {
+		t.Fatal(err)
+	}
+	if r.SamplerName != "euler_ancestral" {
+		t.Errorf("got sampler %q", r.SamplerName)
+	}
+
+	srv.Upscalers = []string{"None"}
+	if _, err := parse("cat -u 2"); err == nil || err.Error() != "no default upscaler, the backend has no upscalers" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestReqParamsParseFetchesListsOnce(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	_, _, err := parseRender(t, api, "cat -m sd_xl_base_1.0 -refiner sd_xl_base_1.0 -u 2 -upscaler Lanczos -hr 2 -hru LDSR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/sdapi/v1/sd-models", "/sdapi/v1/upscalers"} {
+		if n := len(srv.Requests(path)); n != 1 {
+			t.Errorf("got %d requests to %s, expected 1", n, path)
+		}
+	}
+	if n := len(srv.Requests("/sdapi/v1/samplers")); n != 0 {
+		t.Errorf("got %d requests to samplers, expected none", n)
+	}
+
+	srv.FailNext("/sdapi/v1/samplers", 1, 500, `{"error":"boom"}`)
+	if _, _, err = parseRender(t, api, "cat -r Euler"); err == nil || !strings.Contains(err.Error(), "<code>-r Euler</code>: error getting samplers") {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, a := range attributes {
+		for _, name := range a.names {
+			if seen[name] {
+				t.Errorf("duplicate attribute name %s", name)
+			}
+			seen[name] = true
+		}
+		if a.parse == nil {
+			t.Errorf("attribute %s has no parser", a.names[0])
+		}
+		if a.flag != (a.example == "") {
+			t.Errorf("attribute %s should have an example only if it's not a flag", a.names[0])
+		}
+		if a.scope&attrAll == 0 {
+			t.Errorf("attribute %s has no scope", a.names[0])
+		}
+	}
+}
+
+func TestReqParamsParseCapabilities(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	r, _, err := parseRender(t, api, "cat -refiner sd_xl_base_1.0:0.7 -scheduler Karras")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Refiner != "sd_xl_base_1.0" || r.RefinerSwitchAt != 0.7 || r.Scheduler != "Karras" {
+		t.Errorf("got %+v", r)
+	}
+
+	srv.Schedulers = nil
+	srv.Scripts = nil
+	delete(srv.Options, "face_restoration_model")
+	if _, err = api.DetectCapabilities(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for s, expectedErrStr := range map[string]string{
+		"cat -scheduler Karras":       "not supported by the AUTOMATIC1111 backend: -scheduler",
+		"cat -refiner sd_xl_base_1.0": "not supported by the AUTOMATIC1111 backend: -refiner",
+		"cat -restorefaces":           "not supported by the AUTOMATIC1111 backend: -restorefaces",
+	} {
+		if _, _, err = parseRender(t, api, s); err == nil || !strings.Contains(err.Error(), expectedErrStr) {
+			t.Errorf("got error %v for %q, expected %q", err, s, expectedErrStr)
+		}
+	}
+}
+
+func TestReqParamsParseUpscale(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	r := reqparams.ReqParamsUpscale{Scale: 2, Upscaler: "LDSR"}
+	_, err := ReqParamsParse(context.Background(), api, testDefaults, testLimits, NameAliases{},
+		"/upscale -u 4 -upscaler Lanczos -upscaler2 \"R-ESRGAN 4x+\" -gfpgan 0.8 -to 2048x1024 -crop -png", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := reqparams.ReqParamsUpscale{
+		Scale:               4,
+		Upscaler:            "Lanczos",
+		Upscaler2:           "R-ESRGAN 4x+",
+		Upscaler2Visibility: 0.5,
+		GFPGANVisibility:    0.8,
+		TargetWidth:         2048,
+		TargetHeight:        1024,
+		Crop:                true,
+		Output:              reqparams.ReqParamsOutput{Format: "png", AsDocument: true},
+		MaxWidth:            8192,
+		MaxHeight:           8192,
+	}
+	if r != expected {
+		t.Errorf("got %+v, expected %+v", r, expected)
+	}
+
+	r = reqparams.ReqParamsUpscale{Scale: 2, Upscaler: "LDSR"}
+	_, err = ReqParamsParse(context.Background(), api, testDefaults, testLimits, NameAliases{},
+		"/upscale -u=3 -upscaler2=Lanczos -u2v 0.3 -codeformer 0.6 -cfw=0.4", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = reqparams.ReqParamsUpscale{
+		Scale:                3,
+		Upscaler:             "LDSR",
+		Upscaler2:            "Lanczos",
+		Upscaler2Visibility:  0.3,
+		CodeFormerVisibility: 0.6,
+		CodeFormerWeight:     0.4,
+		MaxWidth:             8192,
+		MaxHeight:            8192,
+	}
+	if r != expected {
+		t.Errorf("got %+v, expected %+v", r, expected)
+	}
+
+	for s, expectedErrStr := range map[string]string{
+		"/upscale -to 100":            "<code>-to 100</code>: invalid target size",
+		"/upscale -to 9000x9000":      "target size is too large",
+		"/upscale -gfpgan 2":          "<code>-gfpgan 2</code>: invalid GFPGAN visibility: value should be between 0 and 1",
+		"/upscale -cfw x":             "invalid CodeFormer weight: value should be a number",
+		"/upscale -upscaler2 ldrs":    "invalid upscaler, did you mean LDSR",
+		"/upscale -crop -s 1":         "-s can only be used with /sd",
+		"/upscale -crop -crop=true":   "this parameter takes no value",
+		"/upscale -u2v 0.5 -hr=2 -to": "-hr can only be used with /sd",
+	} {
+		r = reqparams.ReqParamsUpscale{Scale: 2, Upscaler: "LDSR"}
+		if _, err = ReqParamsParse(context.Background(), api, testDefaults, testLimits, NameAliases{}, s, &r); err == nil || !strings.Contains(err.Error(), expectedErrStr) {
+			t.Errorf("got error %v for %q, expected %q", err, s, expectedErrStr)
+		}
+	}
+
+	// Render-only attributes are ignored for upscale requests.
+	r = reqparams.ReqParamsUpscale{Scale: 2, Upscaler: "LDSR"}
+	firstCmdCharAt, err := ReqParamsParse(context.Background(), api, testDefaults, testLimits, NameAliases{}, "/upscale -s 1", &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstCmdCharAt != -1 {
+		t.Errorf("got first command char at %d, expected -1", firstCmdCharAt)
+	}
+}
+
+func TestReqParamsParseOutput(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	for s, expected := range map[string]reqparams.ReqParamsOutput{
+		"cat":                         {},
+		"cat -png":                    {Format: "png", AsDocument: true},
+		"cat -png -photo":             {Format: "png"},
+		"cat -format JPEG -q 95 -doc": {Format: "jpg", Quality: 95, AsDocument: true},
+		"cat -format webp":            {Format: "webp"},
+	} {
+		r, _, err := parseRender(t, api, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Output != expected {
+			t.Errorf("got %+v for %q, expected %+v", r.Output, s, expected)
+		}
+	}
+
+	for s, expectedErrStr := range map[string]string{
+		"cat -format gif":        "invalid format",
+		"cat -quality 0":         "invalid quality",
+		"cat -q 101":             "invalid quality",
+		"cat -format webp -q 90": "quality can't be set for WebP images",
+	} {
+		if _, _, err := parseRender(t, api, s); err == nil || !strings.Contains(err.Error(), expectedErrStr) {
+			t.Errorf("got error %v for %q, expected %q", err, s, expectedErrStr)
+		}
+	}
+}
+
+func TestValidateExtraNetworks(t *testing.T) {
+	api, srv := newTestAPI(t)
+	catalog := sdapi.NewCatalog(api, time.Hour)
+
+	tests := []struct {
+		prompt         string
+		loras          []reqparams.ReqParamsLoRA
+		expectedErrStr string
+		expectedHint   string
+	}{
+		{prompt: "cat <lora:add_detail:0.5> easynegative"},
+		{prompt: "cat", loras: []reqparams.ReqParamsLoRA{{Name: "pixel-art-xl", Weight: 1}}},
+		{prompt: "cat <lora:add_detial:0.5>", expectedErrStr: "unknown LoRA add_detial, did you mean add_detail?"},
+		{prompt: "cat", loras: []reqparams.ReqParamsLoRA{{Name: "pixelart", Weight: 1}}, expectedErrStr: "unknown LoRA pixelart"},
+		// Words similar to embedding names can be ordinary words, so they're only hinted.
+		{prompt: "cat easynegativ", expectedHint: "💡 easynegativ is not an embedding, did you mean easynegative?"},
+		{prompt: "cat with badhands"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prompt, func(t *testing.T) {
+			hint, err := validateExtraNetworks(context.Background(), catalog, &reqparams.ReqParamsRender{Prompt: tt.prompt, LoRAs: tt.loras})
+			if tt.expectedErrStr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.expectedErrStr) {
+				t.Errorf("got error %v, expected %q", err, tt.expectedErrStr)
+			}
+			var gotHint string
+			if hint.Key != i18n.None {
+				gotHint = i18n.English.T(hint.Key, hint.Args...)
+			}
+			if gotHint != tt.expectedHint {
+				t.Errorf("got hint %q, expected %q", gotHint, tt.expectedHint)
+			}
+		})
+	}
+	if n := len(srv.Requests("/sdapi/v1/embeddings")); n != 1 {
+		t.Errorf("got %d embedding requests, expected 1", n)
+	}
+}
@@ -0,0 +1,117 @@
+package preprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultEnhanceSystemPrompt = "You are an expert at writing prompts for Stable Diffusion image generation. " +
+	"Expand the user's short idea into a single detailed prompt: describe the subject, composition, " +
+	"lighting, style and quality in comma separated English tags and short phrases. " +
+	"Keep any <lora:...> tags and (word:1.2) weights unchanged. " +
+	"Reply with the prompt only, without explanations, quotes or line breaks."
+
+const DefaultEnhanceTimeout = time.Minute
+
+// Enhancer expands short prompts using an OpenAI compatible chat completion API, like
+// llama.cpp server or Ollama.
+type Enhancer struct {
+	// Base URL of the API, like http://localhost:11434/v1
+	Host   string
+	APIKey string
+	Model  string
+	// DefaultEnhanceSystemPrompt is used if empty.
+	SystemPrompt string
+	// DefaultEnhanceTimeout is used if zero.
+	Timeout time.Duration
+}
+
+func (e *Enhancer) Process(ctx context.Context, prompt string) (string, error) {
+	systemPrompt := e.SystemPrompt
+	if systemPrompt == "" {
+		systemPrompt = DefaultEnhanceSystemPrompt
+	}
+	type chatMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	postData, err := json.Marshal(struct {
+		Model    string        `json:"model"`
+		Messages []chatMessage `json:"messages"`
+		Stream   bool          `json:"stream"`
+	}{
+		Model: e.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: prompt},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	endpoint, err := url.JoinPath(e.Host, "/chat/completions")
+	if err != nil {
+		return "", err
+	}
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = DefaultEnhanceTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(postData))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		request.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("enhance api request error: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("enhance api response parse error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if res.Error.Message != "" {
+			return "", fmt.Errorf("enhance api status code: %d: %s", resp.StatusCode, res.Error.Message)
+		}
+		return "", fmt.Errorf("enhance api status code: %d", resp.StatusCode)
+	}
+	if len(res.Choices) == 0 {
+		return "", fmt.Errorf("enhance api returned no choices")
+	}
+
+	// Models tend to quote the answer and split it to lines despite the instructions.
+	enhanced := strings.Join(strings.Fields(res.Choices[0].Message.Content), " ")
+	enhanced = strings.Trim(enhanced, "\"'`")
+	if enhanced == "" {
+		return "", fmt.Errorf("enhance api returned an empty prompt")
+	}
+	return enhanced, nil
+}
@@ -0,0 +1,49 @@
+package preprocess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnhancerProcess(t *testing.T) {
+	var req struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"message": "invalid api key"}}`))
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_, _ = w.Write([]byte(`{"choices": [{"message": {"role": "assistant",
+			"content": "\"a fluffy cat,\n  studio lighting, highly detailed\"\n"}}]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	e := &Enhancer{Host: srv.URL + "/v1", APIKey: "key", Model: "llama3"}
+	res, err := e.Process(context.Background(), "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "a fluffy cat, studio lighting, highly detailed" {
+		t.Errorf("got %q", res)
+	}
+	if req.Model != "llama3" || len(req.Messages) != 2 || req.Messages[0].Content != DefaultEnhanceSystemPrompt ||
+		req.Messages[1].Content != "cat" {
+		t.Errorf("got request %+v", req)
+	}
+
+	e.APIKey = "invalid"
+	if _, err = e.Process(context.Background(), "cat"); err == nil || !strings.Contains(err.Error(), "invalid api key") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+package preprocess
+
+import "context"
+
+// PromptPreprocessor changes render prompts before the request gets queued.
+type PromptPreprocessor interface {
+	// Returns the processed prompt, or the same prompt if nothing had to be changed.
+	Process(ctx context.Context, prompt string) (string, error)
+}
@@ -0,0 +1,137 @@
+package preprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const translateTimeout = 30 * time.Second
+
+// Parts of the prompt which are not sent for translation: extra networks like <lora:name:0.6>,
+// weights like (word:1.2), emphasis brackets, separators and words with Latin letters, which are
+// usually embedding names or already English.
+var untranslatedRegex = regexp.MustCompile(`<[^<>]*>|:\s*-?\d*\.?\d+|[()\[\]{}|,]|[\p{Latin}\d_\-]*\p{Latin}[\p{Latin}\d_\-]*`)
+
+// Translator translates prompts written in non-Latin scripts using a LibreTranslate compatible API.
+type Translator struct {
+	Host   string
+	APIKey string
+	// Target language code, "en" if empty.
+	Target string
+}
+
+func hasNonLatinLetters(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits the prompt to parts which should be translated (odd indexes) and the parts which
+// should be kept as is (even indexes).
+func splitPrompt(prompt string) (parts []string) {
+	pos := 0
+	for _, loc := range untranslatedRegex.FindAllStringIndex(prompt, -1) {
+		parts = append(parts, prompt[pos:loc[0]], prompt[loc[0]:loc[1]])
+		pos = loc[1]
+	}
+	return append(parts, prompt[pos:])
+}
+
+func (t *Translator) Process(ctx context.Context, prompt string) (string, error) {
+	if !hasNonLatinLetters(prompt) {
+		return prompt, nil
+	}
+
+	parts := splitPrompt(prompt)
+	var texts []string
+	var textIdxs []int
+	for i := 0; i < len(parts); i += 2 {
+		if hasNonLatinLetters(parts[i]) {
+			texts = append(texts, strings.TrimSpace(parts[i]))
+			textIdxs = append(textIdxs, i)
+		}
+	}
+
+	translated, err := t.translate(ctx, texts)
+	if err != nil {
+		return "", err
+	}
+	if len(translated) != len(texts) {
+		return "", fmt.Errorf("translate api returned %d texts instead of %d", len(translated), len(texts))
+	}
+
+	for i, idx := range textIdxs {
+		// Keeping the whitespace around the text, so it doesn't get glued to the weights and brackets.
+		p := parts[idx]
+		leading := p[:len(p)-len(strings.TrimLeftFunc(p, unicode.IsSpace))]
+		trailing := p[len(strings.TrimRightFunc(p, unicode.IsSpace)):]
+		parts[idx] = leading + translated[i] + trailing
+	}
+	return strings.Join(parts, ""), nil
+}
+
+func (t *Translator) translate(ctx context.Context, texts []string) ([]string, error) {
+	target := t.Target
+	if target == "" {
+		target = "en"
+	}
+	postData, err := json.Marshal(struct {
+		Q      []string `json:"q"`
+		Source string   `json:"source"`
+		Target string   `json:"target"`
+		Format string   `json:"format"`
+		APIKey string   `json:"api_key,omitempty"`
+	}{texts, "auto", target, "text", t.APIKey})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := url.JoinPath(t.Host, "/translate")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, translateTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(postData))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("translate api request error: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		TranslatedText []string `json:"translatedText"`
+		Error          string   `json:"error"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("translate api response parse error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if res.Error != "" {
+			return nil, fmt.Errorf("translate api status code: %d: %s", resp.StatusCode, res.Error)
+		}
+		return nil, fmt.Errorf("translate api status code: %d", resp.StatusCode)
+	}
+	return res.TranslatedText, nil
+}
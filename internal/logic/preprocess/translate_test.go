@@ -0,0 +1,86 @@
+package preprocess
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testDictionary = map[string]string{
+	"кот":           "cat",
+	"рыжий кот":     "red cat",
+	"в шляпе":       "in a hat",
+	"размытый фон":  "blurred background",
+	"старый дом":    "old house",
+	"ночь":          "night",
+	"очень большой": "very big",
+}
+
+func newTestTranslator(t *testing.T) (*Translator, *[][]string) {
+	t.Helper()
+	var requests [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Q      []string `json:"q"`
+			Target string   `json:"target"`
+			APIKey string   `json:"api_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Target != "en" {
+			http.Error(w, `{"error": "invalid request"}`, http.StatusBadRequest)
+			return
+		}
+		if req.APIKey != "key" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"error": "Invalid API key"}`))
+			return
+		}
+		requests = append(requests, req.Q)
+		var res []string
+		for _, q := range req.Q {
+			res = append(res, testDictionary[q])
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"translatedText": res})
+	}))
+	t.Cleanup(srv.Close)
+	return &Translator{Host: srv.URL, APIKey: "key"}, &requests
+}
+
+func TestTranslatorProcess(t *testing.T) {
+	tr, requests := newTestTranslator(t)
+
+	for prompt, expected := range map[string]string{
+		"рыжий кот, в шляпе":                    "red cat, in a hat",
+		"(рыжий кот:1.2) <lora:add_detail:0.6>": "(red cat:1.2) <lora:add_detail:0.6>",
+		"[старый дом], ночь, easynegative":      "[old house], night, easynegative",
+		"кот BREAK очень большой":               "cat BREAK very big",
+		"((размытый фон)), <hypernet:anime:1>":  "((blurred background)), <hypernet:anime:1>",
+	} {
+		res, err := tr.Process(context.Background(), prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expected {
+			t.Errorf("got %q for %q, expected %q", res, prompt, expected)
+		}
+	}
+
+	// Latin prompts are not sent to the api.
+	*requests = nil
+	res, err := tr.Process(context.Background(), "red cat (in a hat:1.2)")
+	if err != nil || res != "red cat (in a hat:1.2)" || len(*requests) != 0 {
+		t.Errorf("got %q, %v and %d requests", res, err, len(*requests))
+	}
+}
+
+func TestTranslatorProcessError(t *testing.T) {
+	tr, _ := newTestTranslator(t)
+	tr.APIKey = "invalid"
+
+	_, err := tr.Process(context.Background(), "кот")
+	if err == nil || !strings.Contains(err.Error(), "Invalid API key") {
+		t.Errorf("got error %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/config"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"golang.org/x/exp/slices"
+)
+
+// Returns the text without the leading @botname mention, and whether the bot was mentioned.
+func (c *CmdHandler) cutMention(text string) (string, bool) {
+	mention := "@" + c.bot.Username()
+	if mention == "@" || len(text) < len(mention) || !strings.EqualFold(text[:len(mention)], mention) {
+		return text, false
+	}
+	rest := text[len(mention):]
+	// Not matching mentions of other bots with names starting with ours.
+	if r, _ := utf8.DecodeRuneInString(rest); rest != "" && !unicode.IsSpace(r) && r != ',' && r != ':' {
+		return text, false
+	}
+	return strings.TrimSpace(strings.TrimLeft(rest, ",:")), true
+}
+
+// Checks if the message triggers a render by mentioning the bot, or by replying to an image
+// rendered by the bot. Returns the text without the mention, and the params of the replied
+// image for replies.
+func (c *CmdHandler) getTrigger(msg *models.Message) (text string, source *reqparams.ReqParamsRender, ok bool) {
+	if c.getChatSettings(msg.Chat.ID).MentionsDisabled {
+		return "", nil, false
+	}
+	text, mentioned := c.cutMention(strings.TrimSpace(msg.Text))
+	if msg.ReplyToMessage != nil {
+		if params, found := c.reqQueue.RenderParams(msg.Chat.ID, msg.ReplyToMessage.ID); found {
+			return text, &params, true
+		}
+	}
+	return text, nil, mentioned
+}
+
+// Defaults of derived renders are the params of the source image, so only the attributes
+// given in the reply are changed.
+func derivedDefaults(defaults config.GenerationDefaults, source reqparams.ReqParamsRender) config.GenerationDefaults {
+	defaults.Cnt = 1
+	defaults.Batch = source.BatchSize
+	defaults.Width, defaults.WidthSDXL = source.Width, source.Width
+	defaults.Height, defaults.HeightSDXL = source.Height, source.Height
+	defaults.Steps, defaults.StepsSDXL = source.Steps, source.Steps
+	defaults.SendAs = "photo"
+	if source.Output.AsDocument {
+		defaults.SendAs = "document"
+	}
+	return defaults
+}
+
+// Returns the params of a render derived from the replied image. The seed and the other
+// params of the image are kept if they are not given in the reply, and the prompt is kept if
+// the reply has only attributes.
+func derivedReqParams(source reqparams.ReqParamsRender, text string) reqparams.ReqParamsRender {
+	reqParams := source
+	reqParams.OriginalPromptText = text
+	reqParams.ProcessedPromptText = ""
+	reqParams.Prompt = ""
+	reqParams.NegativePrompt = source.UserNegativePrompt
+	reqParams.Enhance = false
+	// LoRAs given in the reply are appended, the source's slice should not be changed.
+	reqParams.LoRAs = slices.Clone(source.LoRAs)
+	return reqParams
+}
+
+// Returns the text of the render request in the message, and the params of the replied image
+// for derived renders. Returns false if the message doesn't request a render.
+func (c *CmdHandler) renderRequest(msg *models.Message) (text string, source *reqparams.ReqParamsRender, ok bool) {
+	if isRenderCmd(msg.Text) {
+		return strings.TrimSpace(removeBotName(msg.Text)), nil, true
+	}
+	if text, source, ok = c.getTrigger(msg); ok {
+		return text, source, true
+	}
+	if msg.Chat.ID >= 0 || c.getChatSettings(msg.Chat.ID).PlainMessages {
+		return strings.TrimSpace(removeBotName(msg.Text)), nil, true
+	}
+	return "", nil, false
+}
+
+func isRenderCmd(text string) bool {
+	return strings.HasPrefix(text, "/sd") || strings.HasPrefix(text, "/txt2img")
+}
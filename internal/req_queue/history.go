@@ -0,0 +1,67 @@
+package reqqueue
+
+import (
+	"sync"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+// Number of uploaded images for which the render params are kept.
+const renderHistorySize = 1000
+
+type renderHistoryKey struct {
+	chatID int64
+	msgID  int
+}
+
+// renderHistory keeps the params of the recently uploaded images, so replies to them can
+// start derived renders. The oldest entries are dropped when it's full.
+type renderHistory struct {
+	mutex  sync.Mutex
+	keys   []renderHistoryKey
+	params map[renderHistoryKey]reqparams.ReqParamsRender
+}
+
+func (h *renderHistory) add(chatID int64, msgID int, params reqparams.ReqParamsRender) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.params == nil {
+		h.params = make(map[renderHistoryKey]reqparams.ReqParamsRender)
+	}
+	key := renderHistoryKey{chatID: chatID, msgID: msgID}
+	if _, ok := h.params[key]; !ok {
+		h.keys = append(h.keys, key)
+	}
+	h.params[key] = params
+	if len(h.keys) > renderHistorySize {
+		delete(h.params, h.keys[0])
+		h.keys = h.keys[1:]
+	}
+}
+
+func (h *renderHistory) get(chatID int64, msgID int) (reqparams.ReqParamsRender, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	params, ok := h.params[renderHistoryKey{chatID: chatID, msgID: msgID}]
+	return params, ok
+}
+
+// Returns the params of the image in the given message, if it was rendered recently.
+func (q *ReqQueue) RenderParams(chatID int64, msgID int) (reqparams.ReqParamsRender, bool) {
+	return q.history.get(chatID, msgID)
+}
+
+// Stores the params of each uploaded image with the seed of the image.
+func (q *ReqQueue) addToHistory(reqParams reqparams.ReqParamsRender, seeds []uint32, msgs []*models.Message) {
+	chatID := q.currentEntry.entry.Message.Chat.ID
+	for i := range min(len(seeds), len(msgs)) {
+		if msgs[i] == nil {
+			continue
+		}
+		params := reqParams
+		params.Seed = seeds[i]
+		params.NumOutputs = 1
+		q.history.add(chatID, msgs[i].ID, params)
+	}
+}
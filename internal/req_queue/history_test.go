@@ -0,0 +1,43 @@
+package reqqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+func TestReqQueueRenderHistory(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+
+	req := newTestRenderReq(1)
+	params := req.Params.(reqparams.ReqParamsRender)
+	params.Seed = 5
+	req.Params = params
+	q.Add(req)
+
+	waitFor(t, "upload", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 1 })
+	firstMsgID := tgBot.Calls("SendMediaGroup")[0].MessageID
+	for i := range 2 {
+		p, ok := q.RenderParams(1, firstMsgID+i)
+		if !ok || p.Seed != uint32(5+i) || p.NumOutputs != 1 || p.Prompt != "cat" {
+			t.Errorf("got params %+v for image %d", p, i)
+		}
+	}
+	if _, ok := q.RenderParams(2, firstMsgID); ok {
+		t.Error("got params for another chat")
+	}
+}
+
+func TestRenderHistoryLimit(t *testing.T) {
+	var h renderHistory
+	for i := range renderHistorySize + 1 {
+		h.add(1, i, reqparams.ReqParamsRender{Seed: uint32(i)})
+	}
+	if _, ok := h.get(1, 0); ok {
+		t.Error("oldest entry is not dropped")
+	}
+	if p, ok := h.get(1, renderHistorySize); !ok || p.Seed != renderHistorySize {
+		t.Errorf("got %+v for the newest entry", p)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
 	"image/jpeg"
 	"image/png"
 	"math/rand"
@@ -14,12 +15,17 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/consts"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
 	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram"
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/utils"
+	_ "golang.org/x/image/webp" // Registering the WebP decoder for the upscale size check.
 )
 
 type ReqType int
@@ -34,9 +40,15 @@ type ReqQueueEntry struct {
 	Params reqparams.ReqParams
 	TaskID uint64
 
-	bot          *telegram.SDBot
+	bot          telegram.BotAPI
 	ReplyMessage *models.Message
 	Message      *models.Message
+	// Language of the replies.
+	Lang i18n.Lang
+	// Called with the sent messages after each upload of rendered images.
+	OnUploaded func(msgs []*models.Message)
+	// Called after the entry is processed, set with WhenFinished.
+	onFinished func()
 }
 
 func (e *ReqQueueEntry) checkWaitError(err error) time.Duration {
@@ -53,6 +65,12 @@ func (e *ReqQueueEntry) checkWaitError(err error) time.Duration {
 	return time.Duration(retryAfter) * time.Second
 }
 
+func (e *ReqQueueEntry) uploaded(msgs []*models.Message) {
+	if e.OnUploaded != nil {
+		e.OnUploaded(msgs)
+	}
+}
+
 func (e *ReqQueueEntry) sendReply(ctx context.Context, text string) {
 	if e.ReplyMessage == nil {
 		e.ReplyMessage = e.bot.SendReplyToMessage(ctx, e.Message, text)
@@ -69,7 +87,11 @@ func (e *ReqQueueEntry) sendReply(ctx context.Context, text string) {
 	}
 }
 
-func (e *ReqQueueEntry) convertImagesFromPNGToJPG(imgs [][]byte) error {
+// Converts the PNG images returned by the API to the output format.
+func (e *ReqQueueEntry) convertImages(imgs [][]byte, output reqparams.ReqParamsOutput) error {
+	if output.FileExt() == "png" {
+		return nil
+	}
 	for i := range imgs {
 		p, err := png.Decode(bytes.NewReader(imgs[i]))
 		if err != nil {
@@ -77,83 +99,25 @@ func (e *ReqQueueEntry) convertImagesFromPNGToJPG(imgs [][]byte) error {
 			return fmt.Errorf("png decode error: %w", err)
 		}
 		buf := new(bytes.Buffer)
-		err = jpeg.Encode(buf, p, &jpeg.Options{Quality: 80})
+		switch output.FileExt() {
+		case "webp":
+			err = nativewebp.Encode(buf, p, nil)
+		default:
+			quality := output.Quality
+			if quality == 0 {
+				quality = jpeg.DefaultQuality
+			}
+			err = jpeg.Encode(buf, p, &jpeg.Options{Quality: quality})
+		}
 		if err != nil {
-			fmt.Println("  jpg decode error:", err)
-			return fmt.Errorf("jpg decode error: %w", err)
+			fmt.Println("  ", output.FileExt(), "encode error:", err)
+			return fmt.Errorf("%s encode error: %w", output.FileExt(), err)
 		}
 		imgs[i] = buf.Bytes()
 	}
 	return nil
 }
 
-// If filename is empty then a filename will be automatically generated.
-func (e *ReqQueueEntry) uploadImages(
-	ctx context.Context,
-	firstImageID uint32,
-	description string,
-	imgs [][]byte,
-	filename string,
-	retryAllowed bool,
-	sendPNGs bool,
-) error {
-	fileExt := "jpg"
-	if sendPNGs {
-		fileExt = "png"
-	}
-	if len(imgs) == 0 {
-		fmt.Println("  error: nothing to upload")
-		return fmt.Errorf("nothing to upload")
-	}
-
-	generateFilename := (filename == "")
-
-	caption := description
-	if len(caption) > 1024 {
-		caption = caption[:1021] + "..."
-	}
-	var err error
-	var media []models.InputMedia
-	for i := range imgs {
-		if generateFilename {
-			filename = fmt.Sprintf("sd-image-%d-%d-%d.%s", firstImageID, e.TaskID, i, fileExt)
-		}
-		if sendPNGs {
-			media = append(media, &models.InputMediaDocument{
-				Media:           "attach://" + filename,
-				MediaAttachment: bytes.NewReader(imgs[i]),
-				ParseMode:       models.ParseModeHTML,
-				Caption:         caption,
-			})
-		} else {
-			media = append(media, &models.InputMediaPhoto{
-				Media:           "attach://" + filename,
-				MediaAttachment: bytes.NewReader(imgs[i]),
-				ParseMode:       models.ParseModeHTML,
-				Caption:         caption,
-			})
-		}
-		caption = ""
-	}
-
-	err = e.bot.SendMediaGroup(ctx, e.Message, media)
-	if err != nil {
-		fmt.Println("  send images error:", err)
-
-		if !retryAllowed {
-			return fmt.Errorf("send images error: %w", err)
-		}
-
-		retryAfter := e.checkWaitError(err)
-		if retryAfter > 0 {
-			fmt.Println("  retrying after", retryAfter, "...")
-			time.Sleep(retryAfter)
-			return e.uploadImages(ctx, firstImageID, description, imgs, filename, false, sendPNGs)
-		}
-	}
-	return nil
-}
-
 func (e *ReqQueueEntry) deleteReply(ctx context.Context) {
 	if e.ReplyMessage == nil {
 		return
@@ -172,46 +136,100 @@ type ReqQueueCurrentEntry struct {
 	stoppedChan chan bool
 
 	gotImageChan chan telegram.ImageFileData
+
+	// Used for showing the overall progress when streaming batches.
+	batchIdx         int
+	batchCount       int
+	processStartedAt time.Time
+}
+
+// Returns the progress and ETA of all batches from the progress of the current batch.
+func (e *ReqQueueCurrentEntry) overallProgress(progressPercent int, eta time.Duration) (int, time.Duration) {
+	if e.batchCount <= 1 {
+		return progressPercent, eta
+	}
+	remainingBatches := time.Duration(e.batchCount - e.batchIdx - 1)
+	if progressPercent > 0 {
+		eta += (time.Since(e.processStartedAt) + eta) * remainingBatches
+	}
+	return (e.batchIdx*100 + progressPercent) / e.batchCount, eta
 }
 
 type ReqQueue struct {
-	bot            *telegram.SDBot
+	bot            telegram.BotAPI
 	mutex          sync.Mutex
 	ctx            context.Context
 	entries        []ReqQueueEntry
 	processReqChan chan bool
 	ProcessTimeout time.Duration
+	// Out of memory errors are retried with halved batch size, then with highres disabled.
+	OOMRecovery bool
+	// Renders are done one batch at a time, and each batch is uploaded when it's finished.
+	StreamBatches bool
+	// Rendered images are checked with the NSFW classifier if set.
+	Safety *safety.Filter
+	// Notified about withheld images.
+	AdminUserIDs []int64
+	// Group chat progress interval and spoiler mode are taken from the chat settings if set.
+	ChatSettings *chatsettings.Store
 
 	currentEntry ReqQueueCurrentEntry
+	history      renderHistory
 }
 
 type ReqQueueReq struct {
 	Type    ReqType
 	Message *models.Message
 	Params  reqparams.ReqParams
+	// Language of the replies, English if empty.
+	Lang i18n.Lang
+	// Optional, called with the sent messages after each upload of rendered images.
+	OnUploaded func(msgs []*models.Message)
 }
 
 func (q *ReqQueue) CurrentEntryParams() reqparams.ReqParams {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	return q.currentEntry.entry.Params
 }
 
 func (q *ReqQueue) GotImage(ctx context.Context, updateMsg *models.Message, imageData *telegram.ImageFileData) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.currentEntry.gotImageChan == nil {
+		return // Not waiting for an image anymore.
+	}
 	// Updating the message to reply to this document.
 	q.currentEntry.entry.Message = updateMsg
 	q.currentEntry.entry.ReplyMessage = nil
-	// Notifying the request queue that we now got the image data.
-	q.currentEntry.gotImageChan <- *imageData
+	// Notifying the request queue that we now got the image data. The channel is buffered, so this never blocks
+	// while holding the mutex.
+	select {
+	case q.currentEntry.gotImageChan <- *imageData:
+	default:
+	}
 }
 
+// Replies to the current entry while it's waiting for an image. The processor doesn't touch the entry during the
+// wait, so the reply can't interfere with its own replies.
 func (q *ReqQueue) SendReplyToCurrentEntry(ctx context.Context, text string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.currentEntry.gotImageChan == nil {
+		return
+	}
 	q.currentEntry.entry.sendReply(ctx, text)
 }
 
 func (q *ReqQueue) IsImageForMessage(msg *models.Message) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	return q.currentEntry.gotImageChan != nil && msg.From.ID == q.currentEntry.entry.Message.From.ID
 }
 
 func (q *ReqQueue) IsCurrentEntryChat() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
 	return q.currentEntry.entry.Message.Chat.ID >= 0
 }
 
@@ -223,13 +241,15 @@ func (q *ReqQueue) Add(req ReqQueueReq) {
 		Params: req.Params,
 		TaskID: rand.Uint64(),
 
-		bot:     q.bot,
-		Message: req.Message,
+		bot:        q.bot,
+		Message:    req.Message,
+		Lang:       req.Lang,
+		OnUploaded: req.OnUploaded,
 	}
 
 	if len(q.entries) > 0 {
 		fmt.Println("  queueing request at position #", len(q.entries))
-		newEntry.sendReply(q.ctx, q.getQueuePositionString(len(q.entries)))
+		newEntry.sendReply(q.ctx, newEntry.queuePositionString(len(q.entries)))
 	}
 
 	q.entries = append(q.entries, newEntry)
@@ -241,6 +261,56 @@ func (q *ReqQueue) Add(req ReqQueueReq) {
 	}
 }
 
+// Returns the index of the render entry of the message which is waiting in the queue, or -1.
+// The first entry is not waiting, as it's processed.
+func (q *ReqQueue) queuedRenderIdx(msg *models.Message) int {
+	for i := 1; i < len(q.entries); i++ {
+		e := q.entries[i]
+		if e.Type == ReqTypeRender && e.Message.Chat.ID == msg.Chat.ID && e.Message.ID == msg.ID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns true if the render request of the message is waiting in the queue.
+func (q *ReqQueue) IsQueued(msg *models.Message) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.queuedRenderIdx(msg) >= 0
+}
+
+// Replaces the params of the render request of the edited message, if it's still waiting in
+// the queue. Returns false if the request is not waiting anymore.
+func (q *ReqQueue) UpdateQueued(msg *models.Message, params reqparams.ReqParamsRender) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	i := q.queuedRenderIdx(msg)
+	if i < 0 {
+		return false
+	}
+	q.entries[i].Params = params
+	q.entries[i].Message = msg
+	q.entries[i].sendReply(q.ctx, q.entries[i].Lang.T(i18n.RequestUpdated)+"\n"+q.entries[i].queuePositionString(i))
+	return true
+}
+
+// Makes f called after the render request of the message is processed, if it's being processed
+// right now. Returns false otherwise.
+func (q *ReqQueue) WhenFinished(msg *models.Message, f func()) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.entries) == 0 {
+		return false
+	}
+	e := &q.entries[0]
+	if e.Type != ReqTypeRender || e.Message.Chat.ID != msg.Chat.ID || e.Message.ID != msg.ID {
+		return false
+	}
+	e.onFinished = f
+	return true
+}
+
 func (q *ReqQueue) CancelCurrentEntry(ctx context.Context) (err error) {
 	q.mutex.Lock()
 	if len(q.entries) > 0 {
@@ -248,17 +318,18 @@ func (q *ReqQueue) CancelCurrentEntry(ctx context.Context) (err error) {
 		q.currentEntry.ctxCancel()
 	} else {
 		fmt.Println("  no active request to cancel")
-		err = fmt.Errorf("no active request to cancel")
+		err = i18n.Errorf(i18n.NoActiveRequest)
 	}
 	q.mutex.Unlock()
 	return
 }
 
-func (q *ReqQueue) getQueuePositionString(pos int) string {
-	return "👨‍👦‍👦 Request queued at position #" + fmt.Sprint(pos)
+// All requests before the position are ahead, including the one being processed.
+func (e *ReqQueueEntry) queuePositionString(pos int) string {
+	return e.Lang.N(i18n.QueuePosition, pos, pos, pos)
 }
 
-func (q *ReqQueue) queryProgress(ctx context.Context, sdApi *sdapi.SdAPIType, prevProgressPercent int) (progressPercent int, eta time.Duration, err error) {
+func (q *ReqQueue) queryProgress(ctx context.Context, sdApi sdapi.Backend, prevProgressPercent int) (progressPercent int, eta time.Duration, err error) {
 	progressPercent = prevProgressPercent
 
 	var newProgressPercent int
@@ -295,7 +366,7 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 			return
 		}
 
-		err = fmt.Errorf("error: Stable Diffusion is not running and start is disabled")
+		err = i18n.Errorf(i18n.SDNotRunning)
 		fmt.Println("  error:", err)
 	}
 
@@ -305,14 +376,15 @@ func (q *ReqQueue) runProcessThread(processCtx context.Context, processFn ReqQue
 
 func (q *ReqQueue) runProcess(
 	processCtx context.Context,
-	sdApi *sdapi.SdAPIType,
+	sdApi sdapi.Backend,
 	processFn ReqQueueEntryProcessFn,
 	reqParams reqparams.ReqParams,
 	imageData telegram.ImageFileData,
 	reqParamsText string,
 ) (imgs [][]byte, err error) {
-	q.currentEntry.entry.sendReply(q.ctx, consts.ProcessStartStr+"\n"+reqParamsText)
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.ProcessStart)+"\n"+reqParamsText)
 
+	q.currentEntry.processStartedAt = time.Now()
 	q.currentEntry.imgsChan = make(chan [][]byte)
 	q.currentEntry.errChan = make(chan error, 1)
 	q.currentEntry.stoppedChan = make(chan bool, 1)
@@ -323,6 +395,8 @@ func (q *ReqQueue) runProcess(
 	progressUpdateInterval := consts.GroupChatProgressUpdateInterval
 	if q.currentEntry.entry.Message.Chat.ID >= 0 {
 		progressUpdateInterval = consts.PrivateChatProgressUpdateInterval
+	} else if s := q.chatSettings(q.currentEntry.entry.Message.Chat.ID); s.ProgressIntervalSeconds > 0 {
+		progressUpdateInterval = time.Duration(s.ProgressIntervalSeconds) * time.Second
 	}
 	progressPercentUpdateTicker := time.NewTicker(progressUpdateInterval)
 	defer func() {
@@ -346,12 +420,18 @@ func (q *ReqQueue) runProcess(
 	for {
 		select {
 		case <-processCtx.Done():
-			return nil, fmt.Errorf("timeout")
+			return nil, i18n.Errorf(i18n.Timeout)
 		case <-progressPercentUpdateTicker.C:
-			q.currentEntry.entry.sendReply(q.ctx, consts.ProcessStr+" "+utils.GetProgressbar(progressPercent, consts.ProgressBarLength)+" ETA: "+fmt.Sprint(eta.Round(time.Second))+"\n"+reqParamsText)
+			overallPercent, overallETA := q.currentEntry.overallProgress(progressPercent, eta)
+			progressBar := utils.GetProgressbar(overallPercent, consts.ProgressBarLength)
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.Process, progressBar, overallETA.Round(time.Second))+"\n"+reqParamsText)
 		case <-progressCheckTicker.C:
 			progressPercent, eta, _ = q.queryProgress(processCtx, sdApi, progressPercent)
 		case err = <-q.currentEntry.errChan:
+			if errors.Is(processCtx.Err(), context.DeadlineExceeded) {
+				// The API request was aborted by the timeout.
+				return nil, i18n.Errorf(i18n.Timeout)
+			}
 			return nil, err
 		case imgs = <-q.currentEntry.imgsChan:
 			return imgs, nil
@@ -359,42 +439,152 @@ func (q *ReqQueue) runProcess(
 	}
 }
 
-func (q *ReqQueue) upscale(processCtx context.Context, sdApi *sdapi.SdAPIType, reqParams reqparams.ReqParamsUpscale, imageData telegram.ImageFileData) error {
+// Checks that the upscaled image doesn't exceed the max. size of the params.
+func checkUpscaledSize(reqParams reqparams.ReqParamsUpscale, imageData []byte) error {
+	if reqParams.MaxWidth == 0 || reqParams.MaxHeight == 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageData))
+	if err != nil {
+		return i18n.Errorf(i18n.InvalidImage, err)
+	}
+	width, height := reqParams.UpscaledSize(cfg.Width, cfg.Height)
+	if width > reqParams.MaxWidth || height > reqParams.MaxHeight {
+		return i18n.Errorf(i18n.UpscaledSizeTooLarge, width, height, reqParams.MaxWidth, reqParams.MaxHeight)
+	}
+	return nil
+}
+
+func (q *ReqQueue) upscale(processCtx context.Context, sdApi sdapi.Backend, reqParams reqparams.ReqParamsUpscale, imageData telegram.ImageFileData) error {
 	reqParamsText := reqParams.String()
+	if err := checkUpscaledSize(reqParams, imageData.Data); err != nil {
+		return err
+	}
 
 	imgs, err := q.runProcess(processCtx, sdApi, sdApi.Upscale, reqParams, imageData, reqParamsText)
 	if err != nil {
 		return err
 	}
 
-	fn := utils.FilenameWithoutExt(imageData.Filename) + "-upscaled"
-	if !reqParams.OutputPNG {
-		err = q.currentEntry.entry.convertImagesFromPNGToJPG(imgs)
-		if err != nil {
-			return err
-		}
-		fn += ".jpg"
-	} else {
-		fn += ".png"
+	if err = q.currentEntry.entry.convertImages(imgs, reqParams.Output); err != nil {
+		return err
 	}
+	fn := utils.FilenameWithoutExt(imageData.Filename) + "-upscaled." + reqParams.Output.FileExt()
 
 	fmt.Println("  uploading...")
-	q.currentEntry.entry.sendReply(q.ctx, consts.UploadingStr+"\n"+reqParamsText)
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.Uploading)+"\n"+reqParamsText)
 
-	err = q.currentEntry.entry.uploadImages(q.ctx, 0, "", imgs, fn, true, reqParams.OutputPNG)
+	_, err = q.currentEntry.entry.uploadImages(q.ctx, 0, "", imgs, fn, true, reqParams.Output, nil)
 	if err == nil {
 		q.currentEntry.entry.deleteReply(q.ctx)
 	}
 	return err
 }
 
-func (q *ReqQueue) render(processCtx context.Context, sdApi *sdapi.SdAPIType, reqParams reqparams.ReqParamsRender) error {
-	reqParamsText := reqParams.String()
+func isOOMError(err error) bool {
+	var apiErr *sdapi.APIError
+	return errors.As(err, &apiErr) && apiErr.Kind == sdapi.ErrorKindOOM
+}
+
+// Lowers the render params for retrying after an out of memory error. Returns an empty
+// message if there's nothing left to lower.
+func oomDowngrade(reqParams *reqparams.ReqParamsRender) i18n.Message {
+	if reqParams.BatchSize > 1 {
+		reqParams.BatchSize /= 2
+		return i18n.Msg(i18n.OOMDowngradeBatchSize, reqParams.BatchSize)
+	}
+	if reqParams.HR.Scale > 0 {
+		reqParams.HR = reqparams.ReqParamsRenderHR{}
+		return i18n.Msg(i18n.OOMDowngradeHRDisabled)
+	}
+	return i18n.Message{}
+}
+
+// Runs the render, retrying with lowered params on out of memory errors if enabled. The
+// downgrades are applied to reqParams.
+func (q *ReqQueue) runRender(processCtx context.Context, sdApi sdapi.Backend, reqParams *reqparams.ReqParamsRender, progressText string) (imgs [][]byte, err error) {
+	var downgradesText string
+	for {
+		imgs, err = q.runProcess(processCtx, sdApi, sdApi.Render, *reqParams, telegram.ImageFileData{}, downgradesText+progressText+reqParams.String())
+		if err == nil {
+			return imgs, nil
+		}
+		if !q.OOMRecovery || !isOOMError(err) {
+			return nil, err
+		}
+		downgrade := oomDowngrade(reqParams)
+		if downgrade.Key == i18n.None {
+			return nil, err
+		}
+
+		// Waiting for the failed process thread to stop before starting a new one.
+		<-q.currentEntry.stoppedChan
+		fmt.Println("  out of memory, retrying with", downgrade)
+		downgradesText += q.currentEntry.entry.Lang.T(i18n.OOMRetry, downgrade) + "\n"
+	}
+}
+
+// Renders one batch at a time and uploads each batch when it's finished.
+func (q *ReqQueue) renderStreaming(processCtx context.Context, sdApi sdapi.Backend, reqParams reqparams.ReqParamsRender) error {
+	batchSize := reqParams.BatchSize
+	q.currentEntry.batchCount = (reqParams.NumOutputs + batchSize - 1) / batchSize
+	defer func() {
+		q.currentEntry.batchIdx = 0
+		q.currentEntry.batchCount = 0
+	}()
+
+	for q.currentEntry.batchIdx = 0; q.currentEntry.batchIdx < q.currentEntry.batchCount; q.currentEntry.batchIdx++ {
+		done := q.currentEntry.batchIdx * batchSize
+		batchParams := reqParams
+		batchParams.Seed = reqParams.Seed + uint32(done)
+		batchParams.NumOutputs = min(batchSize, reqParams.NumOutputs-done)
+
+		progressText := q.currentEntry.entry.Lang.N(i18n.BatchProgress, done, q.currentEntry.batchIdx+1, q.currentEntry.batchCount, done) + "\n"
+		imgs, err := q.runRender(processCtx, sdApi, &batchParams, progressText)
+		if err != nil {
+			return err
+		}
+		// Keeping the downgrades for the next batches.
+		reqParams.BatchSize = batchParams.BatchSize
+		reqParams.HR = batchParams.HR
+
+		var spoilers []bool
+		seeds := imageSeeds(batchParams.Seed, len(imgs))
+		if imgs, seeds, spoilers, err = q.checkImages(imgs, seeds); err != nil {
+			return err
+		}
+		if len(imgs) == 0 {
+			continue
+		}
+		if err = q.currentEntry.entry.convertImages(imgs, reqParams.Output); err != nil {
+			return err
+		}
+
+		fmt.Println("  uploading batch", q.currentEntry.batchIdx+1, "of", q.currentEntry.batchCount, "...")
+		batchParamsText := batchParams.String()
+		msgs, err := q.currentEntry.entry.uploadImages(q.ctx, batchParams.Seed, reqParams.OriginalPrompt()+"\n"+batchParamsText, imgs, "", true, reqParams.Output, spoilers)
+		if err != nil {
+			return err
+		}
+		q.addToHistory(batchParams, seeds, msgs)
+		q.currentEntry.entry.uploaded(msgs)
+	}
+
+	q.currentEntry.entry.deleteReply(q.ctx)
+	return nil
+}
+
+func (q *ReqQueue) render(processCtx context.Context, sdApi sdapi.Backend, reqParams reqparams.ReqParamsRender) error {
+	// Upscaling is done for the whole render, so it's not streamed.
+	if q.StreamBatches && reqParams.NumOutputs > reqParams.BatchSize && reqParams.Upscale.Scale == 0 {
+		return q.renderStreaming(processCtx, sdApi, reqParams)
+	}
 
-	imgs, err := q.runProcess(processCtx, sdApi, sdApi.Render, reqParams, telegram.ImageFileData{}, reqParamsText)
+	imgs, err := q.runRender(processCtx, sdApi, &reqParams, "")
 	if err != nil {
 		return err
 	}
+	reqParamsText := reqParams.String()
 
 	// Now we have the output images.
 	if reqParams.Upscale.Scale > 0 {
@@ -402,7 +592,7 @@ func (q *ReqQueue) render(processCtx context.Context, sdApi *sdapi.SdAPIType, re
 			OriginalPromptText: reqParams.OriginalPrompt(),
 			Scale:              reqParams.Upscale.Scale,
 			Upscaler:           reqParams.Upscale.Upscaler,
-			OutputPNG:          reqParams.OutputPNG,
+			Output:             reqParams.Output,
 		}
 		imgs, err = q.runProcess(processCtx, sdApi, sdApi.Upscale, reqParamsUpscale, telegram.ImageFileData{Data: imgs[0], Filename: ""}, reqParamsUpscale.String())
 		if err != nil {
@@ -410,24 +600,42 @@ func (q *ReqQueue) render(processCtx context.Context, sdApi *sdapi.SdAPIType, re
 		}
 	}
 
-	if !reqParams.OutputPNG {
-		err = q.currentEntry.entry.convertImagesFromPNGToJPG(imgs)
-		if err != nil {
-			return err
-		}
+	var spoilers []bool
+	seeds := imageSeeds(reqParams.Seed, len(imgs))
+	if imgs, seeds, spoilers, err = q.checkImages(imgs, seeds); err != nil {
+		return err
+	}
+	if len(imgs) == 0 {
+		q.currentEntry.entry.deleteReply(q.ctx)
+		return nil
+	}
+	if err = q.currentEntry.entry.convertImages(imgs, reqParams.Output); err != nil {
+		return err
 	}
 
 	fmt.Println("  uploading...")
-	q.currentEntry.entry.sendReply(q.ctx, consts.UploadingStr+"\n"+reqParamsText)
+	q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.Uploading)+"\n"+reqParamsText)
 
-	err = q.currentEntry.entry.uploadImages(q.ctx, reqParams.Seed, reqParams.OriginalPrompt()+"\n"+reqParamsText, imgs, "", true, reqParams.OutputPNG)
-	if err == nil {
-		q.currentEntry.entry.deleteReply(q.ctx)
+	msgs, err := q.currentEntry.entry.uploadImages(q.ctx, reqParams.Seed, reqParams.OriginalPrompt()+"\n"+reqParamsText, imgs, "", true, reqParams.Output, spoilers)
+	if err != nil {
+		return err
 	}
-	return err
+	q.addToHistory(reqParams, seeds, msgs)
+	q.currentEntry.entry.uploaded(msgs)
+	q.currentEntry.entry.deleteReply(q.ctx)
+	return nil
+}
+
+// Images of a render are made with consecutive seeds.
+func imageSeeds(firstSeed uint32, cnt int) []uint32 {
+	seeds := make([]uint32, cnt)
+	for i := range seeds {
+		seeds[i] = firstSeed + uint32(i)
+	}
+	return seeds
 }
 
-func (q *ReqQueue) processQueueEntry(processCtx context.Context, sdApi *sdapi.SdAPIType, imageData telegram.ImageFileData) error {
+func (q *ReqQueue) processQueueEntry(processCtx context.Context, sdApi sdapi.Backend, imageData telegram.ImageFileData) error {
 	fmt.Print("processing request from ", q.currentEntry.entry.Message.From.Username, "#",
 		q.currentEntry.entry.Message.From.ID, ": ", q.currentEntry.entry.Params.OriginalPrompt(), "\n")
 
@@ -441,7 +649,7 @@ func (q *ReqQueue) processQueueEntry(processCtx context.Context, sdApi *sdapi.Sd
 	}
 }
 
-func (q *ReqQueue) processor(sdApi *sdapi.SdAPIType) {
+func (q *ReqQueue) processor(sdApi sdapi.Backend) {
 	for {
 		q.mutex.Lock()
 		if (len(q.entries)) == 0 {
@@ -452,7 +660,7 @@ func (q *ReqQueue) processor(sdApi *sdapi.SdAPIType) {
 
 		// Updating queue positions for all waiting entries.
 		for i := 1; i < len(q.entries); i++ {
-			q.bot.SendReplyToMessage(q.ctx, q.entries[i].Message, q.getQueuePositionString(i))
+			q.bot.SendReplyToMessage(q.ctx, q.entries[i].Message, q.entries[i].queuePositionString(i))
 		}
 
 		q.currentEntry = ReqQueueCurrentEntry{
@@ -471,21 +679,27 @@ func (q *ReqQueue) processor(sdApi *sdapi.SdAPIType) {
 		}
 		if imageNeededFirst {
 			fmt.Println("  waiting for image file...")
-			q.currentEntry.entry.sendReply(q.ctx, consts.ImageReqStr)
-			q.currentEntry.gotImageChan = make(chan telegram.ImageFileData)
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.ImageReq))
+			gotImageChan := make(chan telegram.ImageFileData, 1)
+			q.mutex.Lock()
+			q.currentEntry.gotImageChan = gotImageChan
+			q.mutex.Unlock()
 			select {
-			case imageData = <-q.currentEntry.gotImageChan:
+			case imageData = <-gotImageChan:
 			case <-processCtx.Done():
+				q.mutex.Lock()
 				q.currentEntry.canceled = true
+				q.mutex.Unlock()
 			case <-time.NewTimer(3 * time.Minute).C:
 				fmt.Println("  waiting for image file timeout")
-				err = fmt.Errorf("waiting for image data timeout")
+				err = i18n.Errorf(i18n.ImageWaitTimeout)
 			}
-			close(q.currentEntry.gotImageChan)
+			q.mutex.Lock()
 			q.currentEntry.gotImageChan = nil
+			q.mutex.Unlock()
 
 			if err == nil && len(imageData.Data) == 0 {
-				err = fmt.Errorf("got no image data")
+				err = i18n.Errorf(i18n.NoImageData)
 			}
 		}
 
@@ -500,10 +714,11 @@ func (q *ReqQueue) processor(sdApi *sdapi.SdAPIType) {
 			if err != nil {
 				fmt.Println("  can't interrupt:", err)
 			}
-			q.currentEntry.entry.sendReply(q.ctx, consts.CanceledStr)
+			q.currentEntry.entry.sendReply(q.ctx, q.currentEntry.entry.Lang.T(i18n.Canceled))
 		} else if err != nil {
 			fmt.Println("  error:", err)
-			q.currentEntry.entry.sendReply(q.ctx, consts.ErrorStr+": "+err.Error())
+			lang := q.currentEntry.entry.Lang
+			q.currentEntry.entry.sendReply(q.ctx, lang.T(i18n.Error, sdapi.ErrorMessage(err, lang)))
 		}
 
 		q.currentEntry.ctxCancel()
@@ -516,15 +731,20 @@ func (q *ReqQueue) processor(sdApi *sdapi.SdAPIType) {
 			q.currentEntry.stoppedChan = nil
 		}
 
+		onFinished := q.entries[0].onFinished
 		q.entries = q.entries[1:]
 		if len(q.entries) == 0 {
 			fmt.Print("finished queue processing\n")
 		}
 		q.mutex.Unlock()
+
+		if onFinished != nil {
+			onFinished()
+		}
 	}
 }
 
-func (q *ReqQueue) Init(ctx context.Context, sdApi *sdapi.SdAPIType, bot *telegram.SDBot) {
+func (q *ReqQueue) Init(ctx context.Context, sdApi sdapi.Backend, bot telegram.BotAPI) {
 	q.ctx = ctx
 	q.processReqChan = make(chan bool)
 	q.bot = bot
@@ -0,0 +1,291 @@
+package reqqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	sdapi "github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram/telegramtest"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestQueue(t *testing.T, processTimeout time.Duration) (*ReqQueue, *sdapitest.Server, *telegramtest.FakeBot) {
+	t.Helper()
+	sdSrv := sdapitest.NewServer()
+	t.Cleanup(sdSrv.Close)
+	tgBot := telegramtest.NewFakeBot(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	q := &ReqQueue{ProcessTimeout: processTimeout}
+	q.Init(ctx, &sdapi.SdAPIType{SdHost: sdSrv.URL}, tgBot)
+	return q, sdSrv, tgBot
+}
+
+func newTestRenderReq(msgID int) ReqQueueReq {
+	return ReqQueueReq{
+		Type: ReqTypeRender,
+		Message: &models.Message{
+			ID:   msgID,
+			Chat: models.Chat{ID: 1},
+			From: &models.User{ID: 1},
+		},
+		Params: reqparams.ReqParamsRender{
+			OriginalPromptText: "cat",
+			Prompt:             "cat",
+			Width:              16,
+			Height:             16,
+			BatchSize:          1,
+			NumOutputs:         2,
+			Steps:              1,
+			Output:             reqparams.ReqParamsOutput{Format: "png", AsDocument: true},
+		},
+	}
+}
+
+func TestReqQueueRender(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	sdSrv.Latency = 300 * time.Millisecond
+
+	q.Add(newTestRenderReq(1))
+	waitFor(t, "render start", func() bool { return len(sdSrv.Requests("/sdapi/v1/txt2img")) == 1 })
+	// The second request gets queued while the first one is rendering.
+	q.Add(newTestRenderReq(2))
+
+	waitFor(t, "two uploads", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 2 })
+	if !tgBot.HasText("SendReplyToMessage", "Request queued at position #1") {
+		t.Error("queue position reply not sent")
+	}
+
+	uploads := tgBot.Calls("SendMediaGroup")
+	if uploads[0].ReplyToID != 1 || uploads[1].ReplyToID != 2 {
+		t.Errorf("uploads are not replies to the requests")
+	}
+	media := uploads[0].Media
+	if len(media) != 2 {
+		t.Errorf("got %d uploaded images, expected 2", len(media))
+	}
+	if _, ok := media[0].(*models.InputMediaDocument); !ok {
+		t.Errorf("got media type %T, expected document for PNGs", media[0])
+	}
+	waitFor(t, "progress reply deletion", func() bool { return len(tgBot.Calls("DeleteMessage")) == 2 })
+}
+
+func TestReqQueueCancel(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	sdSrv.Latency = time.Minute
+
+	q.Add(newTestRenderReq(1))
+	waitFor(t, "render start", func() bool { return len(sdSrv.Requests("/sdapi/v1/txt2img")) == 1 })
+
+	if err := q.CancelCurrentEntry(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "canceled reply", func() bool { return tgBot.HasText("EditMessage", i18n.English.T(i18n.Canceled)) })
+	if len(sdSrv.Requests("/sdapi/v1/interrupt")) == 0 {
+		t.Error("render not interrupted")
+	}
+	if len(tgBot.Calls("SendMediaGroup")) != 0 {
+		t.Error("images uploaded for a canceled request")
+	}
+
+	waitFor(t, "queue to get empty", func() bool {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		return len(q.entries) == 0
+	})
+	if err := q.CancelCurrentEntry(context.Background()); err == nil {
+		t.Error("expected error when canceling with empty queue")
+	}
+}
+
+func TestReqQueueTimeout(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, 300*time.Millisecond)
+	sdSrv.Latency = time.Minute
+
+	q.Add(newTestRenderReq(1))
+	waitFor(t, "timeout error reply", func() bool {
+		return tgBot.HasText("EditMessage", i18n.English.T(i18n.Error, i18n.Msg(i18n.Timeout)))
+	})
+	if len(tgBot.Calls("SendMediaGroup")) != 0 {
+		t.Error("images uploaded for a timed out request")
+	}
+}
+
+func TestReqQueueRenderError(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	sdSrv.FailNext("/sdapi/v1/txt2img", 1, http.StatusInternalServerError, `{"detail": "failure"}`)
+
+	q.Add(newTestRenderReq(1))
+	waitFor(t, "error reply", func() bool { return tgBot.HasText("EditMessage", i18n.English.T(i18n.Error, "")) })
+	if !tgBot.HasText("EditMessage", i18n.English.T(i18n.APIError, "failure")) || tgBot.HasText("EditMessage", sdSrv.URL) {
+		t.Errorf("got error replies %+v", tgBot.Calls("EditMessage"))
+	}
+}
+
+func TestReqQueueFloodWait(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	tgBot.FailWithRetryAfter("SendMediaGroup", 1, 1)
+
+	q.Add(newTestRenderReq(1))
+	waitFor(t, "upload after flood wait", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 1 })
+}
+
+func TestReqQueueOOMRecovery(t *testing.T) {
+	const oomBody = `{"error": "OutOfMemoryError", "errors": "CUDA out of memory."}`
+
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	q.OOMRecovery = true
+	sdSrv.FailNext("/sdapi/v1/txt2img", 2, http.StatusInternalServerError, oomBody)
+
+	req := newTestRenderReq(1)
+	params := req.Params.(reqparams.ReqParamsRender)
+	params.BatchSize = 2
+	params.NumOutputs = 4
+	params.HR = reqparams.ReqParamsRenderHR{Scale: 2, Upscaler: "Lanczos"}
+	req.Params = params
+	q.Add(req)
+
+	waitFor(t, "upload", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 1 })
+	var renderReq sdapi.RenderReq
+	if err := sdSrv.LastRequest("/sdapi/v1/txt2img", &renderReq); err != nil {
+		t.Fatal(err)
+	}
+	if renderReq.BatchSize != 1 || renderReq.NIter != 4 || renderReq.EnableHR {
+		t.Errorf("got batch size %d, n_iter %d, hr %v", renderReq.BatchSize, renderReq.NIter, renderReq.EnableHR)
+	}
+	if !tgBot.HasText("EditMessage", i18n.English.T(i18n.OOMRetry, "batch size 1")+"\n"+i18n.English.T(i18n.OOMRetry, "highres disabled")) {
+		t.Errorf("downgrades not reported, got replies %+v", tgBot.Calls("EditMessage"))
+	}
+	if len(tgBot.Calls("SendMediaGroup")[0].Media) != 4 {
+		t.Error("expected all outputs to be uploaded")
+	}
+
+	// Gives up when there's nothing left to lower.
+	sdSrv.FailNext("/sdapi/v1/txt2img", 1, http.StatusInternalServerError, oomBody)
+	q.Add(newTestRenderReq(2))
+	waitFor(t, "error reply", func() bool { return tgBot.HasText("EditMessage", i18n.English.T(i18n.APIErrorOOM)) })
+}
+
+func TestReqQueueStreamBatches(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	q.StreamBatches = true
+
+	req := newTestRenderReq(1)
+	params := req.Params.(reqparams.ReqParamsRender)
+	params.Seed = 10
+	params.NumOutputs = 5
+	params.BatchSize = 2
+	req.Params = params
+	q.Add(req)
+
+	waitFor(t, "three uploads", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 3 })
+	reqs := sdSrv.Requests("/sdapi/v1/txt2img")
+	expected := []struct {
+		seed      uint32
+		batchSize int
+		nIter     int
+	}{{10, 2, 1}, {12, 2, 1}, {14, 2, 1}}
+	for i, body := range reqs {
+		var renderReq sdapi.RenderReq
+		if err := json.Unmarshal(body, &renderReq); err != nil {
+			t.Fatal(err)
+		}
+		if renderReq.Seed != expected[i].seed || renderReq.BatchSize != expected[i].batchSize || renderReq.NIter != expected[i].nIter {
+			t.Errorf("got render request #%d seed %d, batch size %d, n_iter %d", i, renderReq.Seed, renderReq.BatchSize, renderReq.NIter)
+		}
+	}
+	uploads := tgBot.Calls("SendMediaGroup")
+	// The fake API renders the whole batch, so the last upload has 2 images.
+	if len(uploads[0].Media) != 2 || len(uploads[2].Media) != 2 {
+		t.Errorf("got uploads with %d and %d images", len(uploads[0].Media), len(uploads[2].Media))
+	}
+	if !tgBot.HasText("EditMessage", "Batch 2/3, 2 images delivered") {
+		t.Errorf("batch progress not shown, got replies %+v", tgBot.Calls("EditMessage"))
+	}
+}
+
+func TestReqQueueStreamBatchesCancel(t *testing.T) {
+	q, sdSrv, tgBot := newTestQueue(t, time.Minute)
+	q.StreamBatches = true
+	sdSrv.Latency = 300 * time.Millisecond
+
+	req := newTestRenderReq(1)
+	params := req.Params.(reqparams.ReqParamsRender)
+	params.NumOutputs = 4
+	req.Params = params
+	q.Add(req)
+
+	waitFor(t, "first upload", func() bool { return len(tgBot.Calls("SendDocument")) == 1 })
+	if err := q.CancelCurrentEntry(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "canceled reply", func() bool { return tgBot.HasText("EditMessage", i18n.English.T(i18n.Canceled)) })
+	time.Sleep(400 * time.Millisecond)
+	if n := len(tgBot.Calls("SendDocument")); n != 1 {
+		t.Errorf("got %d uploads after cancel, expected 1", n)
+	}
+}
+
+func TestConvertImages(t *testing.T) {
+	e := &ReqQueueEntry{}
+	for _, tc := range []struct {
+		output reqparams.ReqParamsOutput
+		magic  []byte
+		offset int
+	}{
+		{reqparams.ReqParamsOutput{Format: "png"}, []byte("\x89PNG"), 0},
+		{reqparams.ReqParamsOutput{}, []byte{0xff, 0xd8}, 0},
+		{reqparams.ReqParamsOutput{Format: "jpg", Quality: 50}, []byte{0xff, 0xd8}, 0},
+		{reqparams.ReqParamsOutput{Format: "webp"}, []byte("WEBP"), 8},
+	} {
+		imgs := [][]byte{sdapitest.GeneratePNG(16, 16, 1)}
+		if err := e.convertImages(imgs, tc.output); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(imgs[0][tc.offset:], tc.magic) {
+			t.Errorf("got wrong file header for %+v", tc.output)
+		}
+	}
+}
+
+func TestCheckUpscaledSize(t *testing.T) {
+	img := sdapitest.GeneratePNG(1000, 500, 1)
+	for _, tc := range []struct {
+		params         reqparams.ReqParamsUpscale
+		expectedErrStr string
+	}{
+		{reqparams.ReqParamsUpscale{Scale: 4, MaxWidth: 4096, MaxHeight: 4096}, ""},
+		{reqparams.ReqParamsUpscale{Scale: 4.5, MaxWidth: 4096, MaxHeight: 4096}, "upscaled size 4500x2250 is too large, maximum is 4096x4096"},
+		{reqparams.ReqParamsUpscale{Scale: 64}, ""},
+		{reqparams.ReqParamsUpscale{Scale: 64, TargetWidth: 4096, TargetHeight: 4096, MaxWidth: 4096, MaxHeight: 4096}, ""},
+	} {
+		err := checkUpscaledSize(tc.params, img)
+		if (tc.expectedErrStr == "" && err != nil) || (tc.expectedErrStr != "" && (err == nil || err.Error() != tc.expectedErrStr)) {
+			t.Errorf("got error %v for %+v, expected %q", err, tc.params, tc.expectedErrStr)
+		}
+	}
+
+	if err := checkUpscaledSize(reqparams.ReqParamsUpscale{Scale: 2, MaxWidth: 4096, MaxHeight: 4096}, []byte("not an image")); err == nil {
+		t.Error("expected error for invalid image data")
+	}
+}
@@ -0,0 +1,77 @@
+package reqqueue
+
+import (
+	"fmt"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
+)
+
+// Checks the rendered PNG images with the NSFW classifier, and applies the chat's action to
+// the flagged images. Returns the images to upload with their seeds, and which of them should
+// be spoilers.
+func (q *ReqQueue) checkImages(imgs [][]byte, seeds []uint32) ([][]byte, []uint32, []bool, error) {
+	msg := q.currentEntry.entry.Message
+	spoilerMode := q.chatSettings(msg.Chat.ID).SpoilerMode
+	if spoilerMode == chatsettings.SpoilerModeAll {
+		spoilers := make([]bool, len(imgs))
+		for i := range spoilers {
+			spoilers[i] = true
+		}
+		return imgs, seeds, spoilers, nil
+	}
+
+	if q.Safety == nil || !q.Safety.HasClassifier() {
+		return imgs, seeds, nil, nil
+	}
+	flagged, flaggedCnt := q.Safety.FlagImages(q.ctx, imgs)
+	if flaggedCnt == 0 {
+		return imgs, seeds, nil, nil
+	}
+
+	action := q.Safety.NSFWAction(msg.Chat.ID)
+	if spoilerMode != "" {
+		action = safety.Action(spoilerMode)
+	}
+	fmt.Println("  nsfw images:", flaggedCnt, "of", len(imgs), "action:", action)
+
+	switch action {
+	case safety.ActionBlur:
+		for i := range imgs {
+			if !flagged[i] {
+				continue
+			}
+			blurred, err := safety.Blur(imgs[i])
+			if err != nil {
+				fmt.Println("  blur error:", err)
+				return nil, nil, nil, fmt.Errorf("blur error: %w", err)
+			}
+			imgs[i] = blurred
+		}
+		return imgs, seeds, nil, nil
+	case safety.ActionWithhold:
+		var res [][]byte
+		var resSeeds []uint32
+		for i := range imgs {
+			if !flagged[i] {
+				res = append(res, imgs[i])
+				resSeeds = append(resSeeds, seeds[i])
+			}
+		}
+		q.bot.SendReplyToMessage(q.ctx, msg, q.currentEntry.entry.Lang.N(i18n.NSFWWithheld, flaggedCnt, flaggedCnt))
+		// Messages to admins are not localized, as the admins' languages are not known.
+		q.bot.SendTextToAdmins(q.ctx, q.AdminUserIDs, i18n.English.T(i18n.NSFWWithheldToAdmins,
+			flaggedCnt, msg.From.Username, msg.From.ID, msg.Chat.ID, q.currentEntry.entry.Params.OriginalPrompt()))
+		return res, resSeeds, nil, nil
+	default:
+		return imgs, seeds, flagged, nil
+	}
+}
+
+func (q *ReqQueue) chatSettings(chatID int64) chatsettings.Settings {
+	if q.ChatSettings == nil {
+		return chatsettings.Settings{}
+	}
+	return q.ChatSettings.Get(chatID)
+}
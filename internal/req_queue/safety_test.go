@@ -0,0 +1,127 @@
+package reqqueue
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/chatsettings"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/safety"
+)
+
+// Flags every other image as NSFW, starting with the first one.
+func newTestSafetyFilter(t *testing.T, action safety.Action) *safety.Filter {
+	t.Helper()
+	var mutex sync.Mutex
+	var cnt int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fmt.Fprintf(w, `{"score": %d}`, 1-cnt%2)
+		cnt++
+	}))
+	t.Cleanup(srv.Close)
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"default": {"nsfw_action": "`+string(action)+`"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := safety.NewFilter(path, srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReqQueueNSFWSpoiler(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	q.Safety = newTestSafetyFilter(t, safety.ActionSpoiler)
+
+	q.Add(newTestRenderReq(1))
+
+	waitFor(t, "uploads", func() bool { return len(tgBot.Calls("SendPhoto")) == 1 && len(tgBot.Calls("SendDocument")) == 1 })
+	photo := tgBot.Calls("SendPhoto")[0]
+	if !photo.Spoiler || photo.Text == "" {
+		t.Errorf("flagged image is not sent as a spoiler photo with the caption: %+v", photo)
+	}
+	if doc := tgBot.Calls("SendDocument")[0]; doc.Spoiler || doc.Text != "" {
+		t.Errorf("got document upload %+v", doc)
+	}
+}
+
+func TestReqQueueNSFWWithhold(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	q.Safety = newTestSafetyFilter(t, safety.ActionWithhold)
+	q.AdminUserIDs = []int64{100}
+
+	q.Add(newTestRenderReq(1))
+
+	waitFor(t, "upload", func() bool { return len(tgBot.Calls("SendDocument")) == 1 })
+	if len(tgBot.Calls("SendPhoto")) != 0 || len(tgBot.Calls("SendMediaGroup")) != 0 {
+		t.Errorf("flagged image is uploaded, got calls %+v", tgBot.Calls(""))
+	}
+	if !tgBot.HasText("SendReplyToMessage", i18n.English.N(i18n.NSFWWithheld, 1, 1)) {
+		t.Error("user is not notified")
+	}
+	admins := tgBot.Calls("SendTextToAdmins")
+	if len(admins) != 1 || admins[0].ChatID != 100 {
+		t.Errorf("admins are not notified, got %+v", admins)
+	}
+}
+
+func TestReqQueueNSFWBlur(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	q.Safety = newTestSafetyFilter(t, safety.ActionBlur)
+
+	q.Add(newTestRenderReq(1))
+
+	waitFor(t, "upload", func() bool { return len(tgBot.Calls("SendMediaGroup")) == 1 })
+	if len(tgBot.Calls("SendMediaGroup")[0].Media) != 2 {
+		t.Errorf("got calls %+v", tgBot.Calls(""))
+	}
+}
+
+func newTestChatSettings(t *testing.T, spoilerMode string) *chatsettings.Store {
+	t.Helper()
+	store, err := chatsettings.NewStore(filepath.Join(t.TempDir(), "chatsettings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Set(1, chatsettings.Settings{SpoilerMode: spoilerMode}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestReqQueueChatSpoilerModeAll(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	q.ChatSettings = newTestChatSettings(t, chatsettings.SpoilerModeAll)
+
+	q.Add(newTestRenderReq(1))
+
+	waitFor(t, "uploads", func() bool { return len(tgBot.Calls("SendPhoto")) == 2 })
+	for _, photo := range tgBot.Calls("SendPhoto") {
+		if !photo.Spoiler {
+			t.Errorf("image is not sent as a spoiler: %+v", photo)
+		}
+	}
+}
+
+func TestReqQueueChatSpoilerModeOverridesAction(t *testing.T) {
+	q, _, tgBot := newTestQueue(t, time.Minute)
+	q.Safety = newTestSafetyFilter(t, safety.ActionSpoiler)
+	q.ChatSettings = newTestChatSettings(t, string(safety.ActionWithhold))
+
+	q.Add(newTestRenderReq(1))
+
+	waitFor(t, "upload", func() bool { return len(tgBot.Calls("SendDocument")) == 1 })
+	if len(tgBot.Calls("SendPhoto")) != 0 || !tgBot.HasText("SendReplyToMessage", i18n.English.N(i18n.NSFWWithheld, 1, 1)) {
+		t.Errorf("flagged image is not withheld, got calls %+v", tgBot.Calls(""))
+	}
+}
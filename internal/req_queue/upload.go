@@ -0,0 +1,216 @@
+package reqqueue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"golang.org/x/image/draw"
+)
+
+// Telegram limits.
+const (
+	maxMediaGroupSize     = 10
+	maxPhotoFileSize      = 10 * 1024 * 1024
+	maxPhotoDimensionsSum = 10000
+	maxCaptionLength      = 1024
+)
+
+type uploadFile struct {
+	// Index of the image in the uploaded images.
+	idx        int
+	filename   string
+	data       []byte
+	asDocument bool
+	spoiler    bool
+}
+
+// Returns the JPEG image downscaled to fit into the photo dimension limit.
+func downscaleToPhotoLimit(data []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	scale := float64(maxPhotoDimensionsSum) / float64(b.Dx()+b.Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	buf := new(bytes.Buffer)
+	if err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Makes the photo fit into the Telegram photo limits. Photos which are too large in
+// dimensions are downscaled to JPEG, and photos which are too large in file size are sent as
+// documents.
+func fitPhoto(f *uploadFile) {
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(f.data)); err == nil && cfg.Width+cfg.Height > maxPhotoDimensionsSum {
+		fmt.Println("  photo is too large (", cfg.Width, "x", cfg.Height, "), downscaling")
+		if d, err := downscaleToPhotoLimit(f.data); err == nil {
+			f.data = d
+			f.filename = strings.TrimSuffix(f.filename, filepath.Ext(f.filename)) + ".jpg"
+		} else {
+			fmt.Println("  downscale error:", err)
+			f.asDocument = true
+		}
+	}
+	if len(f.data) > maxPhotoFileSize {
+		fmt.Println("  photo file is too large (", len(f.data), "bytes), sending as document")
+		f.asDocument = true
+	}
+}
+
+// Retries the send once if Telegram asks to wait.
+func (e *ReqQueueEntry) sendWithRetry(retryAllowed bool, send func() error) error {
+	err := send()
+	if err == nil {
+		return nil
+	}
+	fmt.Println("  send images error:", err)
+
+	if retryAllowed {
+		if retryAfter := e.checkWaitError(err); retryAfter > 0 {
+			fmt.Println("  retrying after", retryAfter, "...")
+			time.Sleep(retryAfter)
+			return e.sendWithRetry(false, send)
+		}
+	}
+	return fmt.Errorf("send images error: %w", err)
+}
+
+// Sends the files as a single photo/document, or as a media group. Files should be of the
+// same kind, as Telegram doesn't allow mixing photos and documents in a media group. Spoiler
+// photos should be sent one at a time. Returns the sent messages.
+func (e *ReqQueueEntry) sendFiles(ctx context.Context, files []uploadFile, caption string, retryAllowed bool) (msgs []*models.Message, err error) {
+	if len(files) == 1 {
+		err = e.sendWithRetry(retryAllowed, func() error {
+			var msg *models.Message
+			if files[0].asDocument {
+				msg, err = e.bot.SendDocument(ctx, e.Message, files[0].filename, files[0].data, caption)
+			} else {
+				msg, err = e.bot.SendPhoto(ctx, e.Message, files[0].filename, files[0].data, caption, files[0].spoiler)
+			}
+			msgs = []*models.Message{msg}
+			return err
+		})
+		return msgs, err
+	}
+
+	var media []models.InputMedia
+	for _, f := range files {
+		if f.asDocument {
+			media = append(media, &models.InputMediaDocument{
+				Media:           "attach://" + f.filename,
+				MediaAttachment: bytes.NewReader(f.data),
+				ParseMode:       models.ParseModeHTML,
+				Caption:         caption,
+			})
+		} else {
+			media = append(media, &models.InputMediaPhoto{
+				Media:           "attach://" + f.filename,
+				MediaAttachment: bytes.NewReader(f.data),
+				ParseMode:       models.ParseModeHTML,
+				Caption:         caption,
+			})
+		}
+		caption = ""
+	}
+	err = e.sendWithRetry(retryAllowed, func() error {
+		// Readers need to be rewound for retries.
+		for i, m := range media {
+			switch m := m.(type) {
+			case *models.InputMediaDocument:
+				m.MediaAttachment = bytes.NewReader(files[i].data)
+			case *models.InputMediaPhoto:
+				m.MediaAttachment = bytes.NewReader(files[i].data)
+			}
+		}
+		msgs, err = e.bot.SendMediaGroup(ctx, e.Message, media)
+		return err
+	})
+	return msgs, err
+}
+
+// If filename is empty then a filename will be automatically generated. Images are sent in
+// chunks of the maximum media group size, the caption is put on the first message. Images
+// with spoilers set (spoilers can be nil) are sent as separate spoiler photos. Returns the
+// sent messages by image index.
+func (e *ReqQueueEntry) uploadImages(
+	ctx context.Context,
+	firstImageID uint32,
+	description string,
+	imgs [][]byte,
+	filename string,
+	retryAllowed bool,
+	output reqparams.ReqParamsOutput,
+	spoilers []bool,
+) ([]*models.Message, error) {
+	fileExt := output.FileExt()
+	if len(imgs) == 0 {
+		fmt.Println("  error: nothing to upload")
+		return nil, fmt.Errorf("nothing to upload")
+	}
+
+	generateFilename := (filename == "")
+
+	caption := description
+	if len(caption) > maxCaptionLength {
+		caption = caption[:maxCaptionLength-3] + "..."
+	}
+
+	files := make([]uploadFile, len(imgs))
+	for i := range imgs {
+		if generateFilename {
+			filename = fmt.Sprintf("sd-image-%d-%d-%d.%s", firstImageID, e.TaskID, i, fileExt)
+		}
+		f := uploadFile{idx: i, filename: filename, data: imgs[i], asDocument: output.AsDocument}
+		if i < len(spoilers) && spoilers[i] {
+			// Documents can't be spoilers.
+			f.asDocument = false
+			f.spoiler = true
+		}
+		if !f.asDocument {
+			fitPhoto(&f)
+		}
+		files[i] = f
+	}
+
+	sentMsgs := make([]*models.Message, len(imgs))
+	send := func(files []uploadFile) error {
+		msgs, err := e.sendFiles(ctx, files, caption, retryAllowed)
+		if err != nil {
+			return err
+		}
+		for i := range min(len(msgs), len(files)) {
+			sentMsgs[files[i].idx] = msgs[i]
+		}
+		caption = ""
+		return nil
+	}
+
+	// Files are sent in their original order. Media groups can't mix photos and documents, and
+	// their items can't have spoilers with the used bot library version, so spoiler photos are
+	// sent one by one between the groups.
+	for len(files) > 0 {
+		n := 1
+		for n < min(len(files), maxMediaGroupSize) && !files[0].spoiler && !files[n].spoiler &&
+			files[n].asDocument == files[0].asDocument {
+			n++
+		}
+		if err := send(files[:n]); err != nil {
+			return nil, err
+		}
+		files = files[n:]
+	}
+	return sentMsgs, nil
+}
@@ -0,0 +1,139 @@
+package reqqueue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram/telegramtest"
+)
+
+func newTestUploadEntry() (*ReqQueueEntry, *telegramtest.FakeBot) {
+	tgBot := telegramtest.NewFakeBot(nil)
+	return &ReqQueueEntry{
+		bot:     tgBot,
+		Message: &models.Message{ID: 1, Chat: models.Chat{ID: 1}},
+	}, tgBot
+}
+
+func mediaCaption(m models.InputMedia) string {
+	switch m := m.(type) {
+	case *models.InputMediaPhoto:
+		return m.Caption
+	case *models.InputMediaDocument:
+		return m.Caption
+	}
+	return ""
+}
+
+func TestUploadImagesChunks(t *testing.T) {
+	e, tgBot := newTestUploadEntry()
+
+	var imgs [][]byte
+	for i := 0; i < 21; i++ {
+		imgs = append(imgs, sdapitest.GeneratePNG(8, 8, uint32(i)))
+	}
+	if _, err := e.uploadImages(context.Background(), 1, "caption", imgs, "", true, reqparams.ReqParamsOutput{Format: "png", AsDocument: true}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	groups := tgBot.Calls("SendMediaGroup")
+	docs := tgBot.Calls("SendDocument")
+	if len(groups) != 2 || len(groups[0].Media) != 10 || len(groups[1].Media) != 10 || len(docs) != 1 {
+		t.Fatalf("got %d media groups and %d documents", len(groups), len(docs))
+	}
+	if mediaCaption(groups[0].Media[0]) != "caption" || mediaCaption(groups[0].Media[1]) != "" ||
+		mediaCaption(groups[1].Media[0]) != "" || docs[0].Text != "" {
+		t.Error("caption is not only on the first message")
+	}
+}
+
+func TestUploadImagesSpoilerOrder(t *testing.T) {
+	e, tgBot := newTestUploadEntry()
+
+	var imgs [][]byte
+	for i := 0; i < 4; i++ {
+		imgs = append(imgs, sdapitest.GeneratePNG(8, 8, uint32(i)))
+	}
+	sentMsgs, err := e.uploadImages(context.Background(), 1, "caption", imgs, "", true, reqparams.ReqParamsOutput{Format: "png"}, []bool{false, true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := tgBot.Calls("")
+	if len(calls) != 3 || calls[0].Method != "SendPhoto" || calls[1].Method != "SendPhoto" || calls[2].Method != "SendMediaGroup" {
+		t.Fatalf("got calls %+v", calls)
+	}
+	if calls[0].Text != "caption" || calls[0].Spoiler || !calls[1].Spoiler || calls[1].Text != "" || mediaCaption(calls[2].Media[0]) != "" {
+		t.Errorf("got calls %+v", calls)
+	}
+	for i, media := range append(calls[0].Media, append(calls[1].Media, calls[2].Media...)...) {
+		if expected := fmt.Sprintf("attach://sd-image-1-%d-%d.png", e.TaskID, i); media.(*models.InputMediaPhoto).Media != expected {
+			t.Errorf("got photo %s, expected %s", media.(*models.InputMediaPhoto).Media, expected)
+		}
+	}
+	for i, msg := range sentMsgs {
+		if msg == nil {
+			t.Errorf("no message for image %d", i)
+		}
+	}
+}
+
+func TestUploadImagesOversizedPhoto(t *testing.T) {
+	e, tgBot := newTestUploadEntry()
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 6000, 4100)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.uploadImages(context.Background(), 1, "caption", [][]byte{buf.Bytes()}, "", true, reqparams.ReqParamsOutput{Format: "jpg"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	photos := tgBot.Calls("SendPhoto")
+	if len(photos) != 1 || photos[0].Text != "caption" {
+		t.Fatalf("got calls %+v", tgBot.Calls(""))
+	}
+	photo := photos[0].Media[0].(*models.InputMediaPhoto)
+	cfg, _, err := image.DecodeConfig(photo.MediaAttachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width+cfg.Height > maxPhotoDimensionsSum {
+		t.Errorf("got %dx%d photo, expected downscaled", cfg.Width, cfg.Height)
+	}
+
+	// Downscaled photos are JPEGs, so the extension of other formats is changed.
+	buf.Reset()
+	if err = png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 6000, 4100))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = e.uploadImages(context.Background(), 1, "caption", [][]byte{buf.Bytes()}, "", true, reqparams.ReqParamsOutput{Format: "png"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	photos = tgBot.Calls("SendPhoto")
+	if len(photos) != 2 {
+		t.Fatalf("got calls %+v", tgBot.Calls(""))
+	}
+	photo = photos[1].Media[0].(*models.InputMediaPhoto)
+	if expected := fmt.Sprintf("attach://sd-image-1-%d-0.jpg", e.TaskID); photo.Media != expected {
+		t.Errorf("got photo %s, expected %s", photo.Media, expected)
+	}
+	if _, format, err := image.DecodeConfig(photo.MediaAttachment); err != nil || format != "jpeg" {
+		t.Errorf("got photo format %s, error %v", format, err)
+	}
+
+	// Photos too large in file size are sent as documents.
+	f := uploadFile{data: make([]byte, maxPhotoFileSize+1)}
+	fitPhoto(&f)
+	if !f.asDocument {
+		t.Error("expected document fallback for large file")
+	}
+}
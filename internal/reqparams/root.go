@@ -2,21 +2,89 @@ package reqparams
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 )
 
+type ReqParamsOutput struct {
+	// jpg, png or webp.
+	Format string
+	// JPEG quality, WebP is always lossless.
+	Quality    int
+	AsDocument bool
+}
+
+func (o ReqParamsOutput) FileExt() string {
+	if o.Format == "" {
+		return "jpg"
+	}
+	return o.Format
+}
+
+func (o ReqParamsOutput) String() (res string) {
+	if o.FileExt() != "jpg" {
+		res = "/" + strings.ToUpper(o.Format)
+	}
+	// Only showing the upload mode if it differs from the default for the format.
+	if o.AsDocument && o.Format != "png" {
+		res += "📄"
+	} else if !o.AsDocument && o.Format == "png" {
+		res += "📷"
+	}
+	return
+}
+
 type ReqParamsUpscale struct {
-	OriginalPromptText string
-	Scale              float32
-	Upscaler           string
-	OutputPNG          bool
+	OriginalPromptText   string
+	Scale                float32
+	Upscaler             string
+	Output               ReqParamsOutput
+	Upscaler2            string
+	Upscaler2Visibility  float32
+	TargetWidth          int
+	TargetHeight         int
+	Crop                 bool
+	GFPGANVisibility     float32
+	CodeFormerVisibility float32
+	CodeFormerWeight     float32
+	// Max. size of the upscaled image, not limited if zero.
+	MaxWidth  int
+	MaxHeight int
 }
 
 func (r ReqParamsUpscale) String() string {
-	res := "🔎 " + r.Upscaler + "x" + fmt.Sprint(r.Scale)
-	if r.OutputPNG {
-		res += "/PNG"
+	res := "🔎 " + r.Upscaler
+	if r.Upscaler2 != "" {
+		res += "+" + r.Upscaler2 + "/" + fmt.Sprint(r.Upscaler2Visibility)
 	}
-	return res
+	if r.TargetWidth > 0 {
+		res += fmt.Sprintf(" 🖼%dx%d", r.TargetWidth, r.TargetHeight)
+		if r.Crop {
+			res += "/crop"
+		}
+	} else {
+		res += "x" + fmt.Sprint(r.Scale)
+	}
+	if r.GFPGANVisibility > 0 {
+		res += " 🙂GFPGAN " + fmt.Sprint(r.GFPGANVisibility)
+	}
+	if r.CodeFormerVisibility > 0 {
+		res += " 🙂CodeFormer " + fmt.Sprint(r.CodeFormerVisibility, "/", r.CodeFormerWeight)
+	}
+	return res + r.Output.String()
+}
+
+// Returns the size of the image of the given size upscaled with the params.
+func (r ReqParamsUpscale) UpscaledSize(width, height int) (int, int) {
+	if r.TargetWidth > 0 {
+		return r.TargetWidth, r.TargetHeight
+	}
+	return ScaledSize(width, height, r.Scale)
+}
+
+func ScaledSize(width, height int, scale float32) (int, int) {
+	return int(math.Round(float64(width) * float64(scale))), int(math.Round(float64(height) * float64(scale)))
 }
 
 func (r ReqParamsUpscale) OriginalPrompt() string {
@@ -30,20 +98,44 @@ type ReqParamsRenderHR struct {
 	SecondPassSteps   int
 }
 
+type ReqParamsLoRA struct {
+	Name   string
+	Weight float64
+}
+
+func (l ReqParamsLoRA) String() string {
+	return "<lora:" + l.Name + ":" + strconv.FormatFloat(l.Weight, 'f', -1, 64) + ">"
+}
+
 type ReqParamsRender struct {
 	OriginalPromptText string
-	Prompt             string
-	NegativePrompt     string
-	Seed               uint32
-	Width              int
-	Height             int
-	BatchSize          int
-	Steps              int
-	NumOutputs         int
-	OutputPNG          bool
-	CFGScale           float64
-	SamplerName        string
-	ModelName          string
+	// Prompt after preprocessing (like translation), empty if it was not changed.
+	ProcessedPromptText string
+	Prompt              string
+	NegativePrompt      string
+	Seed                uint32
+	Width               int
+	Height              int
+	BatchSize           int
+	Steps               int
+	NumOutputs          int
+	Output              ReqParamsOutput
+	CFGScale            float64
+	SamplerName         string
+	ModelName           string
+	Refiner             string
+	RefinerSwitchAt     float64
+	LoRAs               []ReqParamsLoRA
+	VAE                 string
+	ClipSkip            int
+	Eta                 float64
+	Scheduler           string
+	RestoreFaces        bool
+	Tiling              bool
+	// Expand the prompt with the LLM before rendering.
+	Enhance bool
+	// Negative prompt as given by the user, without the mandatory negative prompts.
+	UserNegativePrompt string
 
 	Upscale ReqParamsUpscale
 
@@ -56,10 +148,7 @@ func (r ReqParamsRender) String() string {
 		numOutputs = fmt.Sprintf("x%d", r.NumOutputs)
 	}
 
-	var outFormatText string
-	if r.OutputPNG {
-		outFormatText = "/PNG"
-	}
+	outFormatText := r.Output.String()
 
 	res := fmt.Sprintf("🌱<code>%d</code> 👟%d 🕹%.1f 🖼%dx%d%s%s 🔭%s 🧩%s",
 		r.Seed,
@@ -73,6 +162,28 @@ func (r ReqParamsRender) String() string {
 		r.ModelName,
 	)
 
+	if r.Refiner != "" {
+		res += " 🔁" + r.Refiner + "@" + strconv.FormatFloat(r.RefinerSwitchAt, 'f', -1, 64)
+	}
+	if r.Scheduler != "" {
+		res += " 📈" + r.Scheduler
+	}
+	if r.VAE != "" {
+		res += " 🎨" + r.VAE
+	}
+	if r.ClipSkip > 0 {
+		res += fmt.Sprintf(" ✂%d", r.ClipSkip)
+	}
+	if r.Eta > 0 {
+		res += " η" + strconv.FormatFloat(r.Eta, 'f', -1, 64)
+	}
+	if r.RestoreFaces {
+		res += " 🙂"
+	}
+	if r.Tiling {
+		res += " 🧱"
+	}
+
 	if r.HR.Scale > 0 {
 		res += " 🔎 " + r.HR.Upscaler + "x" + fmt.Sprint(r.HR.Scale, "/", r.HR.DenoisingStrength)
 	} else if r.Upscale.Scale > 0 {
@@ -89,7 +200,19 @@ func (r ReqParamsRender) String() string {
 	return res
 }
 
+// Returns the prompt with the LoRAs set by attributes appended to it.
+func (r ReqParamsRender) PromptWithLoRAs() string {
+	res := r.Prompt
+	for _, l := range r.LoRAs {
+		res += " " + l.String()
+	}
+	return res
+}
+
 func (r ReqParamsRender) OriginalPrompt() string {
+	if r.ProcessedPromptText != "" {
+		return r.OriginalPromptText + "\n📝 " + r.ProcessedPromptText
+	}
 	return r.OriginalPromptText
 }
 
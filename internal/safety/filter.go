@@ -0,0 +1,162 @@
+package safety
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+const classifyTimeout = 30 * time.Second
+
+const DefaultNSFWThreshold = 0.7
+
+// Filter checks prompts and rendered images against the safety rules.
+type Filter struct {
+	defaultRules rules
+	chatRules    map[int64]*rules
+
+	// HTTP endpoint which gets the image as the request body, and returns a JSON with the NSFW
+	// score (0-1) like {"score": 0.93}. Images are not checked if empty.
+	classifierURL string
+	threshold     float64
+}
+
+// Creates the filter with the rules read from the JSON file at rulesPath (no rules if empty).
+func NewFilter(rulesPath string, classifierURL string, threshold float64) (*Filter, error) {
+	f := &Filter{
+		chatRules:     make(map[int64]*rules),
+		classifierURL: classifierURL,
+		threshold:     threshold,
+		defaultRules:  rules{nsfwAction: ActionSpoiler},
+	}
+	if f.threshold == 0 {
+		f.threshold = DefaultNSFWThreshold
+	}
+	if rulesPath == "" {
+		return f, nil
+	}
+
+	rulesFile, err := readRulesFile(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.defaultRules.add(rulesFile.Default); err != nil {
+		return nil, fmt.Errorf("default safety rules: %w", err)
+	}
+	for chatID, c := range rulesFile.Chats {
+		r := f.defaultRules
+		// Not sharing the backing arrays with the default rules, as chat rules are appended.
+		r.blocklist = slices.Clone(r.blocklist)
+		r.allowlist = slices.Clone(r.allowlist)
+		if err = r.add(c); err != nil {
+			return nil, fmt.Errorf("safety rules of chat %d: %w", chatID, err)
+		}
+		f.chatRules[chatID] = &r
+	}
+	return f, nil
+}
+
+func (f *Filter) rules(chatID int64) *rules {
+	if r, ok := f.chatRules[chatID]; ok {
+		return r
+	}
+	return &f.defaultRules
+}
+
+// Returns an error if the prompt contains blocked words.
+func (f *Filter) CheckPrompt(chatID int64, prompt string) error {
+	if m := f.rules(chatID).blockedMatch(prompt); m != "" {
+		fmt.Println("  blocked word in prompt:", m)
+		return fmt.Errorf("blocked word in prompt: %s", m)
+	}
+	return nil
+}
+
+// Returns the negative prompt which should be added to all renders in the chat.
+func (f *Filter) NegativePrompt(chatID int64) string {
+	return f.rules(chatID).negativePrompt
+}
+
+func (f *Filter) NSFWAction(chatID int64) Action {
+	return f.rules(chatID).nsfwAction
+}
+
+func (f *Filter) HasClassifier() bool {
+	return f.classifierURL != ""
+}
+
+func (f *Filter) classify(ctx context.Context, img []byte) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, classifyTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "POST", f.classifierURL, bytes.NewReader(img))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", http.DetectContentType(img))
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("classifier request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("classifier status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	var res struct {
+		Score *float64 `json:"score"`
+	}
+	if err = json.Unmarshal(body, &res); err != nil || res.Score == nil {
+		return 0, fmt.Errorf("classifier response parse error: %s", string(body))
+	}
+	return *res.Score, nil
+}
+
+// Returns which images are flagged as NSFW by the classifier. Images are flagged if they
+// can't be classified, so nothing gets through if the classifier is down.
+func (f *Filter) FlagImages(ctx context.Context, imgs [][]byte) (flagged []bool, flaggedCnt int) {
+	flagged = make([]bool, len(imgs))
+	for i := range imgs {
+		score, err := f.classify(ctx, imgs[i])
+		if err != nil {
+			fmt.Println("  nsfw classify error:", err)
+		}
+		if err != nil || score >= f.threshold {
+			flagged[i] = true
+			flaggedCnt++
+		}
+	}
+	return
+}
+
+// Returns the image blurred beyond recognition, encoded as PNG.
+func Blur(img []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(img))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	// Scaling down to a few pixels and back up with bilinear interpolation gives a smooth blur.
+	small := image.NewRGBA(image.Rect(0, 0, max(b.Dx()/32, 1), max(b.Dy()/32, 1)))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), src, b, draw.Src, nil)
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.BiLinear.Scale(dst, dst.Bounds(), small, small.Bounds(), draw.Src, nil)
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,118 @@
+package safety
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+)
+
+const testRules = `{
+	"default": {"blocklist": ["nude", "/gor(e|y)/"], "allowlist": ["nudelman"], "negative_prompt": "nsfw"},
+	"chats": {"-100": {"blocklist": ["blood"], "negative_prompt": "violence", "nsfw_action": "withhold"}}
+}`
+
+func newTestFilter(t *testing.T, rules string, classifierURL string) (*Filter, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return NewFilter(path, classifierURL, 0)
+}
+
+func TestFilterCheckPrompt(t *testing.T) {
+	f, err := newTestFilter(t, testRules, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		chatID  int64
+		prompt  string
+		blocked bool
+	}{
+		{1, "a cat", false},
+		{1, "a NUDE cat", true},
+		{1, "a nudelman cat", false},
+		{1, "nudes", false},
+		{1, "gory scene", true},
+		{1, "blood moon", false},
+		{-100, "blood moon", true},
+		{-100, "gore", true},
+	} {
+		if err = f.CheckPrompt(tc.chatID, tc.prompt); (err != nil) != tc.blocked {
+			t.Errorf("got error %v for %q in chat %d", err, tc.prompt, tc.chatID)
+		}
+	}
+
+	if f.NegativePrompt(1) != "nsfw" || f.NegativePrompt(-100) != "nsfw, violence" {
+		t.Errorf("got negative prompts %q and %q", f.NegativePrompt(1), f.NegativePrompt(-100))
+	}
+	if f.NSFWAction(1) != ActionSpoiler || f.NSFWAction(-100) != ActionWithhold {
+		t.Errorf("got nsfw actions %q and %q", f.NSFWAction(1), f.NSFWAction(-100))
+	}
+}
+
+func TestFilterInvalidRules(t *testing.T) {
+	for rules, expectedErrStr := range map[string]string{
+		`{"default": {"blocklist": ["/(/"]}}`:         "invalid pattern",
+		`{"chats": {"1": {"nsfw_action": "delete"}}}`: "invalid nsfw action",
+		`{"default": []}`:                             "can't parse",
+	} {
+		if _, err := newTestFilter(t, rules, ""); err == nil || !strings.Contains(err.Error(), expectedErrStr) {
+			t.Errorf("got error %v for %s, expected %q", err, rules, expectedErrStr)
+		}
+	}
+}
+
+func TestFilterFlagImages(t *testing.T) {
+	var mutex sync.Mutex
+	var scores = []string{`{"score": 0.9}`, `{"score": 0.1}`, `invalid`}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get("Content-Type") != "image/png" {
+			http.Error(w, "invalid content type", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, scores[0])
+		scores = scores[1:]
+	}))
+	t.Cleanup(srv.Close)
+
+	f, err := NewFilter("", srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := sdapitest.GeneratePNG(8, 8, 1)
+	flagged, flaggedCnt := f.FlagImages(context.Background(), [][]byte{img, img, img})
+	// Images which can't be classified are flagged.
+	if flaggedCnt != 2 || !flagged[0] || flagged[1] || !flagged[2] {
+		t.Errorf("got flagged %v", flagged)
+	}
+}
+
+func TestBlur(t *testing.T) {
+	blurred, err := Blur(sdapitest.GeneratePNG(64, 48, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(blurred))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Errorf("got blurred image bounds %v", img.Bounds())
+	}
+}
@@ -0,0 +1,119 @@
+package safety
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Action is what's done with images flagged by the NSFW classifier.
+type Action string
+
+const (
+	// Send flagged images as spoiler photos.
+	ActionSpoiler Action = "spoiler"
+	// Blur flagged images.
+	ActionBlur Action = "blur"
+	// Don't send flagged images, and notify admins.
+	ActionWithhold Action = "withhold"
+)
+
+// ChatRules are the safety rules of a chat, as read from the rules file.
+type ChatRules struct {
+	// Words or regexes (enclosed in slashes, like /nud(e|ity)/) which are not allowed in prompts.
+	Blocklist []string `json:"blocklist"`
+	// Words or regexes which are removed from the prompt before checking the blocklist, so
+	// "nudelman" can be allowed while "nude" is blocked.
+	Allowlist []string `json:"allowlist"`
+	// Appended to the negative prompt of all renders.
+	NegativePrompt string `json:"negative_prompt"`
+	NSFWAction     Action `json:"nsfw_action"`
+}
+
+// RulesFile is the format of the safety rules JSON file. Chat rules are added to the
+// default rules.
+type RulesFile struct {
+	Default ChatRules           `json:"default"`
+	Chats   map[int64]ChatRules `json:"chats"`
+}
+
+type rules struct {
+	blocklist      []*regexp.Regexp
+	allowlist      []*regexp.Regexp
+	negativePrompt string
+	nsfwAction     Action
+}
+
+// Words are matched case-insensitively as whole words, regexes are matched case-insensitively.
+func compilePattern(s string) (*regexp.Regexp, error) {
+	if len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		return regexp.Compile("(?i)" + s[1:len(s)-1])
+	}
+	return regexp.Compile(`(?i)\b` + regexp.QuoteMeta(s) + `\b`)
+}
+
+func compilePatterns(patterns []string) (res []*regexp.Regexp, err error) {
+	for _, p := range patterns {
+		r, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func (r *rules) add(c ChatRules) error {
+	blocklist, err := compilePatterns(c.Blocklist)
+	if err != nil {
+		return err
+	}
+	allowlist, err := compilePatterns(c.Allowlist)
+	if err != nil {
+		return err
+	}
+	r.blocklist = append(r.blocklist, blocklist...)
+	r.allowlist = append(r.allowlist, allowlist...)
+
+	if c.NegativePrompt != "" {
+		if r.negativePrompt != "" {
+			r.negativePrompt += ", "
+		}
+		r.negativePrompt += c.NegativePrompt
+	}
+
+	switch c.NSFWAction {
+	case "":
+	case ActionSpoiler, ActionBlur, ActionWithhold:
+		r.nsfwAction = c.NSFWAction
+	default:
+		return fmt.Errorf("invalid nsfw action %q, valid values are spoiler, blur and withhold", c.NSFWAction)
+	}
+	return nil
+}
+
+// Returns the first blocked match in the prompt, or an empty string.
+func (r *rules) blockedMatch(prompt string) string {
+	for _, a := range r.allowlist {
+		prompt = a.ReplaceAllString(prompt, " ")
+	}
+	for _, b := range r.blocklist {
+		if m := b.FindString(prompt); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+func readRulesFile(path string) (f RulesFile, err error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("can't read safety rules: %w", err)
+	}
+	if err = json.Unmarshal(d, &f); err != nil {
+		return f, fmt.Errorf("can't parse safety rules: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,31 @@
+package sdapi
+
+import (
+	"context"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+// Backend is a generation backend which renders and upscales images. SdAPIType implements it
+// for the AUTOMATIC1111 API.
+type Backend interface {
+	Render(ctx context.Context, p reqparams.ReqParams, imageData []byte) (imgs [][]byte, err error)
+	Upscale(ctx context.Context, p reqparams.ReqParams, imageData []byte) (imgs [][]byte, err error)
+	GetProgress(ctx context.Context) (progressPercent int, eta time.Duration, err error)
+	Interrupt(ctx context.Context) error
+
+	GetModels(ctx context.Context) (models []string, err error)
+	GetModelInfos(ctx context.Context) (models []ModelInfo, err error)
+	GetSamplers(ctx context.Context) (samplers []string, err error)
+	GetSchedulers(ctx context.Context) (schedulers []string, err error)
+	GetUpscalers(ctx context.Context) (upscalers []string, err error)
+	GetEmbeddings(ctx context.Context) (embs []string, err error)
+	GetLoRAs(ctx context.Context) (loras []string, err error)
+	GetLoRAInfos(ctx context.Context) (loras []LoRAInfo, err error)
+	GetVAEs(ctx context.Context) (vaes []string, err error)
+
+	Capabilities() Capabilities
+}
+
+var _ Backend = (*SdAPIType)(nil)
@@ -0,0 +1,243 @@
+package sdapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+const (
+	FlavorAUTOMATIC1111 = "AUTOMATIC1111"
+	FlavorForge         = "Forge"
+	FlavorSDNext        = "SD.Next"
+	FlavorComfyUI       = "ComfyUI"
+)
+
+// Forge versions look like "f2.0.1v1.10.1-previous-...".
+var forgeVersionRegex = regexp.MustCompile(`^f\d+\.\d+`)
+
+var loraTagRegex = regexp.MustCompile(`<(?:lora|lyco):[^>]*>`)
+
+// Capabilities describe the optional features supported by a backend.
+type Capabilities struct {
+	Flavor  string
+	Version string
+
+	// Separate scheduler field, older versions have it baked into the sampler name.
+	Scheduler bool
+	// Separate sampler and prompts for the highres fix second pass.
+	HRSampler bool
+	Refiner   bool
+	HR        bool
+	LoRAs     bool
+	// <lora:...> tags in the prompt.
+	LoRATags        bool
+	VAE             bool
+	ClipSkip        bool
+	Eta             bool
+	RestoreFaces    bool
+	Tiling          bool
+	Upscaler2       bool
+	UpscaleFaceRest bool
+}
+
+// Returns the capabilities of the latest AUTOMATIC1111 version, which are used until detection
+// succeeds.
+func DefaultCapabilities() Capabilities {
+	return Capabilities{
+		Flavor:          FlavorAUTOMATIC1111,
+		Scheduler:       true,
+		HRSampler:       true,
+		Refiner:         true,
+		HR:              true,
+		LoRAs:           true,
+		LoRATags:        true,
+		VAE:             true,
+		ClipSkip:        true,
+		Eta:             true,
+		RestoreFaces:    true,
+		Tiling:          true,
+		Upscaler2:       true,
+		UpscaleFaceRest: true,
+	}
+}
+
+func (c Capabilities) String() string {
+	var features []string
+	for _, f := range []struct {
+		name      string
+		supported bool
+	}{
+		{"scheduler", c.Scheduler},
+		{"hr-sampler", c.HRSampler},
+		{"refiner", c.Refiner},
+		{"hr", c.HR},
+		{"lora", c.LoRAs},
+		{"lora-tags", c.LoRATags},
+		{"vae", c.VAE},
+		{"clipskip", c.ClipSkip},
+		{"eta", c.Eta},
+		{"restorefaces", c.RestoreFaces},
+		{"tiling", c.Tiling},
+		{"upscaler2", c.Upscaler2},
+		{"upscale-face-restoration", c.UpscaleFaceRest},
+	} {
+		if f.supported {
+			features = append(features, f.name)
+		}
+	}
+	res := c.Flavor
+	if c.Version != "" {
+		res += " " + c.Version
+	}
+	return res + " (" + strings.Join(features, ", ") + ")"
+}
+
+func (c Capabilities) NotSupportedError(features ...string) error {
+	return i18n.Errorf(i18n.NotSupported, c.Flavor, strings.Join(features, ", "))
+}
+
+func (c Capabilities) CheckRender(params reqparams.ReqParamsRender) error {
+	var unsupported []string
+	if params.HR.Scale > 0 && !c.HR {
+		unsupported = append(unsupported, "highres mode")
+	}
+	if params.Refiner != "" && !c.Refiner {
+		unsupported = append(unsupported, "-refiner")
+	}
+	if len(params.LoRAs) > 0 && !c.LoRAs {
+		unsupported = append(unsupported, "-lora")
+	}
+	if !c.LoRATags && (loraTagRegex.MatchString(params.Prompt) || loraTagRegex.MatchString(params.NegativePrompt)) {
+		unsupported = append(unsupported, "&lt;lora:...&gt; tags")
+	}
+	if params.VAE != "" && !c.VAE {
+		unsupported = append(unsupported, "-vae")
+	}
+	if params.ClipSkip > 0 && !c.ClipSkip {
+		unsupported = append(unsupported, "-clipskip")
+	}
+	if params.Eta > 0 && !c.Eta {
+		unsupported = append(unsupported, "-eta")
+	}
+	if params.Scheduler != "" && !c.Scheduler {
+		unsupported = append(unsupported, "-scheduler")
+	}
+	if params.RestoreFaces && !c.RestoreFaces {
+		unsupported = append(unsupported, "-restorefaces")
+	}
+	if params.Tiling && !c.Tiling {
+		unsupported = append(unsupported, "-tiling")
+	}
+	if len(unsupported) > 0 {
+		return c.NotSupportedError(unsupported...)
+	}
+	return nil
+}
+
+func (c Capabilities) CheckUpscale(params reqparams.ReqParamsUpscale) error {
+	var unsupported []string
+	if params.Upscaler2 != "" && !c.Upscaler2 {
+		unsupported = append(unsupported, "-upscaler2")
+	}
+	if (params.GFPGANVisibility > 0 || params.CodeFormerVisibility > 0) && !c.UpscaleFaceRest {
+		unsupported = append(unsupported, "face restoration")
+	}
+	if len(unsupported) > 0 {
+		return c.NotSupportedError(unsupported...)
+	}
+	return nil
+}
+
+// Probes the API to find out which fork is running and which features it supports. The
+// detected capabilities are stored and used by Render.
+func (a *SdAPIType) DetectCapabilities(ctx context.Context) (Capabilities, error) {
+	res, err := a.req(ctx, "/options", "", nil)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("getting options: %w", err)
+	}
+	var options map[string]json.RawMessage
+	if err = json.Unmarshal([]byte(res), &options); err != nil {
+		return Capabilities{}, fmt.Errorf("getting options: %w", err)
+	}
+
+	c := DefaultCapabilities()
+
+	// SD.Next has no sysinfo endpoint, so errors are ignored here.
+	var sysInfo struct {
+		Version string `json:"Version"`
+	}
+	if res, err = a.hostReq(ctx, "/internal/sysinfo", "", nil); err == nil && json.Unmarshal([]byte(res), &sysInfo) == nil {
+		c.Version = sysInfo.Version
+	}
+
+	_, hasSDBackend := options["sd_backend"]
+	_, hasDiffusersPipeline := options["diffusers_pipeline"]
+	switch {
+	case hasSDBackend || hasDiffusersPipeline:
+		c.Flavor = FlavorSDNext
+	case forgeVersionRegex.MatchString(c.Version):
+		c.Flavor = FlavorForge
+	}
+
+	_, c.HRSampler = options["hires_fix_show_sampler"]
+	_, c.VAE = options["sd_vae"]
+	_, c.ClipSkip = options["CLIP_stop_at_last_layers"]
+	_, c.RestoreFaces = options["face_restoration_model"]
+	c.UpscaleFaceRest = c.RestoreFaces
+
+	var schedulers []json.RawMessage
+	res, err = a.req(ctx, "/schedulers", "", nil)
+	c.Scheduler = err == nil && json.Unmarshal([]byte(res), &schedulers) == nil
+
+	// The refiner is assumed to be missing if the scripts can't be listed.
+	var scripts struct {
+		Txt2Img []string `json:"txt2img"`
+	}
+	if res, err = a.req(ctx, "/scripts", "", nil); err == nil {
+		err = json.Unmarshal([]byte(res), &scripts)
+	}
+	if err != nil {
+		fmt.Println("  error getting scripts, disabling the refiner:", err)
+	}
+	c.Refiner = slices.Contains(scripts.Txt2Img, "refiner")
+
+	a.capabilitiesMutex.Lock()
+	a.capabilities = &c
+	a.capabilitiesMutex.Unlock()
+	return c, nil
+}
+
+// Returns the detected capabilities, detecting them first if it failed at startup, for example
+// because the API was not running yet. Returns the defaults if detection fails again.
+func (a *SdAPIType) detectedCapabilities(ctx context.Context) Capabilities {
+	a.capabilitiesMutex.Lock()
+	detected := a.capabilities
+	a.capabilitiesMutex.Unlock()
+	if detected != nil {
+		return *detected
+	}
+	caps, err := a.DetectCapabilities(ctx)
+	if err != nil {
+		fmt.Println("  can't detect api capabilities, assuming latest AUTOMATIC1111:", err)
+		return DefaultCapabilities()
+	}
+	fmt.Println("  detected api", caps)
+	return caps
+}
+
+// Returns the detected capabilities, or the defaults if detection has not been done yet.
+func (a *SdAPIType) Capabilities() Capabilities {
+	a.capabilitiesMutex.Lock()
+	defer a.capabilitiesMutex.Unlock()
+	if a.capabilities == nil {
+		return DefaultCapabilities()
+	}
+	return *a.capabilities
+}
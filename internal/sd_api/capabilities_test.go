@@ -0,0 +1,125 @@
+package sdapi
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+func TestDetectCapabilities(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	caps, err := api.DetectCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultCapabilities()
+	expected.Version = "v1.10.1"
+	if caps != expected {
+		t.Errorf("got %s, expected %s", caps, expected)
+	}
+	if api.Capabilities() != caps {
+		t.Error("detected capabilities are not stored")
+	}
+}
+
+func TestDetectCapabilitiesForks(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Version = "f2.0.1v1.10.1-previous-224-g900196889"
+	delete(srv.Options, "face_restoration_model")
+
+	caps, err := api.DetectCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Flavor != FlavorForge || caps.RestoreFaces || caps.UpscaleFaceRest {
+		t.Errorf("got %s", caps)
+	}
+
+	srv.Options["sd_backend"] = "diffusers"
+	if caps, err = api.DetectCapabilities(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if caps.Flavor != FlavorSDNext {
+		t.Errorf("got flavor %s, expected %s", caps.Flavor, FlavorSDNext)
+	}
+}
+
+func TestDetectCapabilitiesWithoutScripts(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.FailNext("/sdapi/v1/scripts", 1, 500, `{"error": "RuntimeError"}`)
+
+	caps, err := api.DetectCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DefaultCapabilities()
+	expected.Version = "v1.10.1"
+	expected.Refiner = false
+	if caps != expected {
+		t.Errorf("got %s, expected %s", caps, expected)
+	}
+}
+
+func TestCapabilitiesDetectedOnFirstRender(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Scripts = nil
+
+	params := reqparams.ReqParamsRender{Prompt: "cat", Width: 64, Height: 64, BatchSize: 1, NumOutputs: 1, SamplerName: "Euler a"}
+	for range 2 {
+		if _, err := api.Render(context.Background(), params, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(srv.Requests("/sdapi/v1/options")); n != 1 {
+		t.Errorf("got %d options requests, expected 1", n)
+	}
+	if caps := api.Capabilities(); caps.Version != "v1.10.1" || caps.Refiner {
+		t.Errorf("got %s", caps)
+	}
+}
+
+func TestRenderAdaptsToCapabilities(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Schedulers = nil
+	srv.Scripts = nil
+	delete(srv.Options, "hires_fix_show_sampler")
+
+	caps, err := api.DetectCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Scheduler || caps.Refiner || caps.HRSampler {
+		t.Errorf("got %s", caps)
+	}
+
+	params := reqparams.ReqParamsRender{
+		Prompt:      "cat",
+		Width:       64,
+		Height:      64,
+		BatchSize:   1,
+		NumOutputs:  1,
+		SamplerName: "Euler a",
+		HR:          reqparams.ReqParamsRenderHR{Scale: 2, Upscaler: "Lanczos"},
+	}
+	if _, err = api.Render(context.Background(), params, nil); err != nil {
+		t.Fatal(err)
+	}
+	var req map[string]any
+	if err = srv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"hr_sampler_name", "hr_prompt", "scheduler", "refiner_checkpoint"} {
+		if _, ok := req[field]; ok {
+			t.Errorf("unsupported field %s sent", field)
+		}
+	}
+
+	params.Scheduler = "Karras"
+	_, err = api.Render(context.Background(), params, nil)
+	if err == nil || !strings.Contains(err.Error(), "not supported by the AUTOMATIC1111 backend: -scheduler") {
+		t.Errorf("got error %v", err)
+	}
+}
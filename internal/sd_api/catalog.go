@@ -0,0 +1,173 @@
+package sdapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Refresher is implemented by backends which can rescan their model and LoRA directories.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+// ErrRefreshNotSupported is returned by Catalog.Refresh if the backend is not a Refresher.
+var ErrRefreshNotSupported = errors.New("refreshing lists is not supported by the backend")
+
+// Catalog caches the model, sampler, scheduler, upscaler, VAE, embedding and LoRA lists of a
+// backend for TTL. Expired lists are still returned while they are refetched in the background,
+// so requests can be validated while the backend is slow to respond during long renders.
+type Catalog struct {
+	Backend
+	TTL time.Duration
+
+	mutex sync.Mutex
+	lists map[string]catalogEntry
+	// Running fetches by list name, callers of the same list share one fetch.
+	fetches map[string]*catalogFetch
+	// Incremented by Refresh, so fetches started before it don't store their lists.
+	generation int
+}
+
+type catalogEntry struct {
+	value     any
+	fetchedAt time.Time
+}
+
+type catalogFetch struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// Maximum time of fetching a list, fetches are not bound to the context of the caller as they
+// are shared.
+const catalogFetchTimeout = 30 * time.Second
+
+var _ Backend = (*Catalog)(nil)
+var _ Refresher = (*Catalog)(nil)
+
+func NewCatalog(backend Backend, ttl time.Duration) *Catalog {
+	return &Catalog{
+		Backend: backend,
+		TTL:     ttl,
+		lists:   make(map[string]catalogEntry),
+		fetches: make(map[string]*catalogFetch),
+	}
+}
+
+// Starts fetching the list with the given name if it's not being fetched already. Must be called
+// with the mutex held.
+func startFetch[E any](c *Catalog, name string, fetch func(ctx context.Context) ([]E, error)) *catalogFetch {
+	if f, ok := c.fetches[name]; ok {
+		return f
+	}
+	f := &catalogFetch{done: make(chan struct{})}
+	c.fetches[name] = f
+	generation := c.generation
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), catalogFetchTimeout)
+		defer cancel()
+		value, err := fetch(ctx)
+
+		c.mutex.Lock()
+		if c.fetches[name] == f {
+			delete(c.fetches, name)
+		}
+		if err == nil && c.generation == generation {
+			c.lists[name] = catalogEntry{value: value, fetchedAt: time.Now()}
+		}
+		c.mutex.Unlock()
+		if err != nil {
+			fmt.Println("  can't fetch", name, "list:", err)
+		}
+
+		f.value, f.err = value, err
+		close(f.done)
+	}()
+	return f
+}
+
+// Returns a copy of the cached list with the given name. Expired lists are returned as they are
+// and refetched in the background, missing lists are fetched.
+func cached[E any](c *Catalog, ctx context.Context, name string, fetch func(ctx context.Context) ([]E, error)) ([]E, error) {
+	c.mutex.Lock()
+	entry, ok := c.lists[name]
+	if ok && time.Since(entry.fetchedAt) < c.TTL {
+		c.mutex.Unlock()
+		return slices.Clone(entry.value.([]E)), nil
+	}
+	f := startFetch(c, name, fetch)
+	c.mutex.Unlock()
+
+	if ok {
+		return slices.Clone(entry.value.([]E)), nil
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return slices.Clone(f.value.([]E)), nil
+}
+
+func (c *Catalog) GetModels(ctx context.Context) (models []string, err error) {
+	return cached(c, ctx, "models", c.Backend.GetModels)
+}
+
+func (c *Catalog) GetModelInfos(ctx context.Context) (models []ModelInfo, err error) {
+	return cached(c, ctx, "model infos", c.Backend.GetModelInfos)
+}
+
+func (c *Catalog) GetSamplers(ctx context.Context) (samplers []string, err error) {
+	return cached(c, ctx, "samplers", c.Backend.GetSamplers)
+}
+
+func (c *Catalog) GetSchedulers(ctx context.Context) (schedulers []string, err error) {
+	return cached(c, ctx, "schedulers", c.Backend.GetSchedulers)
+}
+
+func (c *Catalog) GetUpscalers(ctx context.Context) (upscalers []string, err error) {
+	return cached(c, ctx, "upscalers", c.Backend.GetUpscalers)
+}
+
+func (c *Catalog) GetEmbeddings(ctx context.Context) (embs []string, err error) {
+	return cached(c, ctx, "embeddings", c.Backend.GetEmbeddings)
+}
+
+func (c *Catalog) GetLoRAs(ctx context.Context) (loras []string, err error) {
+	return cached(c, ctx, "loras", c.Backend.GetLoRAs)
+}
+
+func (c *Catalog) GetLoRAInfos(ctx context.Context) (loras []LoRAInfo, err error) {
+	return cached(c, ctx, "lora infos", c.Backend.GetLoRAInfos)
+}
+
+func (c *Catalog) GetVAEs(ctx context.Context) (vaes []string, err error) {
+	return cached(c, ctx, "vaes", c.Backend.GetVAEs)
+}
+
+// Makes the backend rescan its models and LoRAs, and drops all cached lists. Returns
+// ErrRefreshNotSupported if the backend can't rescan them.
+func (c *Catalog) Refresh(ctx context.Context) error {
+	r, ok := c.Backend.(Refresher)
+	if !ok {
+		return ErrRefreshNotSupported
+	}
+	if err := r.Refresh(ctx); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.lists = make(map[string]catalogEntry)
+	c.fetches = make(map[string]*catalogFetch)
+	c.generation++
+	c.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,136 @@
+package sdapi
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCatalog(t *testing.T) {
+	api, srv := newTestAPI(t)
+	c := NewCatalog(api, time.Hour)
+	ctx := context.Background()
+
+	for range 3 {
+		models, err := c.GetModels(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(models, srv.Models) {
+			t.Errorf("got models %v", models)
+		}
+		// Callers can modify the returned lists.
+		models[0] = "changed"
+	}
+	if n := len(srv.Requests("/sdapi/v1/sd-models")); n != 1 {
+		t.Errorf("got %d model requests, expected 1", n)
+	}
+
+	if _, err := c.GetUpscalers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetUpscalers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Requests("/sdapi/v1/upscalers")); n != 1 {
+		t.Errorf("got %d upscaler requests, expected 1", n)
+	}
+
+	srv.Models = append(srv.Models, "new-model")
+	if err := c.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/sdapi/v1/refresh-checkpoints", "/sdapi/v1/refresh-loras"} {
+		if n := len(srv.Requests(path)); n != 1 {
+			t.Errorf("got %d requests to %s, expected 1", n, path)
+		}
+	}
+	if models, err := c.GetModels(ctx); err != nil || !slices.Contains(models, "new-model") {
+		t.Errorf("got models %v after refresh, error %v", models, err)
+	}
+}
+
+func TestCatalogExpiry(t *testing.T) {
+	api, srv := newTestAPI(t)
+	c := NewCatalog(api, time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := c.GetSamplers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	srv.Samplers = []string{"Euler"}
+	// The expired list is returned while the new one is fetched in the background.
+	if samplers, err := c.GetSamplers(ctx); err != nil || len(samplers) != 3 {
+		t.Errorf("got samplers %v after expiry, error %v", samplers, err)
+	}
+	waitFor(t, "the refetched samplers", func() bool {
+		samplers, err := c.GetSamplers(ctx)
+		return err == nil && slices.Equal(samplers, []string{"Euler"})
+	})
+
+	// Expired lists are used if the backend fails.
+	time.Sleep(5 * time.Millisecond)
+	srv.FailNext("/sdapi/v1/samplers", 1, 500, `{"error":"busy"}`)
+	if samplers, err := c.GetSamplers(ctx); err != nil || !slices.Equal(samplers, []string{"Euler"}) {
+		t.Errorf("got samplers %v while the backend fails, error %v", samplers, err)
+	}
+
+	srv.FailNext("/sdapi/v1/sd-vae", 1, 500, `{"error":"busy"}`)
+	if _, err := c.GetVAEs(ctx); err == nil {
+		t.Error("expected error without a cached list")
+	}
+}
+
+func TestCatalogSlowBackend(t *testing.T) {
+	api, srv := newTestAPI(t)
+	c := NewCatalog(api, time.Millisecond)
+
+	if _, err := c.GetModels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Expired lists are returned right away while the backend is busy, and only one refetch is
+	// started.
+	release := srv.Block("/sdapi/v1/sd-models")
+	defer release()
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		models, err := c.GetModels(ctx)
+		cancel()
+		if err != nil || !slices.Equal(models, []string{"v1-5-pruned-emaonly", "sd_xl_base_1.0"}) {
+			t.Fatalf("got models %v while the backend is slow, error %v", models, err)
+		}
+	}
+	waitFor(t, "the model refetch request", func() bool { return len(srv.Requests("/sdapi/v1/sd-models")) >= 2 })
+	if _, err := c.GetModels(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Requests("/sdapi/v1/sd-models")); n != 2 {
+		t.Errorf("got %d model requests, expected 2", n)
+	}
+	srv.Models = []string{"new-model"}
+	release()
+	waitFor(t, "the refetched models", func() bool {
+		models, err := c.GetModels(context.Background())
+		return err == nil && slices.Equal(models, []string{"new-model"})
+	})
+
+	// Callers of a missing list wait for the shared fetch until their context is done.
+	release = srv.Block("/sdapi/v1/loras")
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetLoRAs(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected deadline exceeded", err)
+	}
+	release()
+	if loras, err := c.GetLoRAs(context.Background()); err != nil || !slices.Equal(loras, srv.LoRAs) {
+		t.Errorf("got LoRAs %v, error %v", loras, err)
+	}
+	if n := len(srv.Requests("/sdapi/v1/loras")); n != 1 {
+		t.Errorf("got %d LoRA requests, expected 1", n)
+	}
+}
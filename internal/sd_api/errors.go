@@ -0,0 +1,194 @@
+package sdapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+)
+
+type ErrorKind int
+
+const (
+	ErrorKindUnknown ErrorKind = iota
+	ErrorKindOOM
+	ErrorKindMissingModel
+	ErrorKindInvalidSampler
+	ErrorKindBusy
+	ErrorKindValidation
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindOOM:
+		return "out of memory"
+	case ErrorKindMissingModel:
+		return "missing model"
+	case ErrorKindInvalidSampler:
+		return "invalid sampler"
+	case ErrorKindBusy:
+		return "busy"
+	case ErrorKindValidation:
+		return "validation"
+	default:
+		return "unknown"
+	}
+}
+
+// APIError is an error returned by the backend API.
+type APIError struct {
+	Kind       ErrorKind
+	StatusCode int
+	// Request method and path, without the host to not leak it to users.
+	Method string
+	Path   string
+	Detail string
+}
+
+func (e *APIError) Error() string {
+	var res string
+	if e.StatusCode > 0 {
+		res = fmt.Sprintf("api status code: %d (%s to %s)", e.StatusCode, e.Method, e.Path)
+	} else {
+		res = "api error"
+	}
+	if e.Detail != "" {
+		res += ": " + e.Detail
+	}
+	return res
+}
+
+// Returns a message for the user with a hint on how to avoid the error. The detail is escaped
+// as messages are sent in HTML.
+func (e *APIError) UserMessage(lang i18n.Lang) string {
+	detail := html.EscapeString(e.Detail)
+	switch e.Kind {
+	case ErrorKindOOM:
+		return lang.T(i18n.APIErrorOOM)
+	case ErrorKindMissingModel:
+		return lang.T(i18n.APIErrorMissingModel)
+	case ErrorKindInvalidSampler:
+		return lang.T(i18n.APIErrorInvalidSampler)
+	case ErrorKindBusy:
+		return lang.T(i18n.APIErrorBusy)
+	case ErrorKindValidation:
+		return lang.T(i18n.APIErrorValidation, detail)
+	}
+	if detail != "" {
+		return lang.T(i18n.APIError, detail)
+	}
+	return lang.T(i18n.APIError, http.StatusText(e.StatusCode))
+}
+
+// Returns the user message for API errors, and the localized error string for other errors.
+func ErrorMessage(err error, lang i18n.Lang) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.UserMessage(lang)
+	}
+	return lang.Error(err)
+}
+
+func ClassifyError(statusCode int, msg string) ErrorKind {
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "out of memory") || strings.Contains(msg, "outofmemory") ||
+		strings.Contains(msg, "allocation on device"):
+		return ErrorKindOOM
+	case strings.Contains(msg, "sampler not found") || strings.Contains(msg, "invalid sampler"):
+		return ErrorKindInvalidSampler
+	case strings.Contains(msg, "model not found") || strings.Contains(msg, "checkpoint not found") ||
+		(strings.Contains(msg, "checkpoint") && strings.Contains(msg, "not found")):
+		return ErrorKindMissingModel
+	case statusCode == http.StatusServiceUnavailable || statusCode == http.StatusTooManyRequests ||
+		strings.Contains(msg, "busy"):
+		return ErrorKindBusy
+	case statusCode == http.StatusUnprocessableEntity || strings.Contains(msg, "validation"):
+		return ErrorKindValidation
+	}
+	return ErrorKindUnknown
+}
+
+// Returns the message from a FastAPI detail field, which is either a string or a list of
+// validation errors.
+func parseErrorDetail(detail json.RawMessage) string {
+	if len(detail) == 0 {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(detail, &s) == nil {
+		return s
+	}
+	var validationErrors []struct {
+		Loc []any  `json:"loc"`
+		Msg string `json:"msg"`
+	}
+	if json.Unmarshal(detail, &validationErrors) != nil {
+		return ""
+	}
+	var msgs []string
+	for _, e := range validationErrors {
+		var loc []string
+		for _, l := range e.Loc {
+			if l != "body" {
+				loc = append(loc, fmt.Sprint(l))
+			}
+		}
+		if len(loc) > 0 {
+			msgs = append(msgs, strings.Join(loc, ".")+": "+e.Msg)
+		} else {
+			msgs = append(msgs, e.Msg)
+		}
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Parses the error JSON returned by the WebUI API or ComfyUI.
+func ParseAPIError(statusCode int, method, path string, body []byte) *APIError {
+	var errRes struct {
+		Detail json.RawMessage `json:"detail"`
+		Errors string          `json:"errors"`
+		// A string in the WebUI API, an object in ComfyUI.
+		Error json.RawMessage `json:"error"`
+	}
+	_ = json.Unmarshal(body, &errRes)
+
+	var msgs []string
+	if s := parseErrorDetail(errRes.Detail); s != "" {
+		msgs = append(msgs, s)
+	}
+	if errRes.Errors != "" {
+		msgs = append(msgs, errRes.Errors)
+	}
+	if len(errRes.Error) > 0 {
+		var s string
+		var comfyErr struct {
+			Message string `json:"message"`
+			Details string `json:"details"`
+		}
+		if json.Unmarshal(errRes.Error, &s) == nil {
+			// The error field contains only the exception class name, which is not useful
+			// if there are other messages.
+			if len(msgs) == 0 {
+				msgs = append(msgs, s)
+			}
+		} else if json.Unmarshal(errRes.Error, &comfyErr) == nil && comfyErr.Message != "" {
+			msgs = append(msgs, strings.TrimSpace(comfyErr.Message+" "+comfyErr.Details))
+		}
+	}
+
+	detail := strings.Join(msgs, ": ")
+	e := &APIError{
+		StatusCode: statusCode,
+		Method:     method,
+		Path:       path,
+		Detail:     detail,
+		// The exception class name is also checked, like OutOfMemoryError.
+		Kind: ClassifyError(statusCode, detail+" "+string(errRes.Error)),
+	}
+	return e
+}
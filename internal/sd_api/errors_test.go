@@ -0,0 +1,71 @@
+package sdapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+)
+
+func TestParseAPIError(t *testing.T) {
+	tests := []struct {
+		statusCode     int
+		body           string
+		expectedKind   ErrorKind
+		expectedDetail string
+	}{
+		{500, `{"error": "OutOfMemoryError", "detail": "", "body": "", "errors": "CUDA out of memory. Tried to allocate 2.00 GiB"}`,
+			ErrorKindOOM, "CUDA out of memory. Tried to allocate 2.00 GiB"},
+		{404, `{"detail": "Sampler not found"}`, ErrorKindInvalidSampler, "Sampler not found"},
+		{500, `{"error": "RuntimeError", "errors": "checkpoint xyz not found"}`, ErrorKindMissingModel, "checkpoint xyz not found"},
+		{503, `{"detail": "Service Unavailable"}`, ErrorKindBusy, "Service Unavailable"},
+		{422, `{"detail": [{"loc": ["body", "steps"], "msg": "value is not a valid integer", "type": "type_error.integer"}]}`,
+			ErrorKindValidation, "steps: value is not a valid integer"},
+		{400, `{"error": {"type": "prompt_outputs_failed_validation", "message": "Prompt outputs failed validation", "details": ""}}`,
+			ErrorKindValidation, "Prompt outputs failed validation"},
+		{500, `Internal Server Error`, ErrorKindUnknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			e := ParseAPIError(tt.statusCode, "POST", "/sdapi/v1/txt2img", []byte(tt.body))
+			if e.Kind != tt.expectedKind || e.Detail != tt.expectedDetail {
+				t.Errorf("got kind %s detail %q, expected %s %q", e.Kind, e.Detail, tt.expectedKind, tt.expectedDetail)
+			}
+		})
+	}
+}
+
+func TestAPIErrorUserMessageEscapesDetail(t *testing.T) {
+	for _, kind := range []ErrorKind{ErrorKindValidation, ErrorKindUnknown} {
+		e := &APIError{StatusCode: 500, Kind: kind, Detail: "expected <int> & got <str>"}
+		if msg := e.UserMessage(i18n.English); !strings.Contains(msg, "expected &lt;int&gt; &amp; got &lt;str&gt;") {
+			t.Errorf("got message %q for %s", msg, kind)
+		}
+	}
+}
+
+func TestAPIErrorReturned(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.FailNext("/sdapi/v1/txt2img", 1, 500, `{"error": "OutOfMemoryError", "errors": "CUDA out of memory."}`)
+
+	_, err := api.Render(context.Background(), reqparams.ReqParamsRender{Width: 8, Height: 8, BatchSize: 1, NumOutputs: 1}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindOOM {
+		t.Fatalf("got error %v, expected out of memory API error", err)
+	}
+	if msg := ErrorMessage(err, i18n.English); msg != i18n.English.T(i18n.APIErrorOOM) {
+		t.Errorf("got message %q", msg)
+	}
+	if strings.Contains(err.Error(), srv.URL) {
+		t.Errorf("error %q contains the API host", err)
+	}
+
+	srv.Close()
+	_, err = api.GetModels(context.Background())
+	if err == nil || strings.Contains(err.Error(), srv.URL) {
+		t.Errorf("got error %v, expected error without the API host", err)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
@@ -17,10 +21,18 @@ import (
 
 type SdAPIType struct {
 	SdHost string
+
+	capabilitiesMutex sync.Mutex
+	capabilities      *Capabilities
 }
 
 func (a *SdAPIType) req(ctx context.Context, path, service string, postData []byte) (string, error) {
-	path, err := url.JoinPath(a.SdHost, "/sdapi/v1", path)
+	return a.hostReq(ctx, "/sdapi/v1"+path, service, postData)
+}
+
+// Same as req, but the path is not under /sdapi/v1, like /internal/sysinfo.
+func (a *SdAPIType) hostReq(ctx context.Context, path, service string, postData []byte) (string, error) {
+	path, err := url.JoinPath(a.SdHost, path)
 	if err != nil {
 		return "", err
 	}
@@ -44,22 +56,23 @@ func (a *SdAPIType) req(ctx context.Context, path, service string, postData []by
 	client := http.Client{}
 	resp, err := client.Do(request)
 	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) { // Not showing the API host to users.
+			return "", fmt.Errorf("%s to %s: %w", request.Method, request.URL.Path, urlErr.Err)
+		}
 		return "", err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		fmt.Printf("Error on body: %s", postData)
-		if bodyBytes, err := io.ReadAll(resp.Body); err == nil {
-			fmt.Printf("Response: %s\n", string(bodyBytes))
-		} else {
-			fmt.Printf("")
-		}
-		return "", fmt.Errorf("api status code: %d (%s to %s)", resp.StatusCode, request.Method, path)
-	}
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode != 200 {
+		fmt.Printf("Response: %s\n", string(bodyBytes))
+		apiErr := ParseAPIError(resp.StatusCode, request.Method, request.URL.Path, bodyBytes)
+		fmt.Println("  api error:", apiErr.Kind, "-", apiErr)
+		return "", apiErr
+	}
 	return string(bodyBytes), nil
 }
 
@@ -69,9 +82,11 @@ type RenderReq struct {
 	HRScale           float32                `json:"hr_scale"`
 	HRUpscaler        string                 `json:"hr_upscaler"`
 	HRSecondPassSteps int                    `json:"hr_second_pass_steps"`
-	HRSamplerName     string                 `json:"hr_sampler_name"`
-	HRPrompt          string                 `json:"hr_prompt"`
-	HRNegativePrompt  string                 `json:"hr_negative_prompt"`
+	HRSamplerName     string                 `json:"hr_sampler_name,omitempty"`
+	HRPrompt          string                 `json:"hr_prompt,omitempty"`
+	HRNegativePrompt  string                 `json:"hr_negative_prompt,omitempty"`
+	RefinerCheckpoint string                 `json:"refiner_checkpoint,omitempty"`
+	RefinerSwitchAt   float64                `json:"refiner_switch_at,omitempty"`
 	Prompt            string                 `json:"prompt"`
 	Seed              uint32                 `json:"seed"`
 	SamplerName       string                 `json:"sampler_name"`
@@ -82,6 +97,10 @@ type RenderReq struct {
 	Width             int                    `json:"width"`
 	Height            int                    `json:"height"`
 	NegativePrompt    string                 `json:"negative_prompt"`
+	Eta               float64                `json:"eta,omitempty"`
+	Scheduler         string                 `json:"scheduler,omitempty"`
+	RestoreFaces      bool                   `json:"restore_faces,omitempty"`
+	Tiling            bool                   `json:"tiling,omitempty"`
 	OverrideSettings  map[string]interface{} `json:"override_settings"`
 	SendImages        bool                   `json:"send_images"`
 }
@@ -89,18 +108,32 @@ type RenderReq struct {
 func (a *SdAPIType) Render(ctx context.Context, p reqparams.ReqParams, _ []byte) (imgs [][]byte, err error) {
 	params := p.(reqparams.ReqParamsRender)
 
+	caps := a.detectedCapabilities(ctx)
+	if err = caps.CheckRender(params); err != nil {
+		return nil, err
+	}
+
 	n_iter := int(math.Ceil(float64(params.NumOutputs) / float64(params.BatchSize)))
 
-	postData, err := json.Marshal(RenderReq{
+	overrideSettings := map[string]interface{}{
+		"sd_model_checkpoint": params.ModelName,
+	}
+	if params.VAE != "" {
+		overrideSettings["sd_vae"] = params.VAE
+	}
+	if params.ClipSkip > 0 {
+		overrideSettings["CLIP_stop_at_last_layers"] = params.ClipSkip
+	}
+
+	renderReq := RenderReq{
 		EnableHR:          params.HR.Scale > 0,
 		DenoisingStrength: params.HR.DenoisingStrength,
 		HRScale:           params.HR.Scale,
 		HRUpscaler:        params.HR.Upscaler,
 		HRSecondPassSteps: params.HR.SecondPassSteps,
-		HRSamplerName:     params.SamplerName,
-		HRPrompt:          params.Prompt,
-		HRNegativePrompt:  params.NegativePrompt,
-		Prompt:            params.Prompt,
+		RefinerCheckpoint: params.Refiner,
+		RefinerSwitchAt:   params.RefinerSwitchAt,
+		Prompt:            params.PromptWithLoRAs(),
 		Seed:              params.Seed,
 		SamplerName:       params.SamplerName,
 		BatchSize:         params.BatchSize,
@@ -110,11 +143,24 @@ func (a *SdAPIType) Render(ctx context.Context, p reqparams.ReqParams, _ []byte)
 		Width:             params.Width,
 		Height:            params.Height,
 		NegativePrompt:    params.NegativePrompt,
-		OverrideSettings: map[string]interface{}{
-			"sd_model_checkpoint": params.ModelName,
-		},
-		SendImages: true,
-	})
+		Eta:               params.Eta,
+		Scheduler:         params.Scheduler,
+		RestoreFaces:      params.RestoreFaces,
+		Tiling:            params.Tiling,
+		OverrideSettings:  overrideSettings,
+		SendImages:        true,
+	}
+	// Forks without these fields reject requests containing them.
+	if caps.HRSampler {
+		renderReq.HRSamplerName = params.SamplerName
+		renderReq.HRPrompt = params.PromptWithLoRAs()
+		renderReq.HRNegativePrompt = params.NegativePrompt
+	}
+	if !caps.Scheduler {
+		renderReq.Scheduler = ""
+	}
+
+	postData, err := json.Marshal(renderReq)
 	if err != nil {
 		return nil, err
 	}
@@ -166,11 +212,28 @@ type UpscaleReq struct {
 func (a *SdAPIType) Upscale(ctx context.Context, p reqparams.ReqParams, imageData []byte) (imgs [][]byte, err error) {
 	params := p.(reqparams.ReqParamsUpscale)
 
-	postData, err := json.Marshal(UpscaleReq{
-		UpscalingResize: params.Scale,
-		Upscaler1:       params.Upscaler,
-		Image:           base64.StdEncoding.EncodeToString(imageData),
-	})
+	if err = a.detectedCapabilities(ctx).CheckUpscale(params); err != nil {
+		return nil, err
+	}
+
+	upscaleReq := UpscaleReq{
+		GFPGANVisibility:     params.GFPGANVisibility,
+		CodeFormerVisibility: params.CodeFormerVisibility,
+		CodeFormerWeight:     params.CodeFormerWeight,
+		UpscalingResize:      params.Scale,
+		Upscaler1:            params.Upscaler,
+		Upscaler2:            params.Upscaler2,
+		Upscaler2Visibility:  params.Upscaler2Visibility,
+		Image:                base64.StdEncoding.EncodeToString(imageData),
+	}
+	if params.TargetWidth > 0 {
+		upscaleReq.ResizeMode = 1
+		upscaleReq.UpscalingResizeWidth = params.TargetWidth
+		upscaleReq.UpscalingResizeHeight = params.TargetHeight
+		upscaleReq.UpscalingResizeWidthHeightCrop = params.Crop
+	}
+
+	postData, err := json.Marshal(upscaleReq)
 	if err != nil {
 		return nil, err
 	}
@@ -230,14 +293,23 @@ func (a *SdAPIType) GetProgress(ctx context.Context) (progressPercent int, eta t
 	return int(progressRes.Progress * 100), time.Duration(progressRes.ETA * float32(time.Second)), nil
 }
 
-func (a *SdAPIType) GetModels(ctx context.Context) (models []string, err error) {
+type ModelInfo struct {
+	Name string
+	// Short hash, like "6ce0161689".
+	Hash   string
+	SHA256 string
+}
+
+func (a *SdAPIType) GetModelInfos(ctx context.Context) (models []ModelInfo, err error) {
 	res, err := a.req(ctx, "/sd-models", "", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var modelsRes []struct {
-		Name string `json:"model_name"`
+		Name   string `json:"model_name"`
+		Hash   string `json:"hash"`
+		SHA256 string `json:"sha256"`
 	}
 	err = json.Unmarshal([]byte(res), &modelsRes)
 	if err != nil {
@@ -245,6 +317,18 @@ func (a *SdAPIType) GetModels(ctx context.Context) (models []string, err error)
 	}
 
 	for _, m := range modelsRes {
+		models = append(models, ModelInfo{Name: m.Name, Hash: m.Hash, SHA256: m.SHA256})
+	}
+	return
+}
+
+func (a *SdAPIType) GetModels(ctx context.Context) (models []string, err error) {
+	infos, err := a.GetModelInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range infos {
 		models = append(models, m.Name)
 	}
 	return
@@ -270,6 +354,26 @@ func (a *SdAPIType) GetSamplers(ctx context.Context) (samplers []string, err err
 	return
 }
 
+func (a *SdAPIType) GetSchedulers(ctx context.Context) (schedulers []string, err error) {
+	res, err := a.req(ctx, "/schedulers", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedulersRes []struct {
+		Label string `json:"label"`
+	}
+	err = json.Unmarshal([]byte(res), &schedulersRes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scheduler := range schedulersRes {
+		schedulers = append(schedulers, scheduler.Label)
+	}
+	return
+}
+
 func (a *SdAPIType) GetEmbeddings(ctx context.Context) (embs []string, err error) {
 	res, err := a.req(ctx, "/embeddings", "", nil)
 	if err != nil {
@@ -290,14 +394,66 @@ func (a *SdAPIType) GetEmbeddings(ctx context.Context) (embs []string, err error
 	return
 }
 
-func (a *SdAPIType) GetLoRAs(ctx context.Context) (loras []string, err error) {
+type LoRAInfo struct {
+	Name         string
+	Alias        string
+	BaseModel    string
+	TriggerWords []string
+}
+
+// Returns the most frequent training tags from the LoRA's ss_tag_frequency metadata field.
+// The field contains tag frequencies grouped by training dataset, and can be either a JSON
+// object or a string containing JSON.
+func loRATriggerWordsFromMetadata(tagFrequencyField json.RawMessage, maxCount int) (words []string) {
+	if len(tagFrequencyField) == 0 {
+		return nil
+	}
+
+	var tagFrequencyStr string
+	if json.Unmarshal(tagFrequencyField, &tagFrequencyStr) == nil {
+		tagFrequencyField = json.RawMessage(tagFrequencyStr)
+	}
+
+	var datasets map[string]map[string]int
+	if err := json.Unmarshal(tagFrequencyField, &datasets); err != nil {
+		return nil
+	}
+
+	tagFrequencies := make(map[string]int)
+	for _, tags := range datasets {
+		for tag, freq := range tags {
+			tagFrequencies[strings.TrimSpace(tag)] += freq
+		}
+	}
+	for tag := range tagFrequencies {
+		words = append(words, tag)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if tagFrequencies[words[i]] == tagFrequencies[words[j]] {
+			return words[i] < words[j]
+		}
+		return tagFrequencies[words[i]] > tagFrequencies[words[j]]
+	})
+	if len(words) > maxCount {
+		words = words[:maxCount]
+	}
+	return
+}
+
+func (a *SdAPIType) GetLoRAInfos(ctx context.Context) (loras []LoRAInfo, err error) {
 	res, err := a.req(ctx, "/loras", "", nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var lorasRes []struct {
-		Name string `json:"name"`
+		Name     string `json:"name"`
+		Alias    string `json:"alias"`
+		Metadata struct {
+			BaseModelVersion string          `json:"ss_base_model_version"`
+			SDModelName      string          `json:"ss_sd_model_name"`
+			TagFrequency     json.RawMessage `json:"ss_tag_frequency"`
+		} `json:"metadata"`
 	}
 	err = json.Unmarshal([]byte(res), &lorasRes)
 	if err != nil {
@@ -305,6 +461,27 @@ func (a *SdAPIType) GetLoRAs(ctx context.Context) (loras []string, err error) {
 	}
 
 	for _, lora := range lorasRes {
+		info := LoRAInfo{
+			Name:         lora.Name,
+			Alias:        lora.Alias,
+			BaseModel:    lora.Metadata.BaseModelVersion,
+			TriggerWords: loRATriggerWordsFromMetadata(lora.Metadata.TagFrequency, 5),
+		}
+		if info.BaseModel == "" {
+			info.BaseModel = lora.Metadata.SDModelName
+		}
+		loras = append(loras, info)
+	}
+	return
+}
+
+func (a *SdAPIType) GetLoRAs(ctx context.Context) (loras []string, err error) {
+	infos, err := a.GetLoRAInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lora := range infos {
 		loras = append(loras, lora.Name)
 	}
 	return
@@ -349,3 +526,12 @@ func (a *SdAPIType) GetVAEs(ctx context.Context) (vaes []string, err error) {
 	}
 	return
 }
+
+// Makes the API rescan the model and LoRA directories.
+func (a *SdAPIType) Refresh(ctx context.Context) error {
+	if _, err := a.req(ctx, "/refresh-checkpoints", "", []byte{}); err != nil {
+		return err
+	}
+	_, err := a.req(ctx, "/refresh-loras", "", []byte{})
+	return err
+}
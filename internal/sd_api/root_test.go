@@ -0,0 +1,225 @@
+package sdapi
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/reqparams"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/sd_api/sdapitest"
+)
+
+func newTestAPI(t *testing.T) (*SdAPIType, *sdapitest.Server) {
+	t.Helper()
+	srv := sdapitest.NewServer()
+	t.Cleanup(srv.Close)
+	return &SdAPIType{SdHost: srv.URL}, srv
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRender(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	imgs, err := api.Render(context.Background(), reqparams.ReqParamsRender{
+		Prompt:      "cat",
+		Seed:        1,
+		Width:       64,
+		Height:      32,
+		BatchSize:   2,
+		NumOutputs:  3,
+		Steps:       10,
+		SamplerName: "Euler a",
+		ModelName:   "v1-5-pruned-emaonly",
+		VAE:         "Automatic",
+		ClipSkip:    2,
+		LoRAs:       []reqparams.ReqParamsLoRA{{Name: "add_detail", Weight: 0.5}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 outputs with batch size 2 need 2 iterations, so 4 images are rendered.
+	if len(imgs) != 4 {
+		t.Fatalf("got %d images, expected 4", len(imgs))
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imgs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 64 || cfg.Height != 32 {
+		t.Errorf("got %dx%d image, expected 64x32", cfg.Width, cfg.Height)
+	}
+
+	var req RenderReq
+	if err = srv.LastRequest("/sdapi/v1/txt2img", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.NIter != 2 {
+		t.Errorf("got n_iter %d, expected 2", req.NIter)
+	}
+	if req.Prompt != "cat <lora:add_detail:0.5>" {
+		t.Errorf("got prompt %q", req.Prompt)
+	}
+	if req.OverrideSettings["sd_vae"] != "Automatic" || req.OverrideSettings["CLIP_stop_at_last_layers"] != 2.0 {
+		t.Errorf("got override settings %v", req.OverrideSettings)
+	}
+}
+
+func TestUpscale(t *testing.T) {
+	api, srv := newTestAPI(t)
+
+	imgs, err := api.Upscale(context.Background(), reqparams.ReqParamsUpscale{
+		Scale:        2,
+		Upscaler:     "LDSR",
+		TargetWidth:  100,
+		TargetHeight: 50,
+		Crop:         true,
+	}, sdapitest.GeneratePNG(20, 10, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 1 {
+		t.Fatalf("got %d images, expected 1", len(imgs))
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imgs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("got %dx%d image, expected 100x50", cfg.Width, cfg.Height)
+	}
+
+	var req UpscaleReq
+	if err = srv.LastRequest("/sdapi/v1/extra-single-image", &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.ResizeMode != 1 || !req.UpscalingResizeWidthHeightCrop {
+		t.Errorf("target size is not set in request: %+v", req)
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Latency = time.Minute
+	srv.SetProgress(0.5, 20*time.Second)
+
+	if progress, eta, err := api.GetProgress(context.Background()); err != nil || progress != 0 || eta != 0 {
+		t.Errorf("got progress %d%%, eta %v, error %v without a job", progress, eta, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_, _ = api.Render(ctx, reqparams.ReqParamsRender{Width: 8, Height: 8, BatchSize: 1, NumOutputs: 1}, nil)
+	}()
+	waitFor(t, "the job to start", srv.JobRunning)
+
+	progress, eta, err := api.GetProgress(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress != 50 || eta != 20*time.Second {
+		t.Errorf("got progress %d%%, eta %v, expected 50%%, 20s", progress, eta)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.Latency = time.Minute
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = api.Render(context.Background(), reqparams.ReqParamsRender{Width: 8, Height: 8, BatchSize: 1, NumOutputs: 1}, nil)
+		close(done)
+	}()
+
+	waitFor(t, "the job to start", srv.JobRunning)
+	if err := api.Interrupt(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("render not interrupted")
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	api, srv := newTestAPI(t)
+	srv.FailNext("/sdapi/v1/txt2img", 1, 500, `{"detail": "internal error"}`)
+
+	_, err := api.Render(context.Background(), reqparams.ReqParamsRender{Width: 8, Height: 8, BatchSize: 1, NumOutputs: 1}, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Failure injection is only for the next request.
+	if _, err = api.Render(context.Background(), reqparams.ReqParamsRender{Width: 8, Height: 8, BatchSize: 1, NumOutputs: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLists(t *testing.T) {
+	api, srv := newTestAPI(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		get      func(context.Context) ([]string, error)
+		expected []string
+	}{
+		{"models", api.GetModels, srv.Models},
+		{"samplers", api.GetSamplers, srv.Samplers},
+		{"schedulers", api.GetSchedulers, srv.Schedulers},
+		{"upscalers", api.GetUpscalers, srv.Upscalers},
+		{"loras", api.GetLoRAs, srv.LoRAs},
+		{"vaes", api.GetVAEs, srv.VAEs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.get(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(res, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("got %v, expected %v", res, tt.expected)
+			}
+		})
+	}
+
+	embs, err := api.GetEmbeddings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embs) != len(srv.Embeddings) {
+		t.Errorf("got %v, expected %v", embs, srv.Embeddings)
+	}
+
+	models, err := api.GetModelInfos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sdapitest.ModelHash(srv.Models[0])
+	if len(models) != len(srv.Models) || models[0] != (ModelInfo{Name: srv.Models[0], Hash: hash[:10], SHA256: hash}) {
+		t.Errorf("got %+v", models)
+	}
+}
+
+func TestLoRATriggerWordsFromMetadata(t *testing.T) {
+	words := loRATriggerWordsFromMetadata([]byte(`"{\"ds1\": {\"pixel art\": 10, \"cat\": 2}, \"ds2\": {\"cat\": 3, \"dog\": 1}}"`), 2)
+	if strings.Join(words, ",") != "pixel art,cat" {
+		t.Errorf("got %v", words)
+	}
+}
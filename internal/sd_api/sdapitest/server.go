@@ -0,0 +1,432 @@
+// Package sdapitest provides a fake Stable Diffusion AUTOMATIC1111 API server for tests.
+package sdapitest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Returns the progress (0-1) for the given elapsed part (0-1) of a job.
+type ProgressCurveFn func(elapsed float64) float64
+
+func LinearProgress(elapsed float64) float64 {
+	return elapsed
+}
+
+// Progress is slow at the beginning (model loading) and fast at the end.
+func SlowStartProgress(elapsed float64) float64 {
+	return elapsed * elapsed
+}
+
+type failure struct {
+	statusCode int
+	body       string
+	count      int
+}
+
+type Server struct {
+	*httptest.Server
+
+	mutex sync.Mutex
+
+	// Time needed for a render or an upscale job.
+	Latency       time.Duration
+	ProgressCurve ProgressCurveFn
+
+	Models     []string
+	Samplers   []string
+	Schedulers []string
+	Upscalers  []string
+	LoRAs      []string
+	Embeddings []string
+	VAEs       []string
+	Version    string
+	// Returned by the options endpoint, the defaults are the keys checked by capability detection.
+	Options map[string]any
+	// Names of txt2img scripts.
+	Scripts []string
+
+	failures map[string]*failure
+	requests map[string][][]byte
+	blocked  map[string]chan struct{}
+
+	jobStartedAt time.Time
+	jobRunning   bool
+	interruptCh  chan struct{}
+	// Set by SetProgress.
+	fixedProgress *fixedProgress
+}
+
+type fixedProgress struct {
+	progress float64
+	eta      time.Duration
+}
+
+func NewServer() *Server {
+	s := &Server{
+		ProgressCurve: LinearProgress,
+		Models:        []string{"v1-5-pruned-emaonly", "sd_xl_base_1.0"},
+		Samplers:      []string{"Euler a", "Euler", "DPM++ 2M Karras"},
+		Schedulers:    []string{"Automatic", "Karras", "Exponential"},
+		Upscalers:     []string{"None", "Lanczos", "LDSR", "R-ESRGAN 4x+"},
+		LoRAs:         []string{"add_detail", "pixel-art-xl"},
+		Embeddings:    []string{"easynegative", "badhandv4"},
+		VAEs:          []string{"vae-ft-mse-840000-ema-pruned.safetensors"},
+		Version:       "v1.10.1",
+		Options: map[string]any{
+			"sd_model_checkpoint":      "v1-5-pruned-emaonly",
+			"sd_vae":                   "Automatic",
+			"CLIP_stop_at_last_layers": 1,
+			"hires_fix_show_sampler":   false,
+			"face_restoration_model":   "CodeFormer",
+		},
+		Scripts:  []string{"refiner", "seed", "prompt matrix"},
+		failures: make(map[string]*failure),
+		requests: make(map[string][][]byte),
+		blocked:  make(map[string]chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sdapi/v1/txt2img", s.handleTxt2Img)
+	mux.HandleFunc("/sdapi/v1/extra-single-image", s.handleUpscale)
+	mux.HandleFunc("/sdapi/v1/progress", s.handleProgress)
+	mux.HandleFunc("/sdapi/v1/interrupt", s.handleInterrupt)
+	mux.HandleFunc("/sdapi/v1/sd-models", s.handleModels)
+	mux.HandleFunc("/sdapi/v1/samplers", s.handleNameList(&s.Samplers, "name"))
+	mux.HandleFunc("/sdapi/v1/schedulers", s.handleSchedulers)
+	mux.HandleFunc("/sdapi/v1/upscalers", s.handleNameList(&s.Upscalers, "name"))
+	mux.HandleFunc("/sdapi/v1/loras", s.handleNameList(&s.LoRAs, "name"))
+	mux.HandleFunc("/sdapi/v1/sd-vae", s.handleNameList(&s.VAEs, "model_name"))
+	mux.HandleFunc("/sdapi/v1/embeddings", s.handleEmbeddings)
+	mux.HandleFunc("/sdapi/v1/options", s.handleOptions)
+	mux.HandleFunc("/sdapi/v1/scripts", s.handleScripts)
+	mux.HandleFunc("/sdapi/v1/refresh-checkpoints", s.handleRefresh)
+	mux.HandleFunc("/sdapi/v1/refresh-loras", s.handleRefresh)
+	mux.HandleFunc("/internal/sysinfo", s.handleSysInfo)
+
+	s.Server = httptest.NewServer(s.recordAndInjectFailures(mux))
+	return s
+}
+
+// The next count requests to the given path (like "/sdapi/v1/txt2img") will fail with the
+// given status code and body.
+func (s *Server) FailNext(path string, count int, statusCode int, body string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[path] = &failure{statusCode: statusCode, body: body, count: count}
+}
+
+// Requests to the given path wait until the returned function is called, which simulates a
+// backend that is slow to respond.
+func (s *Server) Block(path string) (release func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ch := make(chan struct{})
+	s.blocked[path] = ch
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			if s.blocked[path] == ch {
+				delete(s.blocked, path)
+			}
+			s.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Returns the bodies of requests received at the given path.
+func (s *Server) Requests(path string) [][]byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([][]byte{}, s.requests[path]...)
+}
+
+// Returns the last JSON request received at the given path decoded into dest.
+func (s *Server) LastRequest(path string, dest any) error {
+	reqs := s.Requests(path)
+	if len(reqs) == 0 {
+		return io.EOF
+	}
+	return json.Unmarshal(reqs[len(reqs)-1], dest)
+}
+
+func (s *Server) recordAndInjectFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		s.mutex.Lock()
+		s.requests[r.URL.Path] = append(s.requests[r.URL.Path], body)
+		blocked := s.blocked[r.URL.Path]
+		f := s.failures[r.URL.Path]
+		if f != nil {
+			f.count--
+			if f.count <= 0 {
+				delete(s.failures, r.URL.Path)
+			}
+		}
+		s.mutex.Unlock()
+
+		if blocked != nil {
+			select {
+			case <-blocked:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(f.statusCode)
+			_, _ = w.Write([]byte(f.body))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Generates a PNG image with a solid color depending on the given seed.
+func GeneratePNG(width, height int, seed uint32) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	c := color.RGBA{R: uint8(seed), G: uint8(seed >> 8), B: uint8(seed >> 16), A: 255}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+	buf := new(bytes.Buffer)
+	_ = png.Encode(buf, img)
+	return buf.Bytes()
+}
+
+// Makes the progress endpoint report the given progress (0-1) and ETA for running jobs instead
+// of calculating them from the elapsed time, so tests don't depend on timing.
+func (s *Server) SetProgress(progress float64, eta time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fixedProgress = &fixedProgress{progress: progress, eta: eta}
+}
+
+// Returns true while a render or an upscale job is running.
+func (s *Server) JobRunning() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.jobRunning
+}
+
+// Simulates a running job. Returns false if the job has been interrupted.
+func (s *Server) runJob(r *http.Request) bool {
+	s.mutex.Lock()
+	s.jobStartedAt = time.Now()
+	s.jobRunning = true
+	s.interruptCh = make(chan struct{})
+	interruptCh := s.interruptCh
+	latency := s.Latency
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.jobRunning = false
+		s.mutex.Unlock()
+	}()
+
+	select {
+	case <-time.After(latency):
+		return true
+	case <-interruptCh:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (s *Server) handleTxt2Img(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Seed      uint32 `json:"seed"`
+		BatchSize int    `json:"batch_size"`
+		NIter     int    `json:"n_iter"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, map[string]any{"detail": err.Error()})
+		return
+	}
+
+	completed := s.runJob(r)
+
+	imgCount := max(req.BatchSize, 1) * max(req.NIter, 1)
+	if !completed { // Interrupted jobs return only the first image.
+		imgCount = 1
+	}
+	var images []string
+	for i := 0; i < imgCount; i++ {
+		images = append(images, base64.StdEncoding.EncodeToString(GeneratePNG(req.Width, req.Height, req.Seed+uint32(i))))
+	}
+	writeJSON(w, map[string]any{"images": images, "info": "{}"})
+}
+
+func (s *Server) handleUpscale(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UpscalingResize       float64 `json:"upscaling_resize"`
+		UpscalingResizeWidth  int     `json:"upscaling_resize_w"`
+		UpscalingResizeHeight int     `json:"upscaling_resize_h"`
+		Image                 string  `json:"image"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, map[string]any{"detail": err.Error()})
+		return
+	}
+	imgData, err := base64.StdEncoding.DecodeString(req.Image)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJSON(w, map[string]any{"detail": "invalid image"})
+		return
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imgData))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeJSON(w, map[string]any{"detail": "can't decode image"})
+		return
+	}
+
+	s.runJob(r)
+
+	width := int(math.Round(float64(cfg.Width) * req.UpscalingResize))
+	height := int(math.Round(float64(cfg.Height) * req.UpscalingResize))
+	if req.UpscalingResizeWidth > 0 {
+		width, height = req.UpscalingResizeWidth, req.UpscalingResizeHeight
+	}
+	writeJSON(w, map[string]any{"image": base64.StdEncoding.EncodeToString(GeneratePNG(width, height, 0))})
+}
+
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.jobRunning || (s.Latency == 0 && s.fixedProgress == nil) {
+		writeJSON(w, map[string]any{"progress": 0, "eta_relative": 0})
+		return
+	}
+	if s.fixedProgress != nil {
+		writeJSON(w, map[string]any{"progress": s.fixedProgress.progress, "eta_relative": s.fixedProgress.eta.Seconds()})
+		return
+	}
+
+	elapsed := min(float64(time.Since(s.jobStartedAt))/float64(s.Latency), 1)
+	progress := s.ProgressCurve(elapsed)
+	eta := (s.Latency - time.Since(s.jobStartedAt)).Seconds()
+	writeJSON(w, map[string]any{"progress": progress, "eta_relative": max(eta, 0)})
+}
+
+// Refreshes are only recorded, tests change the lists directly.
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, nil)
+}
+
+func (s *Server) handleInterrupt(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	if s.jobRunning && s.interruptCh != nil {
+		close(s.interruptCh)
+		s.interruptCh = nil
+	}
+	s.mutex.Unlock()
+	writeJSON(w, map[string]any{})
+}
+
+// Returns the SHA256 hash of the model, which is calculated from its name.
+func ModelHash(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	res := []map[string]any{}
+	for _, m := range s.Models {
+		hash := ModelHash(m)
+		res = append(res, map[string]any{
+			"title":      m + ".safetensors [" + hash[:10] + "]",
+			"model_name": m,
+			"hash":       hash[:10],
+			"sha256":     hash,
+		})
+	}
+	writeJSON(w, res)
+}
+
+func (s *Server) handleNameList(names *[]string, nameField string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		res := []map[string]any{}
+		for _, n := range *names {
+			res = append(res, map[string]any{nameField: n})
+		}
+		writeJSON(w, res)
+	}
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	loaded := map[string]any{}
+	for _, e := range s.Embeddings {
+		loaded[e] = map[string]any{"step": nil, "shape": 768, "vectors": 1}
+	}
+	writeJSON(w, map[string]any{"loaded": loaded, "skipped": map[string]any{}})
+}
+
+func (s *Server) handleSysInfo(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, map[string]any{"Version": s.Version})
+}
+
+// Older versions without the scheduler field are simulated by setting Schedulers to nil.
+func (s *Server) handleSchedulers(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	noSchedulers := s.Schedulers == nil
+	s.mutex.Unlock()
+	if noSchedulers {
+		http.NotFound(w, r)
+		return
+	}
+	s.handleNameList(&s.Schedulers, "label")(w, r)
+}
+
+func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, s.Options)
+}
+
+func (s *Server) handleScripts(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, map[string]any{"txt2img": s.Scripts, "img2img": s.Scripts})
+}
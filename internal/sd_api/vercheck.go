@@ -10,7 +10,7 @@ import (
 	"time"
 
 	"github.com/google/go-github/v53/github"
-	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/consts"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/i18n"
 )
 
 const versionCheckTimeout = time.Second * 10
@@ -74,7 +74,7 @@ func VersionCheckGetStr(ctx context.Context, stableDiffusionApiHost string) (res
 	var latestVersion, currentVersion string
 	var err error
 	if latestVersion, currentVersion, err = versionCheck(verCheckCtx, stableDiffusionApiHost); err != nil {
-		return consts.ErrorStr + ": " + err.Error(), true
+		return i18n.English.T(i18n.Error, err), true
 	}
 
 	updateNeededOrError = currentVersion != latestVersion
@@ -1,6 +1,7 @@
 package telegram
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,25 +11,61 @@ import (
 	"github.com/go-telegram/bot/models"
 )
 
+// BotAPI contains the Telegram operations used by the command handlers and the request queue.
+// It is implemented by SDBot, and by telegramtest.FakeBot for tests.
+type BotAPI interface {
+	RegisterPrefixHandler(pattern string, handlerFunc bot.HandlerFunc) string
+	RegisterCallbackHandler(pattern string, handlerFunc bot.HandlerFunc) string
+	SendReplyToMessage(ctx context.Context, replyToMsg *models.Message, text string) (msg *models.Message)
+	SendReplyWithMarkup(ctx context.Context, replyToMsg *models.Message, text string, markup models.ReplyMarkup) (msg *models.Message)
+	AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error
+	EditMessage(ctx context.Context, editableMsg *models.Message, newText string) error
+	DeleteMessage(ctx context.Context, deletingMessage *models.Message) error
+	SendTextToAdmins(ctx context.Context, adminUserIds []int64, s string)
+	SendMediaGroup(ctx context.Context, replyToMsg *models.Message, media []models.InputMedia) ([]*models.Message, error)
+	SendPhoto(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string, hasSpoiler bool) (*models.Message, error)
+	SendDocument(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string) (*models.Message, error)
+	GetFile(ctx context.Context, fileId string, getWriterFunc func(fileSize int64) io.Writer) (d []byte, err error)
+	IsChatAdmin(ctx context.Context, chatID int64, userID int64) (bool, error)
+	AnswerInlineQuery(ctx context.Context, inlineQueryID string, results []models.InlineQueryResult, button *models.InlineQueryResultsButton) error
+	SetMyCommands(ctx context.Context, commands []models.BotCommand, scope models.BotCommandScope, languageCode string) error
+	Username() string
+}
+
 type SDBot struct {
 	bot        *bot.Bot
 	getFileUrl func(fileInfo *models.File) string
+	username   string
 }
 
-func NewBot(botToken string, defailtHandlerFunc bot.HandlerFunc) (*SDBot, error) {
-	botInternal, err := bot.New(botToken, bot.WithDefaultHandler(defailtHandlerFunc))
+func NewBot(botToken string, defailtHandlerFunc bot.HandlerFunc, opts ...bot.Option) (*SDBot, error) {
+	botInternal, err := bot.New(botToken, append([]bot.Option{bot.WithDefaultHandler(defailtHandlerFunc)}, opts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create telegram bot with token: %w", err)
 	}
-	return &SDBot{bot: botInternal, getFileUrl: func(fileInfo *models.File) string {
+	me, err := botInternal.GetMe(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("cannot get telegram bot info: %w", err)
+	}
+	return &SDBot{bot: botInternal, username: me.Username, getFileUrl: func(fileInfo *models.File) string {
 		return fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", botToken, fileInfo.FilePath)
 	}}, nil
 }
 
+// Returns the username of the bot, without the @.
+func (b *SDBot) Username() string {
+	return b.username
+}
+
 func (b *SDBot) RegisterPrefixHandler(pattern string, handlerFunc bot.HandlerFunc) string {
 	return b.bot.RegisterHandler(bot.HandlerTypeMessageText, pattern, bot.MatchTypePrefix, handlerFunc)
 }
 
+// Registers a handler for inline keyboard button presses with callback data starting with pattern.
+func (b *SDBot) RegisterCallbackHandler(pattern string, handlerFunc bot.HandlerFunc) string {
+	return b.bot.RegisterHandler(bot.HandlerTypeCallbackQueryData, pattern, bot.MatchTypePrefix, handlerFunc)
+}
+
 func (b *SDBot) Start(ctx context.Context) {
 	b.bot.Start(ctx)
 }
@@ -47,6 +84,29 @@ func (b *SDBot) SendReplyToMessage(ctx context.Context, replyToMsg *models.Messa
 	return
 }
 
+func (b *SDBot) SendReplyWithMarkup(ctx context.Context, replyToMsg *models.Message, text string, markup models.ReplyMarkup) (msg *models.Message) {
+	var err error
+	msg, err = b.bot.SendMessage(ctx, &bot.SendMessageParams{
+		ReplyToMessageID: replyToMsg.ID,
+		ChatID:           replyToMsg.Chat.ID,
+		ParseMode:        models.ParseModeHTML,
+		Text:             text,
+		ReplyMarkup:      markup,
+	})
+	if err != nil {
+		fmt.Println("  reply send error:", err)
+	}
+	return
+}
+
+func (b *SDBot) AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error {
+	_, err := b.bot.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: callbackQueryID,
+		Text:            text,
+	})
+	return err
+}
+
 func (b *SDBot) EditMessage(ctx context.Context, editableMsg *models.Message, newText string) error {
 	_, err := b.bot.EditMessageText(ctx, &bot.EditMessageTextParams{
 		MessageID: editableMsg.ID,
@@ -74,15 +134,60 @@ func (b *SDBot) SendTextToAdmins(ctx context.Context, adminUserIds []int64, s st
 	}
 }
 
-func (b *SDBot) SendMediaGroup(ctx context.Context, replyToMsg *models.Message, media []models.InputMedia) error {
-	_, err := b.bot.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
+func (b *SDBot) SendMediaGroup(ctx context.Context, replyToMsg *models.Message, media []models.InputMedia) ([]*models.Message, error) {
+	return b.bot.SendMediaGroup(ctx, &bot.SendMediaGroupParams{
 		ChatID:           replyToMsg.Chat.ID,
 		ReplyToMessageID: replyToMsg.ID,
 		Media:            media,
 	})
+}
+
+func (b *SDBot) SendPhoto(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string, hasSpoiler bool) (*models.Message, error) {
+	return b.bot.SendPhoto(ctx, &bot.SendPhotoParams{
+		ChatID:           replyToMsg.Chat.ID,
+		ReplyToMessageID: replyToMsg.ID,
+		Photo:            &models.InputFileUpload{Filename: filename, Data: bytes.NewReader(data)},
+		ParseMode:        models.ParseModeHTML,
+		Caption:          caption,
+		HasSpoiler:       hasSpoiler,
+	})
+}
+
+func (b *SDBot) SendDocument(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string) (*models.Message, error) {
+	return b.bot.SendDocument(ctx, &bot.SendDocumentParams{
+		ChatID:           replyToMsg.Chat.ID,
+		ReplyToMessageID: replyToMsg.ID,
+		Document:         &models.InputFileUpload{Filename: filename, Data: bytes.NewReader(data)},
+		ParseMode:        models.ParseModeHTML,
+		Caption:          caption,
+	})
+}
+
+// Answers the inline query with the results, and with the button shown above them if not nil.
+// Answers are not cached, as the results change when the render is finished.
+func (b *SDBot) AnswerInlineQuery(ctx context.Context, inlineQueryID string, results []models.InlineQueryResult, button *models.InlineQueryResultsButton) error {
+	_, err := b.bot.AnswerInlineQuery(ctx, &bot.AnswerInlineQueryParams{
+		InlineQueryID: inlineQueryID,
+		Results:       results,
+		// Zero is not sent, and the default is 300 seconds.
+		CacheTime:  1,
+		IsPersonal: true,
+		Button:     button,
+	})
 	return err
+}
 
+// Sets the command list shown by Telegram clients for the scope and the language. An empty
+// language code sets the list for users whose language has no dedicated list.
+func (b *SDBot) SetMyCommands(ctx context.Context, commands []models.BotCommand, scope models.BotCommandScope, languageCode string) error {
+	_, err := b.bot.SetMyCommands(ctx, &bot.SetMyCommandsParams{
+		Commands:     commands,
+		Scope:        scope,
+		LanguageCode: languageCode,
+	})
+	return err
 }
+
 func (b *SDBot) GetFile(ctx context.Context, fileId string, getWriterFunc func(fileSize int64) io.Writer) (d []byte, err error) {
 	fmt.Println("  downloading...")
 
@@ -113,6 +218,18 @@ func (b *SDBot) GetFile(ctx context.Context, fileId string, getWriterFunc func(f
 	return d, nil
 }
 
+// Returns true if the user is the owner or an administrator of the chat.
+func (b *SDBot) IsChatAdmin(ctx context.Context, chatID int64, userID int64) (bool, error) {
+	member, err := b.bot.GetChatMember(ctx, &bot.GetChatMemberParams{
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return member.Type == models.ChatMemberTypeOwner || member.Type == models.ChatMemberTypeAdministrator, nil
+}
+
 type ImageFileData struct {
 	Data     []byte
 	Filename string
@@ -0,0 +1,378 @@
+// Package telegramtest provides an in-memory Telegram bot for tests.
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/kanootoko/stable-diffusion-telegram-bot/internal/telegram"
+)
+
+// Call is a recorded call of a FakeBot method.
+type Call struct {
+	Method string
+	ChatID int64
+	// ID of the sent message for sends, ID of the edited/deleted message otherwise.
+	MessageID int
+	ReplyToID int
+	Text      string
+	Media     []models.InputMedia
+	Markup    models.ReplyMarkup
+	Spoiler   bool
+	// Results of inline query answers.
+	InlineResults []models.InlineQueryResult
+	// Commands set with SetMyCommands, the language code is recorded in Text.
+	Commands []models.BotCommand
+}
+
+type prefixHandler struct {
+	pattern     string
+	handlerFunc bot.HandlerFunc
+}
+
+type retryAfterFailure struct {
+	count      int
+	retryAfter int
+}
+
+// FakeBot records all calls and keeps no state on a server.
+type FakeBot struct {
+	mutex          sync.Mutex
+	calls          []Call
+	handlers       []prefixHandler
+	callbacks      []prefixHandler
+	defaultHandler bot.HandlerFunc
+	failures       map[string]*retryAfterFailure
+	nextMsgID      int
+
+	// File contents returned by GetFile, by file ID.
+	Files map[string][]byte
+	// Users who are admins in all chats.
+	ChatAdmins []int64
+}
+
+// Username of the fake bot.
+const BotUsername = "sdbot"
+
+var _ telegram.BotAPI = (*FakeBot)(nil)
+
+func NewFakeBot(defaultHandler bot.HandlerFunc) *FakeBot {
+	return &FakeBot{
+		defaultHandler: defaultHandler,
+		failures:       make(map[string]*retryAfterFailure),
+		nextMsgID:      1000,
+		Files:          make(map[string][]byte),
+	}
+}
+
+// The next count calls of the given method (like "SendMediaGroup") fail with a flood wait
+// error, the same way as the real Telegram API does.
+func (b *FakeBot) FailWithRetryAfter(method string, count int, retryAfterSeconds int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures[method] = &retryAfterFailure{count: count, retryAfter: retryAfterSeconds}
+}
+
+func (b *FakeBot) checkFailure(method string) error {
+	f := b.failures[method]
+	if f == nil {
+		return nil
+	}
+	f.count--
+	if f.count <= 0 {
+		delete(b.failures, method)
+	}
+	return fmt.Errorf("unexpected response statusCode 429 for method %s, "+
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`,
+		method, f.retryAfter, f.retryAfter)
+}
+
+// Returns the recorded successful calls of the given method, or all calls if method is empty.
+func (b *FakeBot) Calls(method string) (res []Call) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, c := range b.calls {
+		if method == "" || c.Method == method {
+			res = append(res, c)
+		}
+	}
+	return
+}
+
+// Returns true if a call of the given method has been recorded with text containing substr.
+func (b *FakeBot) HasText(method, substr string) bool {
+	for _, c := range b.Calls(method) {
+		if strings.Contains(c.Text, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Waits until cond returns true for the recorded calls of the given method.
+func (b *FakeBot) WaitFor(method string, timeout time.Duration, cond func(calls []Call) bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond(b.Calls(method)) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Dispatches the update to the matching registered handler, the same way as the bot library does.
+func (b *FakeBot) ProcessUpdate(ctx context.Context, update *models.Update) {
+	b.mutex.Lock()
+	handlerFunc := b.defaultHandler
+	if update.Message != nil {
+		for _, h := range b.handlers {
+			if strings.HasPrefix(update.Message.Text, h.pattern) {
+				handlerFunc = h.handlerFunc
+				break
+			}
+		}
+	} else if update.CallbackQuery != nil {
+		for _, h := range b.callbacks {
+			if strings.HasPrefix(update.CallbackQuery.Data, h.pattern) {
+				handlerFunc = h.handlerFunc
+				break
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	if handlerFunc != nil {
+		handlerFunc(ctx, nil, update)
+	}
+}
+
+func (b *FakeBot) RegisterPrefixHandler(pattern string, handlerFunc bot.HandlerFunc) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers = append(b.handlers, prefixHandler{pattern: pattern, handlerFunc: handlerFunc})
+	return pattern
+}
+
+func (b *FakeBot) RegisterCallbackHandler(pattern string, handlerFunc bot.HandlerFunc) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.callbacks = append(b.callbacks, prefixHandler{pattern: pattern, handlerFunc: handlerFunc})
+	return pattern
+}
+
+func (b *FakeBot) sendReply(method string, replyToMsg *models.Message, text string, markup models.ReplyMarkup) *models.Message {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkFailure(method); err != nil {
+		return nil
+	}
+	b.nextMsgID++
+	b.calls = append(b.calls, Call{
+		Method:    method,
+		ChatID:    replyToMsg.Chat.ID,
+		MessageID: b.nextMsgID,
+		ReplyToID: replyToMsg.ID,
+		Text:      text,
+		Markup:    markup,
+	})
+	return &models.Message{ID: b.nextMsgID, Chat: replyToMsg.Chat, Text: text}
+}
+
+func (b *FakeBot) SendReplyToMessage(ctx context.Context, replyToMsg *models.Message, text string) (msg *models.Message) {
+	return b.sendReply("SendReplyToMessage", replyToMsg, text, nil)
+}
+
+func (b *FakeBot) SendReplyWithMarkup(ctx context.Context, replyToMsg *models.Message, text string, markup models.ReplyMarkup) (msg *models.Message) {
+	return b.sendReply("SendReplyWithMarkup", replyToMsg, text, markup)
+}
+
+func (b *FakeBot) AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.calls = append(b.calls, Call{Method: "AnswerCallbackQuery", Text: text})
+	return nil
+}
+
+func (b *FakeBot) EditMessage(ctx context.Context, editableMsg *models.Message, newText string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkFailure("EditMessage"); err != nil {
+		return err
+	}
+	b.calls = append(b.calls, Call{
+		Method:    "EditMessage",
+		ChatID:    editableMsg.Chat.ID,
+		MessageID: editableMsg.ID,
+		Text:      newText,
+	})
+	return nil
+}
+
+func (b *FakeBot) DeleteMessage(ctx context.Context, deletingMessage *models.Message) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkFailure("DeleteMessage"); err != nil {
+		return err
+	}
+	b.calls = append(b.calls, Call{
+		Method:    "DeleteMessage",
+		ChatID:    deletingMessage.Chat.ID,
+		MessageID: deletingMessage.ID,
+	})
+	return nil
+}
+
+func (b *FakeBot) SendTextToAdmins(ctx context.Context, adminUserIds []int64, s string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, chatID := range adminUserIds {
+		b.nextMsgID++
+		b.calls = append(b.calls, Call{
+			Method:    "SendTextToAdmins",
+			ChatID:    chatID,
+			MessageID: b.nextMsgID,
+			Text:      s,
+		})
+	}
+}
+
+// Returns the sent message with the media as a photo or a document. File IDs are made from
+// the message ID.
+func (b *FakeBot) sentMessage(replyToMsg *models.Message, media models.InputMedia, hasSpoiler bool) *models.Message {
+	b.nextMsgID++
+	msg := &models.Message{
+		ID:              b.nextMsgID,
+		Chat:            replyToMsg.Chat,
+		HasMediaSpoiler: hasSpoiler,
+	}
+	if _, ok := media.(*models.InputMediaDocument); ok {
+		msg.Document = &models.Document{FileID: fmt.Sprint("document-", msg.ID)}
+	} else {
+		msg.Photo = []models.PhotoSize{{FileID: fmt.Sprint("photo-", msg.ID)}}
+	}
+	return msg
+}
+
+// Media group items get consecutive message IDs, the call is recorded with the first one.
+func (b *FakeBot) SendMediaGroup(ctx context.Context, replyToMsg *models.Message, media []models.InputMedia) ([]*models.Message, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkFailure("SendMediaGroup"); err != nil {
+		return nil, err
+	}
+	var msgs []*models.Message
+	for _, m := range media {
+		msgs = append(msgs, b.sentMessage(replyToMsg, m, false))
+	}
+	b.calls = append(b.calls, Call{
+		Method:    "SendMediaGroup",
+		ChatID:    replyToMsg.Chat.ID,
+		MessageID: msgs[0].ID,
+		ReplyToID: replyToMsg.ID,
+		Media:     media,
+	})
+	return msgs, nil
+}
+
+// Single photos and documents are recorded with one media item, the same way as media groups.
+func (b *FakeBot) sendSingle(method string, replyToMsg *models.Message, caption string, media models.InputMedia, hasSpoiler bool) (*models.Message, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if err := b.checkFailure(method); err != nil {
+		return nil, err
+	}
+	msg := b.sentMessage(replyToMsg, media, hasSpoiler)
+	b.calls = append(b.calls, Call{
+		Method:    method,
+		ChatID:    replyToMsg.Chat.ID,
+		MessageID: msg.ID,
+		ReplyToID: replyToMsg.ID,
+		Text:      caption,
+		Media:     []models.InputMedia{media},
+		Spoiler:   hasSpoiler,
+	})
+	return msg, nil
+}
+
+func (b *FakeBot) SendPhoto(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string, hasSpoiler bool) (*models.Message, error) {
+	return b.sendSingle("SendPhoto", replyToMsg, caption, &models.InputMediaPhoto{
+		Media:           "attach://" + filename,
+		MediaAttachment: bytes.NewReader(data),
+		Caption:         caption,
+	}, hasSpoiler)
+}
+
+func (b *FakeBot) SendDocument(ctx context.Context, replyToMsg *models.Message, filename string, data []byte, caption string) (*models.Message, error) {
+	return b.sendSingle("SendDocument", replyToMsg, caption, &models.InputMediaDocument{
+		Media:           "attach://" + filename,
+		MediaAttachment: bytes.NewReader(data),
+		Caption:         caption,
+	}, false)
+}
+
+// The button text is recorded as the call text.
+func (b *FakeBot) AnswerInlineQuery(ctx context.Context, inlineQueryID string, results []models.InlineQueryResult, button *models.InlineQueryResultsButton) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	call := Call{
+		Method:        "AnswerInlineQuery",
+		InlineResults: results,
+	}
+	if button != nil {
+		call.Text = button.Text
+	}
+	b.calls = append(b.calls, call)
+	return nil
+}
+
+// Records the commands, ChatID is set for chat scopes.
+func (b *FakeBot) SetMyCommands(ctx context.Context, commands []models.BotCommand, scope models.BotCommandScope, languageCode string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	call := Call{
+		Method:   "SetMyCommands",
+		Text:     languageCode,
+		Commands: commands,
+	}
+	if chatScope, ok := scope.(*models.BotCommandScopeChat); ok {
+		call.ChatID, _ = chatScope.ChatID.(int64)
+	}
+	b.calls = append(b.calls, call)
+	return nil
+}
+
+func (b *FakeBot) Username() string {
+	return BotUsername
+}
+
+func (b *FakeBot) IsChatAdmin(ctx context.Context, chatID int64, userID int64) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return slices.Contains(b.ChatAdmins, userID), nil
+}
+
+func (b *FakeBot) GetFile(ctx context.Context, fileId string, getWriterFunc func(fileSize int64) io.Writer) (d []byte, err error) {
+	b.mutex.Lock()
+	if err = b.checkFailure("GetFile"); err != nil {
+		b.mutex.Unlock()
+		return nil, err
+	}
+	d, ok := b.Files[fileId]
+	b.calls = append(b.calls, Call{Method: "GetFile", Text: fileId})
+	b.mutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("file not found")
+	}
+	_, err = getWriterFunc(int64(len(d))).Write(d)
+	return d, err
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+)
+
+// Returns the Levenshtein edit distance between the given strings.
+func EditDistance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Returns at most maxResults candidates which are similar to s, the most similar first.
+// Comparison is case-insensitive.
+func ClosestMatches(s string, candidates []string, maxResults int) (res []string) {
+	type match struct {
+		candidate string
+		distance  int
+	}
+	var matches []match
+	sLower := strings.ToLower(s)
+	maxDistance := max(2, len([]rune(s))/3)
+	for _, c := range candidates {
+		cLower := strings.ToLower(c)
+		d := EditDistance(sLower, cLower)
+		if d > maxDistance && !strings.Contains(cLower, sLower) {
+			continue
+		}
+		matches = append(matches, match{candidate: c, distance: d})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+	for i := 0; i < len(matches) && i < maxResults; i++ {
+		res = append(res, matches[i].candidate)
+	}
+	return
+}